@@ -65,6 +65,8 @@ func NewACLMiddleware(manager ladon.Manager) (chain.SecurityChainMiddleware, err
 			"organizations": authObj.Organizations,
 			"userId":        authObj.UserID,
 			"username":      authObj.Username,
+			"actor":         actorSubject(authObj),
+			"clientId":      authObj.ClientID,
 		}
 
 		aclRequest := ladon.Request{
@@ -83,6 +85,15 @@ func NewACLMiddleware(manager ladon.Manager) (chain.SecurityChainMiddleware, err
 	}, nil
 }
 
+// actorSubject returns the subject of the actor acting on behalf of the user, or an empty string if the
+// request is not delegated.
+func actorSubject(authObj *auth.Auth) string {
+	if authObj.Actor == nil {
+		return ""
+	}
+	return authObj.Actor.Subject
+}
+
 // APIReadAction is "api:read". All HTTP methods except for POST, PUT, PATCH and DELETE are considered to be "api:read" action.
 const APIReadAction = "api:read"
 
@@ -139,6 +150,8 @@ func CheckRequest(ctx context.Context, req *RequestContext) error {
 	cx["roles"] = req.Auth.Roles
 	cx["organizations"] = req.Auth.Organizations
 	cx["scopes"] = req.Scopes
+	cx["actor"] = actorSubject(req.Auth)
+	cx["clientId"] = req.Auth.ClientID
 
 	warden, err := getLadonWarden(ctx)
 	if err != nil {
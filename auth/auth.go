@@ -30,6 +30,38 @@ type Auth struct {
 
 	// Namespaces is the list of namespaces that this user belongs to.
 	Namespaces []string `json:"namespaces"`
+
+	// Actor is the party (usually a service) acting on behalf of the user, if the request is made with
+	// a delegated token (see the "act" claim of RFC 8693). Nil if the user is making the request directly.
+	Actor *Actor `json:"actor,omitempty"`
+
+	// ClientID is the client ID of a service (machine-to-machine) identity, for example a client authenticated
+	// with the client credentials grant. Empty for users.
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// Actor is the identity of a party acting on behalf of another party (RFC 8693 section 4.1).
+type Actor struct {
+	// Subject is the identifier of the actor (the client ID for services).
+	Subject string `json:"sub"`
+
+	// Actor is the prior actor in the delegation chain, if the token has been delegated more than once.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// ServiceRole is the role of the Auth of a service (machine-to-machine) identity, so ACL policies can grant
+// access to all services. The role alone does not make a service identity, see IsService.
+const ServiceRole = "service"
+
+// IsService checks whether the Auth represents a service identity: a client (ClientID) acting for itself, with no
+// user. The roles are not checked, so a user with the ServiceRole is not a service identity.
+func (a *Auth) IsService() bool {
+	return a.ClientID != "" && a.UserID == ""
+}
+
+// IsDelegated checks whether the Auth is delegated to an actor (the request is made on behalf of the user).
+func (a *Auth) IsDelegated() bool {
+	return a.Actor != nil
 }
 
 // SecurityErrors holds the errors generated during validation of the request with a
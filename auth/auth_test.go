@@ -136,3 +136,18 @@ func TestSetSecurityError(t *testing.T) {
 		t.Fatal("Expected to find a JWT error.")
 	}
 }
+
+func TestIsService(t *testing.T) {
+	if (&Auth{UserID: "10001", Roles: []string{"user"}}).IsService() {
+		t.Fatal("Expected user not to be a service identity")
+	}
+	if (&Auth{UserID: "10001", Roles: []string{"user", ServiceRole}}).IsService() {
+		t.Fatal("Expected user with the service role not to be a service identity")
+	}
+	if (&Auth{UserID: "10001", ClientID: "001"}).IsService() {
+		t.Fatal("Expected user of a client not to be a service identity")
+	}
+	if !(&Auth{ClientID: "001", Roles: []string{ServiceRole}}).IsService() {
+		t.Fatal("Expected a service identity")
+	}
+}
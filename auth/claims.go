@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClaimMapper maps the claims of a token (usually a JWT) to an Auth object.
+// Each field holds the path of the claim that is mapped to the Auth field with the same name.
+// Nested claims are referenced with a dot-separated path, for example "realm_access.roles".
+// If a path is empty, the default claim name is used (the JSON name of the Auth field, like
+// "userId" or "roles"). Use "-" to skip mapping of a field.
+//
+// List fields (Roles, Organizations, Namespaces) accept both JSON arrays of strings and strings
+// with delimited values.
+type ClaimMapper struct {
+	// UserID is the path of the claim mapped to Auth.UserID.
+	UserID string `json:"userId,omitempty"`
+
+	// CustomerID is the path of the claim mapped to Auth.CustomerID.
+	CustomerID string `json:"customerID,omitempty"`
+
+	// Username is the path of the claim mapped to Auth.Username.
+	Username string `json:"username,omitempty"`
+
+	// Fullname is the path of the claim mapped to Auth.Fullname.
+	Fullname string `json:"fullname,omitempty"`
+
+	// Email is the path of the claim mapped to Auth.Email.
+	Email string `json:"email,omitempty"`
+
+	// Roles is the path of the claim mapped to Auth.Roles.
+	Roles string `json:"roles,omitempty"`
+
+	// Organizations is the path of the claim mapped to Auth.Organizations.
+	Organizations string `json:"organizations,omitempty"`
+
+	// Namespaces is the path of the claim mapped to Auth.Namespaces.
+	Namespaces string `json:"namespaces,omitempty"`
+
+	// Actor is the path of the claim mapped to Auth.Actor. Defaults to the "act" claim (RFC 8693).
+	Actor string `json:"actor,omitempty"`
+
+	// ClientID is the path of the claim mapped to Auth.ClientID. Defaults to the "client_id" claim (RFC 9068).
+	ClientID string `json:"clientId,omitempty"`
+
+	// Delimiter is the delimiter of the values in list claims given as a string. Defaults to ",".
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Required is the list of Auth fields (by their JSON name, for example "userId") that must be
+	// present in the claims. The fields are not required for service identities (see Auth.IsService),
+	// which have no user.
+	Required []string `json:"required,omitempty"`
+}
+
+// ClaimValidationError is returned by the ClaimMapper when a claim is missing or has
+// an unexpected type.
+type ClaimValidationError struct {
+	// Claim is the path of the invalid claim.
+	Claim string
+
+	// Message describes the validation failure.
+	Message string
+}
+
+// Error returns the validation error message.
+func (e *ClaimValidationError) Error() string {
+	return fmt.Sprintf("invalid claim %s: %s", e.Claim, e.Message)
+}
+
+// MapClaims creates an Auth object from the given claims.
+// A ClaimValidationError is returned if a required claim is missing or a claim has a type that
+// cannot be mapped to the Auth field.
+func (m *ClaimMapper) MapClaims(claims map[string]interface{}) (*Auth, error) {
+	authObj := &Auth{}
+	var err error
+
+	if authObj.UserID, err = m.stringClaim(claims, "userId"); err != nil {
+		return nil, err
+	}
+	if authObj.Username, err = m.stringClaim(claims, "username"); err != nil {
+		return nil, err
+	}
+	if authObj.Fullname, err = m.stringClaim(claims, "fullname"); err != nil {
+		return nil, err
+	}
+	if authObj.Email, err = m.stringClaim(claims, "email"); err != nil {
+		return nil, err
+	}
+	if authObj.CustomerID, err = m.numberClaim(claims, "customerID"); err != nil {
+		return nil, err
+	}
+	if authObj.Roles, err = m.listClaim(claims, "roles"); err != nil {
+		return nil, err
+	}
+	if authObj.Roles == nil {
+		authObj.Roles = []string{}
+	}
+	if authObj.Organizations, err = m.listClaim(claims, "organizations"); err != nil {
+		return nil, err
+	}
+	if authObj.Namespaces, err = m.listClaim(claims, "namespaces"); err != nil {
+		return nil, err
+	}
+	if authObj.Actor, err = m.actorClaim(claims); err != nil {
+		return nil, err
+	}
+	if authObj.ClientID, err = m.stringClaim(claims, "clientId"); err != nil {
+		return nil, err
+	}
+
+	for _, field := range m.Required {
+		claim := m.claimPath(field)
+		if claim == "" {
+			return nil, fmt.Errorf("unknown required field %s", field)
+		}
+		if authObj.IsService() {
+			continue
+		}
+		if value, ok := LookupClaim(claims, claim); !ok || value == nil {
+			return nil, &ClaimValidationError{Claim: claim, Message: "claim is missing"}
+		}
+	}
+
+	return authObj, nil
+}
+
+// WithDefaults returns a copy of the ClaimMapper merged over the defaults: the claim paths and the delimiter that
+// are not set are taken from the defaults, and the fields required by the defaults stay required.
+func (m *ClaimMapper) WithDefaults(defaults *ClaimMapper) *ClaimMapper {
+	merged := *m
+	for _, field := range []struct{ value, defaultValue *string }{
+		{&merged.UserID, &defaults.UserID},
+		{&merged.CustomerID, &defaults.CustomerID},
+		{&merged.Username, &defaults.Username},
+		{&merged.Fullname, &defaults.Fullname},
+		{&merged.Email, &defaults.Email},
+		{&merged.Roles, &defaults.Roles},
+		{&merged.Organizations, &defaults.Organizations},
+		{&merged.Namespaces, &defaults.Namespaces},
+		{&merged.Actor, &defaults.Actor},
+		{&merged.ClientID, &defaults.ClientID},
+		{&merged.Delimiter, &defaults.Delimiter},
+	} {
+		if *field.value == "" {
+			*field.value = *field.defaultValue
+		}
+	}
+
+	merged.Required = []string{}
+	for _, field := range append(append([]string{}, defaults.Required...), m.Required...) {
+		if !containsField(merged.Required, field) {
+			merged.Required = append(merged.Required, field)
+		}
+	}
+	return &merged
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// claimPath returns the configured claim path for the Auth field with the given JSON name.
+func (m *ClaimMapper) claimPath(field string) string {
+	var claim string
+	switch field {
+	case "userId":
+		claim = m.UserID
+	case "customerID":
+		claim = m.CustomerID
+	case "username":
+		claim = m.Username
+	case "fullname":
+		claim = m.Fullname
+	case "email":
+		claim = m.Email
+	case "roles":
+		claim = m.Roles
+	case "organizations":
+		claim = m.Organizations
+	case "namespaces":
+		claim = m.Namespaces
+	case "actor":
+		claim = m.Actor
+		if claim == "" {
+			return "act"
+		}
+	case "clientId":
+		claim = m.ClientID
+		if claim == "" {
+			return "client_id"
+		}
+	default:
+		return ""
+	}
+	if claim == "" {
+		return field
+	}
+	return claim
+}
+
+func (m *ClaimMapper) stringClaim(claims map[string]interface{}, field string) (string, error) {
+	claim := m.claimPath(field)
+	value, ok := LookupClaim(claims, claim)
+	if claim == "-" || !ok || value == nil {
+		return "", nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", &ClaimValidationError{Claim: claim, Message: fmt.Sprintf("expected string, got %T", value)}
+}
+
+func (m *ClaimMapper) numberClaim(claims map[string]interface{}, field string) (float64, error) {
+	claim := m.claimPath(field)
+	value, ok := LookupClaim(claims, claim)
+	if claim == "-" || !ok || value == nil {
+		return 0, nil
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, &ClaimValidationError{Claim: claim, Message: fmt.Sprintf("expected number, got %q", v)}
+		}
+		return number, nil
+	}
+	return 0, &ClaimValidationError{Claim: claim, Message: fmt.Sprintf("expected number, got %T", value)}
+}
+
+func (m *ClaimMapper) listClaim(claims map[string]interface{}, field string) ([]string, error) {
+	claim := m.claimPath(field)
+	value, ok := LookupClaim(claims, claim)
+	if claim == "-" || !ok || value == nil {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		delimiter := m.Delimiter
+		if delimiter == "" {
+			delimiter = ","
+		}
+		values := []string{}
+		for _, item := range strings.Split(v, delimiter) {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, &ClaimValidationError{Claim: claim, Message: fmt.Sprintf("expected list of strings, got item of type %T", item)}
+			}
+			values = append(values, str)
+		}
+		return values, nil
+	}
+	return nil, &ClaimValidationError{Claim: claim, Message: fmt.Sprintf("expected list or string, got %T", value)}
+}
+
+func (m *ClaimMapper) actorClaim(claims map[string]interface{}) (*Actor, error) {
+	claim := m.claimPath("actor")
+	value, ok := LookupClaim(claims, claim)
+	if claim == "-" || !ok || value == nil {
+		return nil, nil
+	}
+	actor, err := parseActor(value)
+	if err != nil {
+		return nil, &ClaimValidationError{Claim: claim, Message: err.Error()}
+	}
+	return actor, nil
+}
+
+// parseActor parses the (possibly nested) "act" claim.
+func parseActor(value interface{}) (*Actor, error) {
+	act, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected object, got %T", value)
+	}
+	subject, ok := act["sub"].(string)
+	if !ok || subject == "" {
+		return nil, fmt.Errorf("the actor has no subject")
+	}
+	actor := &Actor{Subject: subject}
+	if prior, ok := act["act"]; ok && prior != nil {
+		var err error
+		if actor.Actor, err = parseActor(prior); err != nil {
+			return nil, err
+		}
+	}
+	return actor, nil
+}
+
+// LookupClaim looks up a claim by its path. The path may reference nested claims with
+// dot-separated names (for example "realm_access.roles"). A claim whose name contains
+// dots (for example "https://example.com/roles") is matched first by its full name.
+func LookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+	for i := strings.Index(path, "."); i > 0; i = nextDot(path, i) {
+		nested, ok := claims[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := LookupClaim(nested, path[i+1:]); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func nextDot(path string, from int) int {
+	next := strings.Index(path[from+1:], ".")
+	if next < 0 {
+		return -1
+	}
+	return from + 1 + next
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func parseClaims(t *testing.T, claimsJSON string) map[string]interface{} {
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(claimsJSON), &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestMapClaimsDefault(t *testing.T) {
+	claims := parseClaims(t, `{
+		"userId": "10001",
+		"username": "user",
+		"email": "user@example.com",
+		"customerID": 42,
+		"roles": "user, admin",
+		"organizations": ["org1", "org2"]
+	}`)
+
+	authObj, err := (&ClaimMapper{}).MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.UserID != "10001" || authObj.Username != "user" || authObj.Email != "user@example.com" {
+		t.Fatal("Expected the string claims to be mapped")
+	}
+	if authObj.CustomerID != 42 {
+		t.Fatal("Expected customerID to be mapped")
+	}
+	if len(authObj.Roles) != 2 || authObj.Roles[0] != "user" || authObj.Roles[1] != "admin" {
+		t.Fatal("Expected roles to be parsed from the delimited string, got", authObj.Roles)
+	}
+	if len(authObj.Organizations) != 2 || authObj.Organizations[1] != "org2" {
+		t.Fatal("Expected organizations to be parsed from the array, got", authObj.Organizations)
+	}
+	if authObj.Namespaces != nil {
+		t.Fatal("Expected namespaces to be empty")
+	}
+}
+
+func TestMapClaimsNested(t *testing.T) {
+	claims := parseClaims(t, `{
+		"sub": "f77fc7b6-faa4-4c64-b18c-934ba3f913dd",
+		"preferred_username": "user",
+		"realm_access": {"roles": ["offline_access", "user"]},
+		"https://example.com/orgs": "org1 org2"
+	}`)
+
+	mapper := &ClaimMapper{
+		UserID:        "sub",
+		Username:      "preferred_username",
+		Roles:         "realm_access.roles",
+		Organizations: "https://example.com/orgs",
+		Delimiter:     " ",
+		Required:      []string{"userId", "roles"},
+	}
+
+	authObj, err := mapper.MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.UserID != "f77fc7b6-faa4-4c64-b18c-934ba3f913dd" || authObj.Username != "user" {
+		t.Fatal("Expected userId and username to be mapped")
+	}
+	if len(authObj.Roles) != 2 || authObj.Roles[1] != "user" {
+		t.Fatal("Expected nested roles to be mapped, got", authObj.Roles)
+	}
+	if len(authObj.Organizations) != 2 || authObj.Organizations[0] != "org1" {
+		t.Fatal("Expected organizations to be mapped from a claim with dots in the name, got", authObj.Organizations)
+	}
+}
+
+func TestMapClaimsSkipField(t *testing.T) {
+	claims := parseClaims(t, `{"userId": "10001", "roles": {"unexpected": true}}`)
+
+	authObj, err := (&ClaimMapper{Roles: "-"}).MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.Roles == nil || len(authObj.Roles) != 0 {
+		t.Fatal("Expected roles not to be mapped")
+	}
+}
+
+func TestMapClaimsActor(t *testing.T) {
+	claims := parseClaims(t, `{
+		"userId": "10001",
+		"act": {"sub": "service-b", "act": {"sub": "service-a"}}
+	}`)
+
+	authObj, err := (&ClaimMapper{}).MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authObj.IsDelegated() || authObj.Actor.Subject != "service-b" {
+		t.Fatal("Expected the actor to be mapped", authObj.Actor)
+	}
+	if authObj.Actor.Actor == nil || authObj.Actor.Actor.Subject != "service-a" || authObj.Actor.Actor.Actor != nil {
+		t.Fatal("Expected the prior actor to be mapped", authObj.Actor.Actor)
+	}
+
+	authObj, err = (&ClaimMapper{Actor: "-"}).MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.IsDelegated() {
+		t.Fatal("Expected the actor not to be mapped")
+	}
+}
+
+func TestMapClaimsService(t *testing.T) {
+	mapper := &ClaimMapper{Required: []string{"userId", "username"}}
+	authObj, err := mapper.MapClaims(parseClaims(t, `{"client_id": "orders-service", "roles": "service"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.ClientID != "orders-service" || !authObj.IsService() {
+		t.Fatal("Expected a service identity", authObj)
+	}
+
+	authObj, err = mapper.MapClaims(parseClaims(t, `{"userId": "10001", "username": "user", "client_id": "001"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.IsService() {
+		t.Fatal("Expected a user, not a service identity", authObj)
+	}
+}
+
+func TestMapClaimsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		mapper *ClaimMapper
+		claims string
+		claim  string
+	}{
+		{"missing required", &ClaimMapper{Required: []string{"userId"}}, `{"username": "user"}`, "userId"},
+		{"missing required with client", &ClaimMapper{Required: []string{"username"}}, `{"userId": "10001", "client_id": "001"}`, "username"},
+		{"missing nested", &ClaimMapper{Roles: "realm_access.roles", Required: []string{"roles"}}, `{"realm_access": {}}`, "realm_access.roles"},
+		{"string type", &ClaimMapper{}, `{"userId": ["10001"]}`, "userId"},
+		{"number type", &ClaimMapper{}, `{"customerID": "abc"}`, "customerID"},
+		{"list type", &ClaimMapper{}, `{"roles": 1}`, "roles"},
+		{"list item type", &ClaimMapper{}, `{"roles": ["user", 1]}`, "roles"},
+		{"actor type", &ClaimMapper{}, `{"act": "service-a"}`, "act"},
+		{"actor subject", &ClaimMapper{}, `{"act": {"act": {"sub": "service-a"}}}`, "act"},
+	}
+
+	for _, test := range tests {
+		_, err := test.mapper.MapClaims(parseClaims(t, test.claims))
+		if err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+		validationErr, ok := err.(*ClaimValidationError)
+		if !ok {
+			t.Fatalf("%s: expected ClaimValidationError, got %v", test.name, err)
+		}
+		if validationErr.Claim != test.claim {
+			t.Fatalf("%s: expected error for claim %s, got %s", test.name, test.claim, validationErr.Claim)
+		}
+	}
+}
+
+func TestClaimMapperWithDefaults(t *testing.T) {
+	defaults := &ClaimMapper{
+		UserID:   "sub",
+		Email:    "email",
+		Required: []string{"userId", "username"},
+	}
+	mapper := (&ClaimMapper{
+		Roles:    "realm_access.roles",
+		Email:    "mail",
+		Required: []string{"roles", "userId"},
+	}).WithDefaults(defaults)
+
+	if mapper.UserID != "sub" || mapper.Email != "mail" || mapper.Roles != "realm_access.roles" {
+		t.Fatal("Expected the configured claim paths merged over the defaults", mapper)
+	}
+	if len(mapper.Required) != 3 || mapper.Required[0] != "userId" || mapper.Required[1] != "username" || mapper.Required[2] != "roles" {
+		t.Fatal("Expected the required fields of the defaults to stay required", mapper.Required)
+	}
+
+	_, err := mapper.MapClaims(parseClaims(t, `{"username": "user", "realm_access": {"roles": ["user"]}}`))
+	if _, ok := err.(*ClaimValidationError); !ok {
+		t.Fatal("Expected the token without user to be rejected, got", err)
+	}
+}
@@ -86,9 +86,14 @@ func (chain *Chain) AddMiddlewareType(middlewareType string) (SecurityChain, err
 // Execute executes the security chain by calling all SecurityChainMiddleware in the middleware list in the
 // order as they are added.
 func (chain *Chain) Execute(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, *http.Request, error) {
-	if !chain.preflightCheck(req) {
+	if !chain.preflightCheck(req.Method, req.URL.Path) {
 		return ctx, rw, req, nil
 	}
+	return chain.executeMiddlewares(ctx, rw, req)
+}
+
+// executeMiddlewares calls all SecurityChainMiddleware in the middleware list, without the preflight check.
+func (chain *Chain) executeMiddlewares(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, *http.Request, error) {
 	var err error
 	for _, middleware := range chain.MiddlewareList {
 		ctx, rw, err = middleware(ctx, rw, req)
@@ -99,13 +104,12 @@ func (chain *Chain) Execute(ctx context.Context, rw http.ResponseWriter, req *ht
 	return ctx, rw, req, nil
 }
 
-func (chain *Chain) isRequestIgnoredPattern(req *http.Request) bool {
+func (chain *Chain) isRequestIgnoredPattern(urlPath string) bool {
 	if chain.IgnorePatterns == nil {
 		return false
 	}
-	path := req.URL.Path
 	for _, pattern := range chain.IgnorePatterns {
-		if pattern.MatchString(path) {
+		if pattern.MatchString(urlPath) {
 			return true
 		}
 	}
@@ -125,12 +129,12 @@ func (chain *Chain) AddIgnorePattern(pattern string) error {
 	return nil
 }
 
-func (chain *Chain) preflightCheck(req *http.Request) bool {
+func (chain *Chain) preflightCheck(method, urlPath string) bool {
 	// check HTTP request method
-	if !chain.checkHTTPMethod(req.Method) {
+	if !chain.checkHTTPMethod(method) {
 		return false
 	}
-	if chain.isRequestIgnoredPattern(req) {
+	if chain.isRequestIgnoredPattern(urlPath) {
 		return false
 	}
 	return true
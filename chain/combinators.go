@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/keitaroinc/goa"
+)
+
+// MechanismError describes why a security mechanism did not authenticate the request.
+type MechanismError struct {
+	// Mechanism is the security type under which the mechanism reported the error (ex. "JWT", "OAuth2"),
+	// or the position of the mechanism ("#1", "#2"...) if it did not report a security type.
+	Mechanism string `json:"mechanism"`
+
+	// Error is the error message.
+	Error string `json:"error"`
+}
+
+// FirstOf creates a SecurityChainMiddleware that tries the security mechanisms in order and stops at the
+// first one that authenticates the request (sets auth.Auth in the context).
+// Each mechanism is executed with its own SecurityContext, so the errors of the mechanisms that failed are
+// not mixed with the Auth of the one that succeeded.
+// If no mechanism authenticates the request, ErrAuthRequired is returned with a message listing the error
+// of every mechanism, in the order of the mechanisms. The errors are also available as a list of
+// MechanismError in the "errors" meta value of the goa.ErrorResponse.
+// A BreakChainError returned by a mechanism (for example a redirect to the login page) is returned immediately.
+func FirstOf(mechanisms ...SecurityChainMiddleware) SecurityChainMiddleware {
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		failures := []*MechanismError{}
+		for i, mechanism := range mechanisms {
+			result, err := attempt(i, mechanism, ctx, rw, req)
+			if err != nil {
+				return ctx, rw, err
+			}
+			if result.failures == nil {
+				return result.ctx, result.rw, nil
+			}
+			failures = append(failures, result.failures...)
+		}
+		return ctx, rw, authenticationFailed(failures)
+	}
+}
+
+// AllOf creates a SecurityChainMiddleware that requires all security mechanisms to authenticate the request,
+// for example a client certificate and a JWT. The mechanisms are executed in order, each with its own
+// SecurityContext, and the Auth set by the first mechanism is kept in the context.
+// The execution stops at the first mechanism that does not authenticate the request, and ErrAuthRequired is
+// returned with its error, as for FirstOf.
+func AllOf(mechanisms ...SecurityChainMiddleware) SecurityChainMiddleware {
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		var authObj *auth.Auth
+		for i, mechanism := range mechanisms {
+			result, err := attempt(i, mechanism, ctx, rw, req)
+			if err != nil {
+				return ctx, rw, err
+			}
+			if result.failures != nil {
+				return ctx, rw, authenticationFailed(result.failures)
+			}
+			if authObj == nil {
+				authObj = result.auth
+			}
+			ctx, rw = result.ctx, result.rw
+		}
+		if authObj == nil {
+			return ctx, rw, nil
+		}
+		return auth.SetAuth(ctx, authObj), rw, nil
+	}
+}
+
+// Optional creates a SecurityChainMiddleware that lets the request through when the security mechanism does
+// not authenticate it, for resources that are public but may show more to authenticated users.
+// If the mechanism authenticates the request, the Auth is set in the context. Otherwise the request continues
+// without Auth and the errors of the mechanism are recorded in the auth.SecurityErrors.
+// A BreakChainError returned by the mechanism is returned.
+func Optional(mechanism SecurityChainMiddleware) SecurityChainMiddleware {
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		result, err := attempt(0, mechanism, ctx, rw, req)
+		if err != nil {
+			return ctx, rw, err
+		}
+		if result.failures == nil {
+			return result.ctx, result.rw, nil
+		}
+		for _, failure := range result.failures {
+			ctx = auth.SetSecurityError(ctx, failure.Mechanism, failure.Error)
+		}
+		return ctx, rw, nil
+	}
+}
+
+type attemptResult struct {
+	ctx      context.Context
+	rw       http.ResponseWriter
+	auth     *auth.Auth
+	failures []*MechanismError
+}
+
+// attempt executes the security mechanism with a new SecurityContext. The result holds the Auth set by the
+// mechanism, or the failures if the request was not authenticated. Only a BreakChainError is returned as error.
+func attempt(index int, mechanism SecurityChainMiddleware, ctx context.Context, rw http.ResponseWriter, req *http.Request) (*attemptResult, error) {
+	securityContext := &auth.SecurityContext{
+		Errors: auth.SecurityErrors{},
+	}
+	mCtx, mRw, err := mechanism(context.WithValue(ctx, auth.SecurityContextKey, securityContext), rw, req)
+	if _, ok := err.(*BreakChainError); ok {
+		return nil, err
+	}
+	if mCtx == nil {
+		mCtx = ctx
+	}
+	if mRw == nil {
+		mRw = rw
+	}
+
+	result := &attemptResult{
+		ctx:  mCtx,
+		rw:   mRw,
+		auth: auth.GetAuth(mCtx),
+	}
+	if err == nil && result.auth != nil {
+		return result, nil
+	}
+
+	mechanismName := fmt.Sprintf("#%d", index+1)
+	if err != nil {
+		result.failures = []*MechanismError{{Mechanism: mechanismName, Error: errorMessage(err)}}
+		return result, nil
+	}
+	errors := auth.GetSecurityErrors(mCtx)
+	if errors == nil || len(*errors) == 0 {
+		result.failures = []*MechanismError{{Mechanism: mechanismName, Error: "no authentication"}}
+		return result, nil
+	}
+	securityTypes := []string{}
+	for securityType := range *errors {
+		securityTypes = append(securityTypes, securityType)
+	}
+	sort.Strings(securityTypes)
+	for _, securityType := range securityTypes {
+		result.failures = append(result.failures, &MechanismError{
+			Mechanism: securityType,
+			Error:     errorMessage((*errors)[securityType]),
+		})
+	}
+	return result, nil
+}
+
+// errorMessage returns the message of an error. For goa errors, only the detail is used, without the
+// error ID which is different for every error.
+func errorMessage(err interface{}) string {
+	if goaErr, ok := err.(*goa.ErrorResponse); ok {
+		return goaErr.Detail
+	}
+	return fmt.Sprint(err)
+}
+
+// authenticationFailed creates ErrAuthRequired that lists the errors of the mechanisms.
+func authenticationFailed(failures []*MechanismError) error {
+	messages := make([]string, len(failures))
+	for i, failure := range failures {
+		messages[i] = fmt.Sprintf("%s: %s", failure.Mechanism, failure.Error)
+	}
+	return ErrAuthRequired(fmt.Sprintf("authentication failed: %s", strings.Join(messages, "; ")), "errors", failures)
+}
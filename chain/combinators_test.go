@@ -0,0 +1,144 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/keitaroinc/goa"
+)
+
+func failingMechanism(securityType, message string) SecurityChainMiddleware {
+	return ToSecurityChainMiddleware(securityType, func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return goa.ErrUnauthorized(message)
+		}
+	})
+}
+
+func authenticatingMechanism(securityType, userID string, called *[]string) SecurityChainMiddleware {
+	return ToSecurityChainMiddleware(securityType, func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			*called = append(*called, securityType)
+			return h(auth.SetAuth(ctx, &auth.Auth{UserID: userID}), rw, req)
+		}
+	})
+}
+
+func expectMechanismErrors(t *testing.T, err error, detail string, failures []*MechanismError) {
+	t.Helper()
+	goaErr, ok := err.(*goa.ErrorResponse)
+	if !ok || goaErr.Status != http.StatusUnauthorized {
+		t.Fatal("Expected authentication required error, got", err)
+	}
+	if goaErr.Detail != detail {
+		t.Fatalf("Expected %q, got %q", detail, goaErr.Detail)
+	}
+	if !reflect.DeepEqual(goaErr.Meta["errors"], failures) {
+		t.Fatal("Unexpected mechanism errors", goaErr.Meta["errors"])
+	}
+}
+
+func TestFirstOf(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+	called := []string{}
+
+	ctx, _, err := FirstOf(
+		failingMechanism("JWT", "missing auth header"),
+		authenticatingMechanism("OAuth2", "10001", &called),
+		authenticatingMechanism("SAML", "10002", &called),
+	)(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj := auth.GetAuth(ctx); authObj == nil || authObj.UserID != "10001" {
+		t.Fatal("Expected the Auth of the first successful mechanism", authObj)
+	}
+	if errors := auth.GetSecurityErrors(ctx); errors == nil || len(*errors) != 0 {
+		t.Fatal("Expected the errors of the failed mechanisms not to be mixed in")
+	}
+	if len(called) != 1 {
+		t.Fatal("Expected to stop at the first successful mechanism", called)
+	}
+
+	_, _, err = FirstOf(
+		failingMechanism("OAuth2", "invalid token"),
+		failingMechanism("JWT", "missing auth header"),
+		func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+			return ctx, rw, nil
+		},
+	)(context.Background(), nil, req)
+	expectMechanismErrors(t, err, "authentication failed: OAuth2: invalid token; JWT: missing auth header; #3: no authentication", []*MechanismError{
+		{Mechanism: "OAuth2", Error: "invalid token"},
+		{Mechanism: "JWT", Error: "missing auth header"},
+		{Mechanism: "#3", Error: "no authentication"},
+	})
+
+	_, _, err = FirstOf(
+		failingMechanism("JWT", "missing auth header"),
+		func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+			return ctx, rw, BreakChain("redirect")
+		},
+	)(context.Background(), nil, req)
+	if _, ok := err.(*BreakChainError); !ok {
+		t.Fatal("Expected the BreakChainError to be returned, got", err)
+	}
+}
+
+func TestAllOf(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+	called := []string{}
+
+	ctx, _, err := AllOf(
+		authenticatingMechanism("X509", "10001", &called),
+		authenticatingMechanism("JWT", "10002", &called),
+	)(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj := auth.GetAuth(ctx); authObj == nil || authObj.UserID != "10001" {
+		t.Fatal("Expected the Auth of the first mechanism", authObj)
+	}
+	if !reflect.DeepEqual(called, []string{"X509", "JWT"}) {
+		t.Fatal("Expected all mechanisms to be called", called)
+	}
+
+	called = []string{}
+	_, _, err = AllOf(
+		authenticatingMechanism("X509", "10001", &called),
+		failingMechanism("JWT", "token expired"),
+		authenticatingMechanism("SAML", "10001", &called),
+	)(context.Background(), nil, req)
+	expectMechanismErrors(t, err, "authentication failed: JWT: token expired", []*MechanismError{
+		{Mechanism: "JWT", Error: "token expired"},
+	})
+	if len(called) != 1 {
+		t.Fatal("Expected to stop at the first failed mechanism", called)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+	called := []string{}
+
+	ctx, _, err := Optional(failingMechanism("JWT", "missing auth header"))(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.HasAuth(ctx) {
+		t.Fatal("Expected no Auth")
+	}
+	if errors := auth.GetSecurityErrors(ctx); errors == nil || (*errors)["JWT"] != "missing auth header" {
+		t.Fatal("Expected the error to be recorded")
+	}
+
+	ctx, _, err = Optional(authenticatingMechanism("JWT", "10001", &called))(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj := auth.GetAuth(ctx); authObj == nil || authObj.UserID != "10001" {
+		t.Fatal("Expected the Auth to be set", authObj)
+	}
+}
@@ -0,0 +1,54 @@
+package chain
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/keitaroinc/goa"
+)
+
+// ErrorHandler writes the HTTP response for an error returned by the security chain.
+type ErrorHandler func(rw http.ResponseWriter, req *http.Request, err error)
+
+// AsHTTPMiddleware wraps a SecurityChain as a standard net/http middleware, which can be used with any router.
+// The context resulting from the chain (holding the auth.Auth) is set in the request passed to the next handler.
+// If the chain returns an error, the response is written by the errHandler, or by DefaultErrorHandler if no
+// errHandler is given. If the chain returns a BreakChainError, the middleware that broke the chain has already
+// written the response (for example a redirect to the login page), so nothing else is written.
+func AsHTTPMiddleware(chain SecurityChain, errHandler ...ErrorHandler) func(http.Handler) http.Handler {
+	handleError := DefaultErrorHandler
+	if len(errHandler) > 0 && errHandler[0] != nil {
+		handleError = errHandler[0]
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			ctx, rw, req, err := chain.Execute(req.Context(), rw, req)
+			if err != nil {
+				if _, ok := err.(*BreakChainError); ok {
+					return
+				}
+				handleError(rw, req, err)
+				return
+			}
+			next.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
+
+// DefaultErrorHandler writes the error as a JSON response, in the same format as the goa error responses.
+// The status of goa errors (like ErrAuthRequired or the 403 errors of the ACL middleware) is kept, and 401
+// responses get a "WWW-Authenticate: Bearer" header, unless a middleware has already set it.
+// Any other error is written as 500 Internal Server Error, without the error details.
+func DefaultErrorHandler(rw http.ResponseWriter, req *http.Request, err error) {
+	goaErr, ok := err.(*goa.ErrorResponse)
+	if !ok || goaErr.Status >= http.StatusInternalServerError {
+		goaErr = goa.ErrInternal("internal server error").(*goa.ErrorResponse)
+	}
+
+	if goaErr.Status == http.StatusUnauthorized && rw.Header().Get("WWW-Authenticate") == "" {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(goaErr.Status)
+	json.NewEncoder(rw).Encode(goaErr)
+}
@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/keitaroinc/goa"
+)
+
+func TestAsHTTPMiddleware(t *testing.T) {
+	securityChain := NewSecurityChain()
+	securityChain.AddMiddleware(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		switch req.URL.Path {
+		case "/login":
+			http.Redirect(rw, req, "https://idp.example.com", http.StatusFound)
+			return ctx, rw, BreakChain("redirect to the login page")
+		case "/forbidden":
+			return ctx, rw, goa.NewErrorClass("Unauthorized", 403)("access denied")
+		case "/broken":
+			return ctx, rw, fmt.Errorf("connection refused")
+		case "/anonymous":
+			return ctx, rw, nil
+		}
+		return auth.SetAuth(ctx, &auth.Auth{UserID: "10001"}), rw, nil
+	})
+	securityChain.AddMiddleware(CheckAuth)
+
+	var authObj *auth.Auth
+	handler := AsHTTPMiddleware(securityChain)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authObj = auth.GetAuth(req.Context())
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path            string
+		status          int
+		wwwAuthenticate string
+		code            string
+	}{
+		{"/resource", http.StatusOK, "", ""},
+		{"/anonymous", http.StatusUnauthorized, "Bearer", "authentication-required"},
+		{"/forbidden", http.StatusForbidden, "", "Unauthorized"},
+		{"/broken", http.StatusInternalServerError, "", "internal"},
+		{"/login", http.StatusFound, "", ""},
+	}
+	for _, test := range tests {
+		authObj = nil
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", test.path, nil))
+		if rw.Code != test.status {
+			t.Fatalf("%s: expected status %d, got %d", test.path, test.status, rw.Code)
+		}
+		if rw.Header().Get("WWW-Authenticate") != test.wwwAuthenticate {
+			t.Fatalf("%s: unexpected WWW-Authenticate header %q", test.path, rw.Header().Get("WWW-Authenticate"))
+		}
+		if test.code != "" {
+			response := map[string]interface{}{}
+			if err := json.NewDecoder(rw.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response["code"] != test.code || response["status"] != float64(test.status) {
+				t.Fatalf("%s: unexpected error response %v", test.path, response)
+			}
+			if test.status == http.StatusInternalServerError && response["detail"] != "internal server error" {
+				t.Fatalf("%s: expected the error details to be hidden, got %v", test.path, response["detail"])
+			}
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/resource", nil))
+	if authObj == nil || authObj.UserID != "10001" {
+		t.Fatal("Expected the Auth in the request context", authObj)
+	}
+
+	called := false
+	handler = AsHTTPMiddleware(securityChain, func(rw http.ResponseWriter, req *http.Request, err error) {
+		called = true
+		rw.WriteHeader(http.StatusTeapot)
+	})(http.NotFoundHandler())
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/anonymous", nil))
+	if !called || rw.Code != http.StatusTeapot {
+		t.Fatal("Expected the custom error handler to be called")
+	}
+}
@@ -0,0 +1,280 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Route is a security chain for the requests matching a path pattern and, optionally, a list of HTTP methods.
+// Routes are created with RouterChain.Route.
+// The pattern is a path with segments separated by "/". A "*" segment matches any single segment, and a "*"
+// as the last segment matches any (zero or more) remaining segments - "/api/*" matches "/api", "/api/users"
+// and "/api/users/10001".
+type Route struct {
+	*Chain
+
+	// Pattern is the path pattern of the route.
+	Pattern string
+
+	// Methods is the list of HTTP methods of the route. If empty, the route matches all methods.
+	Methods []string
+
+	// MiddlewareNames holds the names of the middlewares in the chain, used to describe the route. The name of
+	// a middleware added by type is the type, otherwise it is the position of the middleware ("#1", "#2"...).
+	MiddlewareNames []string
+
+	segments []string
+	wildcard bool
+}
+
+// AddMiddleware appends a SecurityChainMiddleware to the route.
+func (route *Route) AddMiddleware(middleware SecurityChainMiddleware) SecurityChain {
+	return route.AddNamedMiddleware(fmt.Sprintf("#%d", len(route.MiddlewareList)+1), middleware)
+}
+
+// AddNamedMiddleware appends a SecurityChainMiddleware to the route, under a name used to describe the route.
+func (route *Route) AddNamedMiddleware(name string, middleware SecurityChainMiddleware) SecurityChain {
+	route.Chain.AddMiddleware(middleware)
+	route.MiddlewareNames = append(route.MiddlewareNames, name)
+	return route
+}
+
+// AddMiddlewareType appends a SecurityChainMiddleware built for the registered security type to the route.
+func (route *Route) AddMiddlewareType(middlewareType string) (SecurityChain, error) {
+	middleware, err := buildSecurityMiddleware(middlewareType)
+	if err != nil {
+		return nil, err
+	}
+	return route.AddNamedMiddleware(middlewareType, middleware), nil
+}
+
+// matches checks if the route matches the request path segments and the HTTP method.
+func (route *Route) matches(pathSegments []string, method string) bool {
+	if len(pathSegments) < len(route.segments) || (!route.wildcard && len(pathSegments) != len(route.segments)) {
+		return false
+	}
+	for i, segment := range route.segments {
+		if segment != "*" && segment != pathSegments[i] {
+			return false
+		}
+	}
+	if len(route.Methods) == 0 {
+		return true
+	}
+	for _, routeMethod := range route.Methods {
+		if routeMethod == method {
+			return true
+		}
+	}
+	return false
+}
+
+// moreSpecific checks if the route is more specific than the other route. The segments are compared from left
+// to right and the first literal segment matched against a "*" segment wins. Otherwise the route with more
+// segments wins, then the route that does not end with a wildcard, and finally the route restricted to HTTP methods.
+func (route *Route) moreSpecific(other *Route) bool {
+	for i := 0; i < len(route.segments) && i < len(other.segments); i++ {
+		wildcard, otherWildcard := route.segments[i] == "*", other.segments[i] == "*"
+		if wildcard != otherWildcard {
+			return otherWildcard
+		}
+	}
+	if len(route.segments) != len(other.segments) {
+		return len(route.segments) > len(other.segments)
+	}
+	if route.wildcard != other.wildcard {
+		return !route.wildcard
+	}
+	return len(route.Methods) > 0 && len(other.Methods) == 0
+}
+
+// RouteDescription describes the effective security chain for a request. See RouterChain.Describe.
+type RouteDescription struct {
+	// Pattern is the pattern of the matched route, or empty if the request is handled by the default chain.
+	Pattern string
+
+	// Methods is the list of HTTP methods of the matched route.
+	Methods []string
+
+	// Ignored is set if the request is ignored (passed through) by the security chain.
+	Ignored bool
+
+	// Middlewares is the list of names of the middlewares executed for the request.
+	Middlewares []string
+}
+
+// String returns a readable description of the chain, for example "/admin/* [GET POST]: SAML, ACL".
+func (d *RouteDescription) String() string {
+	if d.Ignored {
+		return "ignored"
+	}
+	route := d.Pattern
+	if route == "" {
+		route = "default"
+	}
+	if len(d.Methods) > 0 {
+		route = fmt.Sprintf("%s %v", route, d.Methods)
+	}
+	if len(d.Middlewares) == 0 {
+		return route + ": no middlewares"
+	}
+	return route + ": " + strings.Join(d.Middlewares, ", ")
+}
+
+// RouterChain is a SecurityChain that executes different security chains for different paths and HTTP methods.
+// For every request the most specific matching Route is executed, for example "/admin/*" may require SAML and
+// ACL, "/api/*" a JWT, while "/metrics" is open. Literal segments are more specific than "*" segments, from left
+// to right, so "/api/users/*" is preferred over "/api/*/settings", and "/api/users" over "/api/*".
+// The requests that match no route are handled by the Default route, to which the middlewares added directly to
+// the RouterChain are added. The ignore patterns and the ignored HTTP methods of the RouterChain apply to all
+// routes, while the ones added to a Route apply only to that route.
+type RouterChain struct {
+	// Default is the security chain for the requests that match no route.
+	Default *Route
+
+	// Routes is the list of routes, in the order as they are added.
+	Routes []*Route
+
+	preflight *Chain
+}
+
+// NewRouterChain creates a new RouterChain with no routes.
+func NewRouterChain() *RouterChain {
+	return &RouterChain{
+		Default: &Route{
+			Chain: NewSecurityChain().(*Chain),
+		},
+		Routes:    []*Route{},
+		preflight: NewSecurityChain().(*Chain),
+	}
+}
+
+// Route adds a route for the path pattern and the HTTP methods (all methods if none are given) and returns it,
+// so the middlewares for the route can be added to it. The pattern must start with "/". If a route with the
+// same pattern and methods already exists, it is returned.
+func (router *RouterChain) Route(pattern string, methods ...string) (*Route, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("the route pattern %s must start with /", pattern)
+	}
+	segments := splitPath(pattern)
+	wildcard := len(segments) > 0 && segments[len(segments)-1] == "*"
+	if wildcard {
+		segments = segments[:len(segments)-1]
+	}
+	routeMethods := make([]string, len(methods))
+	for i, method := range methods {
+		routeMethods[i] = strings.ToUpper(method)
+	}
+
+	for _, route := range router.Routes {
+		if route.Pattern == pattern && strings.Join(route.Methods, " ") == strings.Join(routeMethods, " ") {
+			return route, nil
+		}
+	}
+	route := &Route{
+		Chain:    NewSecurityChain().(*Chain),
+		Pattern:  pattern,
+		Methods:  routeMethods,
+		segments: segments,
+		wildcard: wildcard,
+	}
+	router.Routes = append(router.Routes, route)
+	return route, nil
+}
+
+// Match returns the most specific route matching the request, or the Default route if no route matches.
+// If several routes are equally specific, the one added first is returned. The request path is cleaned
+// (see path.Clean) before matching, so "/api/../admin/users" matches the routes for "/admin/users".
+func (router *RouterChain) Match(req *http.Request) *Route {
+	return router.match(cleanPath(req.URL.Path), req.Method)
+}
+
+// match returns the most specific route matching the cleaned path and the HTTP method.
+func (router *RouterChain) match(urlPath, method string) *Route {
+	pathSegments := splitPath(urlPath)
+	var matched *Route
+	for _, route := range router.Routes {
+		if route.matches(pathSegments, method) && (matched == nil || route.moreSpecific(matched)) {
+			matched = route
+		}
+	}
+	if matched == nil {
+		return router.Default
+	}
+	return matched
+}
+
+// Describe describes the effective security chain for the request - the matched route and the names of
+// its middlewares, or that the request is ignored.
+func (router *RouterChain) Describe(req *http.Request) *RouteDescription {
+	urlPath := cleanPath(req.URL.Path)
+	route := router.match(urlPath, req.Method)
+	if !router.preflight.preflightCheck(req.Method, urlPath) || !route.preflightCheck(req.Method, urlPath) {
+		return &RouteDescription{
+			Pattern: route.Pattern,
+			Methods: route.Methods,
+			Ignored: true,
+		}
+	}
+	return &RouteDescription{
+		Pattern:     route.Pattern,
+		Methods:     route.Methods,
+		Middlewares: route.MiddlewareNames,
+	}
+}
+
+// AddMiddleware adds the SecurityChainMiddleware to the Default route.
+func (router *RouterChain) AddMiddleware(middleware SecurityChainMiddleware) SecurityChain {
+	router.Default.AddMiddleware(middleware)
+	return router
+}
+
+// AddMiddlewareType adds the SecurityChainMiddleware for the registered security type to the Default route.
+func (router *RouterChain) AddMiddlewareType(middlewareType string) (SecurityChain, error) {
+	if _, err := router.Default.AddMiddlewareType(middlewareType); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Execute executes the security chain of the most specific route matching the request. The ignore patterns
+// are matched against the same cleaned path as the routes.
+func (router *RouterChain) Execute(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, *http.Request, error) {
+	urlPath := cleanPath(req.URL.Path)
+	if !router.preflight.preflightCheck(req.Method, urlPath) {
+		return ctx, rw, req, nil
+	}
+	route := router.match(urlPath, req.Method)
+	if !route.preflightCheck(req.Method, urlPath) {
+		return ctx, rw, req, nil
+	}
+	return route.executeMiddlewares(ctx, rw, req)
+}
+
+// AddIgnorePattern adds an ignore pattern for all routes. See Chain.AddIgnorePattern.
+func (router *RouterChain) AddIgnorePattern(pattern string) error {
+	return router.preflight.AddIgnorePattern(pattern)
+}
+
+// IgnoreHTTPMethod adds an HTTP method to be ignored by all routes.
+func (router *RouterChain) IgnoreHTTPMethod(method string) {
+	router.preflight.IgnoreHTTPMethod(method)
+}
+
+// cleanPath cleans the request path, resolving the "." and ".." segments and removing the empty ones.
+// The cleaned path always starts with "/".
+func cleanPath(urlPath string) string {
+	return path.Clean("/" + urlPath)
+}
+
+// splitPath cleans the path (see cleanPath) and splits it into segments.
+func splitPath(urlPath string) []string {
+	urlPath = strings.Trim(cleanPath(urlPath), "/")
+	if urlPath == "" {
+		return []string{}
+	}
+	return strings.Split(urlPath, "/")
+}
@@ -0,0 +1,115 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func recordingMiddleware(name string, called *[]string) SecurityChainMiddleware {
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		*called = append(*called, name)
+		return ctx, rw, nil
+	}
+}
+
+func TestRouterChainMatch(t *testing.T) {
+	router := NewRouterChain()
+	routes := map[string]*Route{}
+	for _, pattern := range []string{"/api/*", "/api/users/*", "/api/*/settings", "/metrics", "/*"} {
+		route, err := router.Route(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes[pattern] = route
+	}
+	postRoute, _ := router.Route("/api/users/*", "post")
+	if _, err := router.Route("api"); err == nil {
+		t.Fatal("Expected an error for a pattern not starting with /")
+	}
+	if route, _ := router.Route("/metrics"); route != routes["/metrics"] {
+		t.Fatal("Expected the existing route to be returned")
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		route  *Route
+	}{
+		{"GET", "/api", routes["/api/*"]},
+		{"GET", "/api/orders/1", routes["/api/*"]},
+		{"GET", "/api/users", routes["/api/users/*"]},
+		{"GET", "/api/users/10001", routes["/api/users/*"]},
+		{"POST", "/api/users/10001", postRoute},
+		{"GET", "/api/users/settings", routes["/api/users/*"]},
+		{"GET", "/api/orders/settings", routes["/api/*/settings"]},
+		{"GET", "/metrics", routes["/metrics"]},
+		{"GET", "/metrics/cpu", routes["/*"]},
+		{"GET", "/", routes["/*"]},
+		{"GET", "/api/../metrics", routes["/metrics"]},
+		{"GET", "/api/users/../../metrics", routes["/metrics"]},
+		{"GET", "/api/./users//10001", routes["/api/users/*"]},
+		{"GET", "/api/users/%2e%2e/orders/settings", routes["/api/*/settings"]},
+		{"GET", "/../api/users", routes["/api/users/*"]},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		if route := router.Match(req); route != test.route {
+			t.Fatalf("%s %s: expected route %s, got %s", test.method, test.path, test.route.Pattern, route.Pattern)
+		}
+	}
+
+	router = NewRouterChain()
+	router.Route("/api/*")
+	req, _ := http.NewRequest("GET", "http://example.com/metrics", nil)
+	if router.Match(req) != router.Default {
+		t.Fatal("Expected the default route for an unmatched request")
+	}
+}
+
+func TestRouterChainExecute(t *testing.T) {
+	called := []string{}
+	router := NewRouterChain()
+	router.AddMiddleware(recordingMiddleware("default", &called))
+	admin, _ := router.Route("/admin/*")
+	admin.AddNamedMiddleware("SAML", recordingMiddleware("saml", &called))
+	admin.AddNamedMiddleware("ACL", recordingMiddleware("acl", &called))
+	api, _ := router.Route("/api/*")
+	api.AddMiddleware(recordingMiddleware("jwt", &called))
+	api.AddIgnorePattern("^/api/public/")
+	router.Route("/metrics")
+	router.IgnoreHTTPMethod("OPTIONS")
+	router.AddIgnorePattern("^/static/")
+
+	tests := []struct {
+		method      string
+		path        string
+		called      string
+		description string
+	}{
+		{"GET", "/admin/users", "saml,acl", "/admin/*: SAML, ACL"},
+		{"GET", "/api/users", "jwt", "/api/*: #1"},
+		{"GET", "/metrics", "", "/metrics: no middlewares"},
+		{"GET", "/other", "default", "default: #1"},
+		{"OPTIONS", "/admin/users", "", "ignored"},
+		{"GET", "/api/public/docs", "", "ignored"},
+		{"GET", "/api/public/../users", "jwt", "/api/*: #1"},
+		{"GET", "/api//public/docs", "", "ignored"},
+		{"GET", "/static/../admin/users", "saml,acl", "/admin/*: SAML, ACL"},
+		{"GET", "//static/app.js", "", "ignored"},
+	}
+	for _, test := range tests {
+		called = []string{}
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		if _, _, _, err := router.Execute(context.Background(), nil, req); err != nil {
+			t.Fatal(err)
+		}
+		if joined := strings.Join(called, ","); joined != test.called {
+			t.Fatalf("%s %s: expected %q to be called, got %q", test.method, test.path, test.called, joined)
+		}
+		if description := router.Describe(req).String(); description != test.description {
+			t.Fatalf("%s %s: expected description %q, got %q", test.method, test.path, test.description, description)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package flow
+
+import (
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/Microkubes/microservice-security/oidc"
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// ExtendedSecurityConfig holds the security configuration that is not part of config.ServiceConfig.
+// The settings are read from the same service configuration file, as additional properties
+// of the "jwt" and "oauth2" sections, and the "oidc" section of the "security" configuration:
+//
+//	{
+//	  "security": {
+//	    "jwt": {
+//	      "tokenUrl": "http://kong:8000/jwt",
+//	      "claimMapping": {
+//	        "userId": "sub",
+//	        "roles": "realm_access.roles"
+//	      }
+//	    }
+//	  }
+//	}
+type ExtendedSecurityConfig struct {
+	// JWT holds the additional options for the JWT security.
+	JWT *jwt.SecurityOptions `json:"jwt,omitempty"`
+
+	// OAuth2 holds the additional options for the OAuth2 security.
+	OAuth2 *jwt.SecurityOptions `json:"oauth2,omitempty"`
+
+	// OIDC holds the configuration of an external OpenID Provider. If set, the OpenID Connect security is
+	// added to the chain.
+	OIDC *oidc.Config `json:"oidc,omitempty"`
+}
+
+// extendedServiceConfig is the shape of the service configuration file, used to read only the
+// extended security configuration.
+type extendedServiceConfig struct {
+	Security ExtendedSecurityConfig `json:"security"`
+}
+
+// LoadExtendedSecurityConfig loads the extended security configuration from the service
+// configuration file.
+func LoadExtendedSecurityConfig(confFile string) (*ExtendedSecurityConfig, error) {
+	serviceConfig := &extendedServiceConfig{}
+	if err := config.LoadConfigAs(confFile, serviceConfig); err != nil {
+		return nil, err
+	}
+	return &serviceConfig.Security, nil
+}
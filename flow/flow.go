@@ -16,6 +16,7 @@ import (
 	"github.com/Microkubes/microservice-security/chain"
 	"github.com/Microkubes/microservice-security/jwt"
 	"github.com/Microkubes/microservice-security/oauth2"
+	"github.com/Microkubes/microservice-security/oidc"
 	"github.com/Microkubes/microservice-security/saml"
 	"github.com/Microkubes/microservice-tools/config"
 	"github.com/crewjam/saml/samlsp"
@@ -80,6 +81,16 @@ func NewSecurityFromConfig(cfg *config.ServiceConfig) (chain.SecurityChain, Clea
 
 // NewConfiguredSecurityFromConfig sets up a full security from a given service configuration.
 func NewConfiguredSecurityFromConfig(cfg *config.ServiceConfig) (*ConfiguredSecurity, error) {
+	return NewConfiguredSecurityFromExtendedConfig(cfg, nil)
+}
+
+// NewConfiguredSecurityFromExtendedConfig sets up a full security from a given service configuration
+// and the extended security configuration (see LoadExtendedSecurityConfig). The extended configuration
+// may be nil.
+func NewConfiguredSecurityFromExtendedConfig(cfg *config.ServiceConfig, extCfg *ExtendedSecurityConfig) (*ConfiguredSecurity, error) {
+	if extCfg == nil {
+		extCfg = &ExtendedSecurityConfig{}
+	}
 	configuredSecurity := &ConfiguredSecurity{}
 	securityChain := chain.NewSecurityChain()
 
@@ -133,7 +144,7 @@ func NewConfiguredSecurityFromConfig(cfg *config.ServiceConfig) (*ConfiguredSecu
 			},
 		}
 
-		jwtMiddleware := jwt.NewJWTSecurity(cfg.SecurityConfig.KeysDir, jwtSpec)
+		jwtMiddleware := jwt.NewJWTSecurityWithOptions(cfg.SecurityConfig.KeysDir, jwtSpec, extCfg.JWT)
 		securityChain.AddMiddleware(jwtMiddleware)
 	}
 
@@ -149,10 +160,17 @@ func NewConfiguredSecurityFromConfig(cfg *config.ServiceConfig) (*ConfiguredSecu
 			},
 		}
 
-		oauth2Middleware := oauth2.NewOAuth2Security(cfg.SecurityConfig.KeysDir, oauth2Spec)
+		oauth2Middleware := oauth2.NewOAuth2SecurityWithOptions(cfg.SecurityConfig.KeysDir, oauth2Spec, extCfg.OAuth2)
 		securityChain.AddMiddleware(oauth2Middleware)
 	}
 
+	if extCfg.OIDC != nil {
+		if err := extCfg.OIDC.Validate(); err != nil {
+			return nil, err
+		}
+		securityChain.AddMiddleware(oidc.NewOIDCSecurity(extCfg.OIDC))
+	}
+
 	if cfg.SecurityConfig.SAMLConfig != nil {
 		samlMiddleware, spMiddleware, err := newSAMLSecurity(cfg.GatewayURL, cfg.SAMLConfig)
 		if err != nil {
@@ -170,7 +188,8 @@ func NewConfiguredSecurityFromConfig(cfg *config.ServiceConfig) (*ConfiguredSecu
 
 	if cfg.SecurityConfig.JWTConfig == nil &&
 		cfg.SecurityConfig.OAuth2Config == nil &&
-		cfg.SecurityConfig.SAMLConfig == nil {
+		cfg.SecurityConfig.SAMLConfig == nil &&
+		extCfg.OIDC == nil {
 		// No security defined
 		return configuredSecurity, nil
 
@@ -0,0 +1,163 @@
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Microkubes/microservice-security/chain"
+	"github.com/keitaroinc/goa"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Options holds the options of the gRPC interceptors.
+type Options struct {
+	// ReadMethods is the list of full names of the gRPC methods that only read data ("/package.Service/Method").
+	// They are presented to the security chain as GET requests, so the ACL checks them as the "api:read" action.
+	// All other methods are presented as POST requests ("api:write" action).
+	ReadMethods []string
+
+	// RequiredScopes maps the full names of the gRPC methods to the scopes required to call them. The scopes are
+	// checked by the JWT, OAuth2 and token introspection middlewares, the same way as the scopes of a goa action.
+	RequiredScopes map[string][]string
+}
+
+// NewUnaryServerInterceptor creates a gRPC unary server interceptor that executes the security chain for every call.
+// The call is presented to the chain as an HTTP request (see NewRequest), so the same middlewares protect the
+// HTTP and the gRPC services: the JWT and OAuth2 middlewares read the bearer token from the "authorization"
+// metadata, and the ACL middleware uses the full gRPC method name as the resource.
+// The context resulting from the chain (holding the auth.Auth) is passed to the handler. The errors of the
+// chain are converted to gRPC status errors with StatusError.
+func NewUnaryServerInterceptor(securityChain chain.SecurityChain, options *Options) grpcgo.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpcgo.UnaryServerInfo, handler grpcgo.UnaryHandler) (interface{}, error) {
+		ctx, err := executeChain(ctx, securityChain, info.FullMethod, options)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewStreamServerInterceptor creates a gRPC stream server interceptor that executes the security chain when the
+// stream is opened, like NewUnaryServerInterceptor. The stream passed to the handler returns the context
+// resulting from the chain.
+func NewStreamServerInterceptor(securityChain chain.SecurityChain, options *Options) grpcgo.StreamServerInterceptor {
+	return func(srv interface{}, stream grpcgo.ServerStream, info *grpcgo.StreamServerInfo, handler grpcgo.StreamHandler) error {
+		ctx, err := executeChain(stream.Context(), securityChain, info.FullMethod, options)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &securedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// securedStream is a grpc.ServerStream with the context resulting from the security chain.
+type securedStream struct {
+	grpcgo.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context resulting from the security chain.
+func (s *securedStream) Context() context.Context {
+	return s.ctx
+}
+
+func executeChain(ctx context.Context, securityChain chain.SecurityChain, fullMethod string, options *Options) (context.Context, error) {
+	if options != nil && len(options.RequiredScopes[fullMethod]) > 0 {
+		ctx = goa.WithRequiredScopes(ctx, options.RequiredScopes[fullMethod])
+	}
+	req := NewRequest(ctx, fullMethod, options)
+	ctx, _, _, err := securityChain.Execute(ctx, &discardResponseWriter{header: http.Header{}}, req)
+	if err != nil {
+		return nil, StatusError(err)
+	}
+	return ctx, nil
+}
+
+// NewRequest creates the HTTP request that represents the gRPC call to the security chain. The path of the
+// request is the full gRPC method name, the method is GET for the ReadMethods and POST otherwise, and the
+// headers are the (non-binary) incoming metadata of the call. The remote address is the address of the peer.
+func NewRequest(ctx context.Context, fullMethod string, options *Options) *http.Request {
+	method := http.MethodPost
+	if options != nil {
+		for _, readMethod := range options.ReadMethods {
+			if readMethod == fullMethod {
+				method = http.MethodGet
+				break
+			}
+		}
+	}
+
+	header := http.Header{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if strings.HasSuffix(key, "-bin") {
+				continue
+			}
+			for _, value := range values {
+				header.Add(key, value)
+			}
+		}
+	}
+
+	req := (&http.Request{
+		Method:     method,
+		URL:        &url.URL{Path: fullMethod},
+		RequestURI: fullMethod,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     header,
+	}).WithContext(ctx)
+	if authority := header.Get(":authority"); authority != "" {
+		req.Host = authority
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+	return req
+}
+
+// StatusError converts an error returned by the security chain to a gRPC status error. The goa errors with status
+// 401 (like chain.ErrAuthRequired or the JWT validation errors) are converted to codes.Unauthenticated, 403 (like
+// the ACL errors) to codes.PermissionDenied and 400 to codes.InvalidArgument, with the error detail as message.
+// A chain.BreakChainError is converted to codes.Unauthenticated and all other errors to codes.Internal, without
+// the error details.
+func StatusError(err error) error {
+	if _, ok := err.(*chain.BreakChainError); ok {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	goaErr, ok := err.(*goa.ErrorResponse)
+	if !ok {
+		return status.Error(codes.Internal, "internal server error")
+	}
+	switch goaErr.Status {
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, goaErr.Detail)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, goaErr.Detail)
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, goaErr.Detail)
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// discardResponseWriter is the http.ResponseWriter passed to the security chain. gRPC responses cannot be written
+// by the security middlewares, so anything written is discarded.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {}
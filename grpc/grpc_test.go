@@ -0,0 +1,178 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/acl"
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/chain"
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/Microkubes/microservice-security/oauth2"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+	"github.com/ory/ladon"
+	"github.com/ory/ladon/manager/memory"
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var signingKey = generateKey()
+
+func generateKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func newTestChain(t *testing.T) chain.SecurityChain {
+	return newTestChainWith(t, jwt.NewJWTSecurityMiddleware(goajwt.NewSimpleResolver([]goajwt.Key{&signingKey.PublicKey}), &goa.JWTSecurity{
+		In:   goa.LocHeader,
+		Name: "Authorization",
+	}))
+}
+
+func newTestChainWith(t *testing.T, mechanism chain.SecurityChainMiddleware) chain.SecurityChain {
+	manager := memory.NewMemoryManager()
+	err := manager.Create(&ladon.DefaultPolicy{
+		ID:        "greeter-read",
+		Subjects:  []string{"user"},
+		Resources: []string{"/test.Greeter/<.+>"},
+		Actions:   []string{acl.APIReadAction},
+		Effect:    ladon.AllowAccess,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aclMiddleware, err := acl.NewACLMiddleware(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	securityChain := chain.NewSecurityChain()
+	securityChain.AddMiddleware(mechanism)
+	securityChain.AddMiddleware(chain.CheckAuth)
+	securityChain.AddMiddleware(aclMiddleware)
+	return securityChain
+}
+
+func incomingContext(t *testing.T, scopes string) context.Context {
+	token, err := jwt.SignToken(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+		"scopes":   scopes,
+	}, "RS256", signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("Expected %s, got %v", code, err)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := NewUnaryServerInterceptor(newTestChain(t), &Options{
+		ReadMethods:    []string{"/test.Greeter/SayHello"},
+		RequiredScopes: map[string][]string{"/test.Greeter/SayHello": {"api:read"}},
+	})
+
+	var authObj *auth.Auth
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		authObj = auth.GetAuth(ctx)
+		return "hello", nil
+	}
+	info := &grpcgo.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	resp, err := interceptor(incomingContext(t, "api:read"), "request", info, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "hello" || authObj == nil || authObj.UserID != "10001" {
+		t.Fatal("Expected the handler to be called with the Auth", authObj)
+	}
+
+	_, err = interceptor(context.Background(), "request", info, handler)
+	expectCode(t, err, codes.Unauthenticated)
+
+	_, err = interceptor(incomingContext(t, "api:write"), "request", info, handler)
+	expectCode(t, err, codes.Unauthenticated)
+
+	// not a read method, so the ACL checks the api:write action
+	_, err = interceptor(incomingContext(t, "api:read"), "request", &grpcgo.UnaryServerInfo{FullMethod: "/test.Greeter/SetGreeting"}, handler)
+	expectCode(t, err, codes.PermissionDenied)
+}
+
+func TestUnaryServerInterceptorOAuth2(t *testing.T) {
+	oauth2Middleware := oauth2.NewOAuth2SecurityMiddleware(goajwt.NewSimpleResolver([]goajwt.Key{&signingKey.PublicKey}), &goa.OAuth2Security{})
+	interceptor := NewUnaryServerInterceptor(newTestChainWith(t, chain.ToSecurityChainMiddleware(oauth2.OAuth2SecurityType, oauth2Middleware)), &Options{
+		ReadMethods:    []string{"/test.Greeter/SayHello"},
+		RequiredScopes: map[string][]string{"/test.Greeter/SayHello": {"api:read"}},
+	})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "hello", nil
+	}
+	info := &grpcgo.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	if _, err := interceptor(incomingContext(t, "api:read"), "request", info, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := interceptor(incomingContext(t, "api:write"), "request", info, handler)
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+type testServerStream struct {
+	grpcgo.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := NewStreamServerInterceptor(newTestChain(t), &Options{
+		ReadMethods: []string{"/test.Greeter/StreamHellos"},
+	})
+	info := &grpcgo.StreamServerInfo{FullMethod: "/test.Greeter/StreamHellos", IsServerStream: true}
+
+	var authObj *auth.Auth
+	handler := func(srv interface{}, stream grpcgo.ServerStream) error {
+		authObj = auth.GetAuth(stream.Context())
+		return nil
+	}
+
+	if err := interceptor(nil, &testServerStream{ctx: incomingContext(t, "api:read")}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if authObj == nil || authObj.Username != "user" {
+		t.Fatal("Expected the stream context to hold the Auth", authObj)
+	}
+
+	err := interceptor(nil, &testServerStream{ctx: context.Background()}, info, handler)
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestStatusError(t *testing.T) {
+	expectCode(t, StatusError(chain.ErrAuthRequired("no auth")), codes.Unauthenticated)
+	expectCode(t, StatusError(goa.NewErrorClass("Unauthorized", 403)("denied")), codes.PermissionDenied)
+	expectCode(t, StatusError(goa.ErrBadRequest("bad")), codes.InvalidArgument)
+	expectCode(t, StatusError(chain.BreakChain("redirect")), codes.Unauthenticated)
+	err := StatusError(context.DeadlineExceeded)
+	expectCode(t, err, codes.Internal)
+	if status.Convert(err).Message() != "internal server error" {
+		t.Fatal("Expected the error details to be hidden")
+	}
+}
@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+)
+
+var (
+	// ErrTokenExpired is returned when the "exp" claim of the token is in the past.
+	ErrTokenExpired = goa.NewErrorClass("token_expired", 401)
+
+	// ErrTokenNotValidYet is returned when the "nbf" or "iat" claim of the token is in the future.
+	ErrTokenNotValidYet = goa.NewErrorClass("token_not_valid_yet", 401)
+
+	// ErrTokenTooOld is returned when the token was issued before the maximal allowed token age.
+	ErrTokenTooOld = goa.NewErrorClass("token_too_old", 401)
+
+	// ErrInvalidIssuer is returned when the "iss" claim is not one of the allowed issuers.
+	ErrInvalidIssuer = goa.NewErrorClass("invalid_issuer", 401)
+
+	// ErrInvalidAudience is returned when the "aud" claim does not contain the required audience.
+	ErrInvalidAudience = goa.NewErrorClass("invalid_audience", 401)
+
+	// ErrMissingClaim is returned when a required claim is not present in the token.
+	ErrMissingClaim = goa.NewErrorClass("missing_claim", 401)
+
+	// ErrInvalidClaim is returned when a registered claim has an invalid value.
+	ErrInvalidClaim = goa.NewErrorClass("invalid_claim", 401)
+)
+
+// ClaimsValidation holds the rules for validation of the registered claims of the incoming tokens.
+// The "exp", "nbf" and "iat" claims are always validated when present. The zero value does not
+// require any of the claims.
+type ClaimsValidation struct {
+	// Issuers is the list of allowed issuers. If set, the "iss" claim must be one of the issuers.
+	Issuers []string `json:"issuers,omitempty"`
+
+	// Audience is the list of accepted audiences (usually the name of the service). If set, the
+	// "aud" claim must contain at least one of them.
+	Audience []string `json:"audience,omitempty"`
+
+	// MaxAge is the maximal age of the token in seconds, calculated from the "iat" claim. If set,
+	// the "iat" claim is required.
+	MaxAge int64 `json:"maxAge,omitempty"`
+
+	// ClockSkew is the tolerated difference in seconds between the clocks of the issuer and
+	// the service, applied when validating "exp", "nbf", "iat" and the MaxAge.
+	ClockSkew int64 `json:"clockSkew,omitempty"`
+
+	// RequiredClaims is the list of claims that must be present in the token.
+	RequiredClaims []string `json:"requiredClaims,omitempty"`
+}
+
+// Validate validates the token claims. The returned error is one of the error classes
+// ErrTokenExpired, ErrTokenNotValidYet, ErrTokenTooOld, ErrInvalidIssuer, ErrInvalidAudience,
+// ErrMissingClaim or ErrInvalidClaim. A nil ClaimsValidation only validates the time claims.
+func (v *ClaimsValidation) Validate(claims map[string]interface{}) error {
+	if v == nil {
+		v = &ClaimsValidation{}
+	}
+	now := jwtgo.TimeFunc().Unix()
+
+	for _, claim := range v.RequiredClaims {
+		if value, ok := claims[claim]; !ok || value == nil {
+			return ErrMissingClaim(fmt.Sprintf("the %q claim is required", claim), "claim", claim)
+		}
+	}
+
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && now > exp+v.ClockSkew {
+		return ErrTokenExpired(fmt.Sprintf("token expired at %s", time.Unix(exp, 0).UTC().Format(time.RFC3339)))
+	}
+
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now < nbf-v.ClockSkew {
+		return ErrTokenNotValidYet(fmt.Sprintf("token is not valid before %s", time.Unix(nbf, 0).UTC().Format(time.RFC3339)))
+	}
+
+	iat, ok, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now < iat-v.ClockSkew {
+		return ErrTokenNotValidYet("token was issued in the future")
+	}
+	if v.MaxAge > 0 {
+		if !ok {
+			return ErrMissingClaim("the \"iat\" claim is required to validate the token age", "claim", "iat")
+		}
+		if now > iat+v.MaxAge+v.ClockSkew {
+			return ErrTokenTooOld(fmt.Sprintf("token is older than %d seconds", v.MaxAge))
+		}
+	}
+
+	if len(v.Issuers) > 0 {
+		issuer, ok := claims["iss"].(string)
+		if !ok {
+			return ErrInvalidIssuer("the \"iss\" claim is missing or invalid")
+		}
+		if !containsString(v.Issuers, issuer) {
+			return ErrInvalidIssuer(fmt.Sprintf("issuer %q is not allowed", issuer))
+		}
+	}
+
+	if len(v.Audience) > 0 {
+		audience, err := audienceClaim(claims)
+		if err != nil {
+			return err
+		}
+		accepted := false
+		for _, aud := range audience {
+			if containsString(v.Audience, aud) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return ErrInvalidAudience("token is not intended for this audience", "audience", audience)
+		}
+	}
+
+	return nil
+}
+
+// timeClaim reads a NumericDate claim as Unix time.
+func timeClaim(claims map[string]interface{}, claim string) (int64, bool, error) {
+	value, ok := claims[claim]
+	if !ok || value == nil {
+		return 0, false, nil
+	}
+	switch v := value.(type) {
+	case float64:
+		return int64(v), true, nil
+	case int64:
+		return v, true, nil
+	case int:
+		return int64(v), true, nil
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return 0, false, ErrInvalidClaim(fmt.Sprintf("the %q claim must be a number", claim), "claim", claim)
+		}
+		return int64(n), true, nil
+	}
+	return 0, false, ErrInvalidClaim(fmt.Sprintf("the %q claim must be a number", claim), "claim", claim)
+}
+
+// audienceClaim reads the "aud" claim, which may be a single string or a list of strings.
+func audienceClaim(claims map[string]interface{}) ([]string, error) {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}, nil
+	case []string:
+		return aud, nil
+	case []interface{}:
+		audience := []string{}
+		for _, item := range aud {
+			str, ok := item.(string)
+			if !ok {
+				return nil, ErrInvalidAudience("the \"aud\" claim must be a string or a list of strings")
+			}
+			audience = append(audience, str)
+		}
+		return audience, nil
+	case nil:
+		return nil, ErrInvalidAudience("the \"aud\" claim is missing")
+	}
+	return nil, ErrInvalidAudience("the \"aud\" claim must be a string or a list of strings")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
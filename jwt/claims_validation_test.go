@@ -0,0 +1,91 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+func TestClaimsValidation(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name       string
+		validation *ClaimsValidation
+		claims     map[string]interface{}
+		code       string
+	}{
+		{"no rules", nil, map[string]interface{}{"sub": "user"}, ""},
+		{"valid exp", nil, map[string]interface{}{"exp": float64(now + 60)}, ""},
+		{"expired", nil, map[string]interface{}{"exp": float64(now - 60)}, "token_expired"},
+		{"expired within skew", &ClaimsValidation{ClockSkew: 120}, map[string]interface{}{"exp": float64(now - 60)}, ""},
+		{"not valid yet", nil, map[string]interface{}{"nbf": float64(now + 60)}, "token_not_valid_yet"},
+		{"nbf within skew", &ClaimsValidation{ClockSkew: 120}, map[string]interface{}{"nbf": float64(now + 60)}, ""},
+		{"issued in the future", nil, map[string]interface{}{"iat": float64(now + 60)}, "token_not_valid_yet"},
+		{"invalid exp", nil, map[string]interface{}{"exp": "tomorrow"}, "invalid_claim"},
+		{"max age", &ClaimsValidation{MaxAge: 300}, map[string]interface{}{"iat": float64(now - 60)}, ""},
+		{"too old", &ClaimsValidation{MaxAge: 300}, map[string]interface{}{"iat": float64(now - 600)}, "token_too_old"},
+		{"max age without iat", &ClaimsValidation{MaxAge: 300}, map[string]interface{}{}, "missing_claim"},
+		{"allowed issuer", &ClaimsValidation{Issuers: []string{"a", "b"}}, map[string]interface{}{"iss": "b"}, ""},
+		{"invalid issuer", &ClaimsValidation{Issuers: []string{"a", "b"}}, map[string]interface{}{"iss": "c"}, "invalid_issuer"},
+		{"missing issuer", &ClaimsValidation{Issuers: []string{"a"}}, map[string]interface{}{}, "invalid_issuer"},
+		{"audience string", &ClaimsValidation{Audience: []string{"user-service"}}, map[string]interface{}{"aud": "user-service"}, ""},
+		{"audience list", &ClaimsValidation{Audience: []string{"user-service"}}, map[string]interface{}{"aud": []interface{}{"other", "user-service"}}, ""},
+		{"invalid audience", &ClaimsValidation{Audience: []string{"user-service"}}, map[string]interface{}{"aud": []interface{}{"other"}}, "invalid_audience"},
+		{"missing audience", &ClaimsValidation{Audience: []string{"user-service"}}, map[string]interface{}{}, "invalid_audience"},
+		{"required claims", &ClaimsValidation{RequiredClaims: []string{"jti"}}, map[string]interface{}{"jti": "1"}, ""},
+		{"missing required claim", &ClaimsValidation{RequiredClaims: []string{"jti"}}, map[string]interface{}{}, "missing_claim"},
+	}
+
+	for _, test := range tests {
+		err := test.validation.Validate(test.claims)
+		if test.code == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: expected %s error", test.name, test.code)
+		}
+		goaErr, ok := err.(*goa.ErrorResponse)
+		if !ok {
+			t.Fatalf("%s: expected goa error, got %v", test.name, err)
+		}
+		if goaErr.Code != test.code {
+			t.Fatalf("%s: expected error code %s, got %s", test.name, test.code, goaErr.Code)
+		}
+	}
+}
+
+func TestValidateTokenWithClaims(t *testing.T) {
+	key, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := SignToken(map[string]interface{}{
+		"iss": "http://issuer.jwt",
+		"exp": time.Now().Add(-30 * time.Second).Unix(),
+	}, "RS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ValidateToken(tokenStr, []goajwt.Key{&key.PublicKey}); err == nil {
+		t.Fatal("Expected the expired token to be rejected")
+	}
+
+	token, err := ValidateTokenWithClaims(tokenStr, []goajwt.Key{&key.PublicKey}, &ClaimsValidation{
+		Issuers:   []string{"http://issuer.jwt"},
+		ClockSkew: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Claims.(jwtgo.MapClaims)["iss"] != "http://issuer.jwt" {
+		t.Fatal("Expected the validated token")
+	}
+}
@@ -14,15 +14,31 @@ var AvailableSigningMethods = SigningMethods{
 	"RS256": jwtgo.SigningMethodRS256,
 	"RS384": jwtgo.SigningMethodRS384,
 	"RS512": jwtgo.SigningMethodRS512,
+	"PS256": jwtgo.SigningMethodPS256,
+	"PS384": jwtgo.SigningMethodPS384,
+	"PS512": jwtgo.SigningMethodPS512,
+	"ES256": jwtgo.SigningMethodES256,
+	"ES384": jwtgo.SigningMethodES384,
+	"ES512": jwtgo.SigningMethodES512,
+	"EdDSA": SigningMethodEdDSA,
 }
 
 // SignToken singns a JWT token with the given claims using the provided private key with the signingMethod.
+// For RSA, ECDSA and Ed25519 keys the "kid" header is set to the RFC 7638 thumbprint of the key (see KeyID),
+// so the verifiers can select the matching key from the published JSON Web Key Set.
 func SignToken(claims map[string]interface{}, signingMethod string, key interface{}) (string, error) {
+	return SignTokenWithType(claims, signingMethod, key, "JWT")
+}
+
+// SignTokenWithType signs a JWT token like SignToken, but sets the "typ" header to tokenType
+// (for example "at+jwt" for OAuth2 access tokens, as specified by RFC 9068).
+func SignTokenWithType(claims map[string]interface{}, signingMethod string, key interface{}, tokenType string) (string, error) {
 	method, ok := AvailableSigningMethods[signingMethod]
 	if !ok {
 		return "", fmt.Errorf("Unsupported sign method %s", signingMethod)
 	}
 	token := jwtgo.New(method)
+	token.Header["typ"] = tokenType
 	mapClaims := jwtgo.MapClaims{}
 
 	for k, v := range claims {
@@ -31,6 +47,10 @@ func SignToken(claims map[string]interface{}, signingMethod string, key interfac
 
 	token.Claims = mapClaims
 
+	if kid, err := KeyID(key); err == nil {
+		token.Header["kid"] = kid
+	}
+
 	signedToken, err := token.SignedString(key)
 	return signedToken, err
 }
@@ -1,9 +1,15 @@
 package jwt
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"testing"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
 )
 
 func TestSignToken(t *testing.T) {
@@ -35,3 +41,97 @@ func TestSignToken(t *testing.T) {
 		t.Fatal("Expected JWT token, not empty string")
 	}
 }
+
+func TestSignTokenWithType(t *testing.T) {
+	keyPair, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenStr, err := SignTokenWithType(map[string]interface{}{"userId": "user-1"}, "RS256", keyPair, "at+jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwtgo.Parse(tokenStr, func(token *jwtgo.Token) (interface{}, error) {
+		return &keyPair.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["typ"] != "at+jwt" {
+		t.Fatal("Expected the at+jwt type header", token.Header)
+	}
+}
+
+func TestSignTokenAllMethods(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method     string
+		privateKey interface{}
+		publicKey  goajwt.Key
+	}{
+		{"RS256", rsaKey, &rsaKey.PublicKey},
+		{"PS256", rsaKey, &rsaKey.PublicKey},
+		{"PS384", rsaKey, &rsaKey.PublicKey},
+		{"PS512", rsaKey, &rsaKey.PublicKey},
+		{"ES256", p256Key, &p256Key.PublicKey},
+		{"ES384", p384Key, &p384Key.PublicKey},
+		{"ES512", p521Key, &p521Key.PublicKey},
+		{"EdDSA", edPrivateKey, edPublicKey},
+	}
+
+	for _, c := range cases {
+		tokenStr, err := SignToken(map[string]interface{}{"userId": "user-1"}, c.method, c.privateKey)
+		if err != nil {
+			t.Fatal(c.method, err)
+		}
+		token, err := ValidateToken(tokenStr, []goajwt.Key{c.publicKey})
+		if err != nil {
+			t.Fatal(c.method, err)
+		}
+		if token.Method.Alg() != c.method {
+			t.Fatal("Expected", c.method, "got", token.Method.Alg())
+		}
+		kid, err := KeyID(c.publicKey)
+		if err != nil {
+			t.Fatal(c.method, err)
+		}
+		if token.Header["kid"] != kid {
+			t.Fatal(c.method, "expected kid to be the thumbprint of the key")
+		}
+	}
+
+	// a token must not validate with a key of a different type
+	tokenStr, err := SignToken(map[string]interface{}{"userId": "user-1"}, "EdDSA", edPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidateToken(tokenStr, []goajwt.Key{&rsaKey.PublicKey, []byte("secret")}); err == nil {
+		t.Fatal("Expected validation to fail with non-Ed25519 keys")
+	}
+
+	// EdDSA tokens are parseable by jwt-go once the jwt package is loaded
+	if _, err = jwtgo.Parse(tokenStr, func(*jwtgo.Token) (interface{}, error) { return edPublicKey, nil }); err != nil {
+		t.Fatal(err)
+	}
+}
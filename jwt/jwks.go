@@ -0,0 +1,437 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// DefaultJWKSCacheTTL is the default period for which the fetched JSON Web Key Set is cached.
+const DefaultJWKSCacheTTL = 10 * time.Minute
+
+// DefaultJWKSMinRefreshInterval is the default minimal period between two fetches of the
+// JSON Web Key Set triggered by a token with unknown key ID.
+const DefaultJWKSMinRefreshInterval = 30 * time.Second
+
+// JWK is a JSON Web Key as defined in RFC 7517. Only the parameters for public
+// RSA, EC and OKP (Ed25519, RFC 8037) keys are supported.
+type JWK struct {
+	// Kty is the key type ("RSA", "EC" or "OKP").
+	Kty string `json:"kty"`
+
+	// Use is the intended use of the key ("sig" or "enc").
+	Use string `json:"use,omitempty"`
+
+	// Alg is the algorithm intended for use with the key.
+	Alg string `json:"alg,omitempty"`
+
+	// Kid is the key ID.
+	Kid string `json:"kid,omitempty"`
+
+	// N is the RSA modulus.
+	N string `json:"n,omitempty"`
+
+	// E is the RSA public exponent.
+	E string `json:"e,omitempty"`
+
+	// Crv is the name of the curve of an EC or OKP key.
+	Crv string `json:"crv,omitempty"`
+
+	// X is the X coordinate of an EC key, or the public key of an OKP key.
+	X string `json:"x,omitempty"`
+
+	// Y is the Y coordinate of an EC key.
+	Y string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set as defined in RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes the JWK into a public key (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey).
+func (jwk *JWK) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %s", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %s", err)
+		}
+		if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{
+			N: n,
+			E: int(e.Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC X coordinate: %s", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC Y coordinate: %s", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     x,
+			Y:     y,
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.X, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Ed25519 public key: %s", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// NewJWK creates a JWK for the public part of the given RSA, ECDSA or Ed25519 key. The key may
+// be either a private or a public key. The key ID is set to the RFC 7638 thumbprint of the key.
+func NewJWK(key interface{}) (*JWK, error) {
+	var jwk *JWK
+	switch k := PublicKey(key).(type) {
+	case *rsa.PublicKey:
+		jwk = &JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk = &JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(k.X.Bytes(), size)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(k.Y.Bytes(), size)),
+		}
+	case ed25519.PublicKey:
+		jwk = &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	jwk.Use = "sig"
+	kid, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = kid
+	return jwk, nil
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint (SHA-256, base64url encoded) of the key.
+func (jwk *JWK) Thumbprint() (string, error) {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+	digest := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+// KeyID returns the key ID for the given RSA, ECDSA or Ed25519 (private or public) key. The key ID is
+// the RFC 7638 thumbprint of the public key, so the issuer and the verifiers derive the same value.
+func KeyID(key interface{}) (string, error) {
+	jwk, err := NewJWK(key)
+	if err != nil {
+		return "", err
+	}
+	return jwk.Kid, nil
+}
+
+// PublicKey returns the public part of a private key. Public keys and HMAC secrets are returned as they are.
+func PublicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return key
+}
+
+func padBytes(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(data):], data)
+	return padded
+}
+
+// decodeBigInt decodes a base64url encoded big-endian unsigned integer.
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("value is missing")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// JWKSKeyResolver is a goajwt.KeyResolver that loads the public keys from a JSON Web Key Set.
+// The key set is fetched from a URL or read from a local file and cached for TTL. The keys are
+// selected by the "kid" header of the incoming JWT. If the token refers to a key that is not
+// in the cached key set, the key set is fetched again (at most once per MinRefreshInterval).
+// Only one fetch runs at a time, and a failed fetch counts as an attempt, so the key set is not
+// refetched on every request while the source is unavailable.
+// Tokens without a "kid" header are checked against all keys in the key set.
+type JWKSKeyResolver struct {
+	// Source is the URL (http:// or https://) or the file path (optionally prefixed with file://)
+	// of the JSON Web Key Set.
+	Source string
+
+	// TTL is the period for which the fetched key set is considered valid.
+	TTL time.Duration
+
+	// MinRefreshInterval is the minimal period between two fetch attempts triggered by an unknown key ID
+	// or, after a failed fetch, by an expired key set.
+	MinRefreshInterval time.Duration
+
+	// Header is the name of the header that carries the bearer token. Defaults to "Authorization".
+	Header string
+
+	// Client is the HTTP client used to fetch the key set from a URL.
+	Client *http.Client
+
+	mutex        sync.RWMutex
+	refreshMutex sync.Mutex
+	keys         map[string]goajwt.Key
+	allKeys      []goajwt.Key
+	fetchedAt    time.Time
+	attemptedAt  time.Time
+}
+
+// NewJWKSKeyResolver creates a JWKSKeyResolver for the JSON Web Key Set available at source.
+// The source may be a URL or a local file. The key set is loaded immediately and an error is
+// returned if it cannot be loaded. If ttl is 0, DefaultJWKSCacheTTL is used.
+func NewJWKSKeyResolver(source string, ttl time.Duration) (*JWKSKeyResolver, error) {
+	if ttl == 0 {
+		ttl = DefaultJWKSCacheTTL
+	}
+	resolver := &JWKSKeyResolver{
+		Source:             source,
+		TTL:                ttl,
+		MinRefreshInterval: DefaultJWKSMinRefreshInterval,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := resolver.Refresh(); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// Refresh fetches the key set from the source and replaces the cached keys. The time of the attempt is recorded
+// even if the fetch fails.
+func (r *JWKSKeyResolver) Refresh() error {
+	err := r.refresh()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.attemptedAt = time.Now()
+	return err
+}
+
+// refreshIf refreshes the key set if needed, holding the refresh lock, so the concurrent requests that need a
+// refresh trigger a single fetch. needed is checked again under the lock, after the refresh of another request.
+func (r *JWKSKeyResolver) refreshIf(needed func() bool) error {
+	if !needed() {
+		return nil
+	}
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+	if !needed() {
+		return nil
+	}
+	return r.Refresh()
+}
+
+func (r *JWKSKeyResolver) refresh() error {
+	data, err := r.fetch()
+	if err != nil {
+		return err
+	}
+	keySet := JWKSet{}
+	if err = json.Unmarshal(data, &keySet); err != nil {
+		return fmt.Errorf("failed to parse JWKS from %s: %s", r.Source, err)
+	}
+
+	keys := map[string]goajwt.Key{}
+	allKeys := []goajwt.Key{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Println("Skipping JWK", jwk.Kid, "from", r.Source, ":", err.Error())
+			continue
+		}
+		if jwk.Kid != "" {
+			keys[jwk.Kid] = key
+		}
+		allKeys = append(allKeys, key)
+	}
+	if len(allKeys) == 0 {
+		return fmt.Errorf("no usable keys in JWKS from %s", r.Source)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keys = keys
+	r.allKeys = allKeys
+	r.fetchedAt = time.Now()
+	return nil
+}
+
+func (r *JWKSKeyResolver) fetch() ([]byte, error) {
+	if !strings.HasPrefix(r.Source, "http://") && !strings.HasPrefix(r.Source, "https://") {
+		return ioutil.ReadFile(strings.TrimPrefix(r.Source, "file://"))
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(r.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS from %s: %s", r.Source, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// SelectKeys returns the keys that should be used to validate the JWT in the request.
+// If the token has a "kid" header, only the key with that ID is returned.
+func (r *JWKSKeyResolver) SelectKeys(req *http.Request) []goajwt.Key {
+	if err := r.refreshIf(r.expired); err != nil {
+		log.Println("Failed to refresh JWKS, using cached keys:", err.Error())
+	}
+
+	kid := tokenKeyID(r.incomingToken(req))
+	if kid == "" {
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
+		return r.allKeys
+	}
+
+	if key, ok := r.lookup(kid); ok {
+		return []goajwt.Key{key}
+	}
+	if err := r.refreshIf(r.canRefresh); err != nil {
+		log.Println("Failed to refresh JWKS for unknown key", kid, ":", err.Error())
+		return nil
+	}
+	if key, ok := r.lookup(kid); ok {
+		return []goajwt.Key{key}
+	}
+	return nil
+}
+
+func (r *JWKSKeyResolver) lookup(kid string) (goajwt.Key, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+// expired checks if the key set has expired and can be refreshed.
+func (r *JWKSKeyResolver) expired() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return time.Since(r.fetchedAt) > r.TTL && time.Since(r.attemptedAt) >= r.MinRefreshInterval
+}
+
+// canRefresh checks if the MinRefreshInterval has passed since the last fetch attempt.
+func (r *JWKSKeyResolver) canRefresh() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return time.Since(r.attemptedAt) >= r.MinRefreshInterval
+}
+
+func (r *JWKSKeyResolver) incomingToken(req *http.Request) string {
+	header := r.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	value := req.Header.Get(header)
+	if len(value) < 7 || !strings.EqualFold(value[0:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(value[7:])
+}
+
+// tokenKeyID extracts the "kid" header from a JWT without validating the token.
+func tokenKeyID(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	data, err := jwtgo.DecodeSegment(parts[0])
+	if err != nil {
+		return ""
+	}
+	header := map[string]interface{}{}
+	if err = json.Unmarshal(data, &header); err != nil {
+		return ""
+	}
+	kid, _ := header["kid"].(string)
+	return kid
+}
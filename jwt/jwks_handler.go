@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Microkubes/microservice-security/tools"
+)
+
+// JWKSPath is the well-known path under which the JSON Web Key Set is usually published.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWKSFromKeyStore derives a JSON Web Key Set from the private keys in the KeyStore.
+// If the KeyStore implements tools.KeyNameLister, all keys are published. Otherwise only
+// the default key is published. Every key is published once, under its RFC 7638 thumbprint
+// as "kid" - the same key ID that SignToken puts in the JWT header.
+func JWKSFromKeyStore(keyStore tools.KeyStore) (*JWKSet, error) {
+	keys := []interface{}{}
+
+	defaultKey, err := keyStore.GetPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, defaultKey)
+
+	if lister, ok := keyStore.(tools.KeyNameLister); ok {
+		for _, keyName := range lister.KeyNames() {
+			key, err := keyStore.GetPrivateKeyByName(keyName)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	keySet := &JWKSet{
+		Keys: []JWK{},
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		jwk, err := NewJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[jwk.Kid] {
+			continue
+		}
+		seen[jwk.Kid] = true
+		keySet.Keys = append(keySet.Keys, *jwk)
+	}
+	return keySet, nil
+}
+
+// NewJWKSHandler creates an http.Handler that serves the public keys of the KeyStore as a
+// JSON Web Key Set. The key set is derived on every request, so keys added to the KeyStore
+// are published immediately. The handler is usually mounted under JWKSPath.
+func NewJWKSHandler(keyStore tools.KeyStore) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		keySet, err := JWKSFromKeyStore(keyStore)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed to load keys: %s", err), http.StatusInternalServerError)
+			return
+		}
+		data, err := json.Marshal(keySet)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(data)
+	})
+}
@@ -0,0 +1,136 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/tools"
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+func TestJWKSFromKeyStore(t *testing.T) {
+	defaultKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	systemKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore := &tools.FileKeyStore{
+		PrivateKey: defaultKey,
+		KeysMap: map[string]interface{}{
+			"default": defaultKey,
+			"system":  systemKey,
+		},
+	}
+
+	keySet, err := JWKSFromKeyStore(keyStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet.Keys) != 2 {
+		t.Fatal("Expected 2 keys in the key set, got", len(keySet.Keys))
+	}
+
+	defaultKID, err := KeyID(defaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keySet.Keys[0].Kid != defaultKID || keySet.Keys[0].Kty != "RSA" {
+		t.Fatal("Expected the default key to be published first under its thumbprint")
+	}
+	if keySet.Keys[1].Kty != "EC" || keySet.Keys[1].Crv != "P-384" {
+		t.Fatal("Expected the system EC key to be published")
+	}
+	for _, jwk := range keySet.Keys {
+		thumbprint, err := jwk.Thumbprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if thumbprint != jwk.Kid {
+			t.Fatal("Expected kid to be the key thumbprint")
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatal("Unexpected thumbprint", thumbprint)
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	key, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore := &tools.FileKeyStore{
+		PrivateKey: key,
+		KeysMap: map[string]interface{}{
+			"default": key,
+		},
+	}
+
+	server := httptest.NewServer(NewJWKSHandler(keyStore))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + JWKSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("Expected status 200, got", resp.StatusCode)
+	}
+	keySet := JWKSet{}
+	if err = json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet.Keys) != 1 {
+		t.Fatal("Expected one key in the key set")
+	}
+
+	// a token signed by the key store must be verifiable with the published key set
+	tokenStr, err := SignToken(map[string]interface{}{"userId": "user-1"}, "RS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := new(jwtgo.Parser).ParseUnverified(tokenStr, jwtgo.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != keySet.Keys[0].Kid {
+		t.Fatal("Expected the token kid to match the published key")
+	}
+
+	resolver, err := NewJWKSKeyResolver(server.URL+JWKSPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := resolver.SelectKeys(requestWithToken(tokenStr)); len(keys) != 1 {
+		t.Fatal("Expected the resolver to select the signing key")
+	}
+
+	req := httptest.NewRequest("POST", JWKSPath, nil)
+	rw := httptest.NewRecorder()
+	NewJWKSHandler(keyStore).ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatal("Expected 405 for POST, got", rw.Code)
+	}
+}
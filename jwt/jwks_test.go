@@ -0,0 +1,239 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Microkubes/microservice-security/auth"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+)
+
+func toTestJWK(kid string, key interface{}) JWK {
+	jwk, err := NewJWK(key)
+	if err != nil {
+		panic(err)
+	}
+	jwk.Kid = kid
+	return *jwk
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"username": "test-user",
+		"userId":   "f77fc7b6-faa4-4c64-b18c-934ba3f913dd",
+		"roles":    "user",
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenStr, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenStr
+}
+
+func requestWithToken(token string) *http.Request {
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return req
+}
+
+func TestJWKSKeyResolver(t *testing.T) {
+	rsaKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySet := JWKSet{
+		Keys: []JWK{toTestJWK("rsa-key", &rsaKey.PublicKey)},
+	}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fetches++
+		json.NewEncoder(rw).Encode(keySet)
+	}))
+	defer server.Close()
+
+	resolver, err := NewJWKSKeyResolver(server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.MinRefreshInterval = 0
+
+	keys := resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "rsa-key", rsaKey)))
+	if len(keys) != 1 || keys[0].(*rsa.PublicKey).N.Cmp(rsaKey.N) != 0 {
+		t.Fatal("Expected the RSA key to be selected by kid")
+	}
+	if fetches != 1 {
+		t.Fatal("Expected the key set to be cached, but it was fetched", fetches, "times")
+	}
+
+	// rotate in a new key - the resolver must refetch on unknown kid
+	keySet.Keys = append(keySet.Keys, toTestJWK("ec-key", &ecKey.PublicKey))
+	keys = resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodES256, "ec-key", ecKey)))
+	if len(keys) != 1 {
+		t.Fatal("Expected the EC key to be selected after refetch")
+	}
+	if _, ok := keys[0].(*ecdsa.PublicKey); !ok {
+		t.Fatal("Expected ECDSA public key")
+	}
+	if fetches != 2 {
+		t.Fatal("Expected the key set to be fetched again, but it was fetched", fetches, "times")
+	}
+
+	keys = resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "", rsaKey)))
+	if len(keys) != 2 {
+		t.Fatal("Expected all keys for token without kid")
+	}
+
+	keys = resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "unknown", rsaKey)))
+	if len(keys) != 0 {
+		t.Fatal("Expected no keys for unknown kid")
+	}
+}
+
+func TestJWKSKeyResolverRefreshAttempts(t *testing.T) {
+	rsaKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	fetches := 0
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		if !available {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(rw).Encode(JWKSet{Keys: []JWK{toTestJWK("rsa-key", &rsaKey.PublicKey)}})
+	}))
+	defer server.Close()
+
+	resolver, err := NewJWKSKeyResolver(server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.attemptedAt = time.Now().Add(-time.Hour)
+
+	// concurrent requests with an unknown kid trigger a single fetch
+	token := signTestToken(t, jwt.SigningMethodRS256, "unknown", rsaKey)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resolver.SelectKeys(requestWithToken(token))
+		}()
+	}
+	wg.Wait()
+	mutex.Lock()
+	if fetches != 2 {
+		t.Fatal("Expected a single refetch for the unknown kid, got", fetches-1)
+	}
+
+	// a failed fetch of an expired key set is not repeated on every request
+	available = false
+	mutex.Unlock()
+	resolver.mutex.Lock()
+	resolver.fetchedAt = time.Now().Add(-2 * time.Hour)
+	resolver.attemptedAt = time.Now().Add(-time.Hour)
+	resolver.mutex.Unlock()
+	for i := 0; i < 5; i++ {
+		keys := resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "rsa-key", rsaKey)))
+		if len(keys) != 1 {
+			t.Fatal("Expected the cached key to be used while the JWKS endpoint is down")
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fetches != 3 {
+		t.Fatal("Expected a single fetch attempt while the JWKS endpoint is down, got", fetches-2)
+	}
+}
+
+func TestJWKSKeyResolverFromFile(t *testing.T) {
+	rsaKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := json.Marshal(JWKSet{Keys: []JWK{toTestJWK("file-key", &rsaKey.PublicKey)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := path.Join(dir, "jwks.json")
+	if err = ioutil.WriteFile(jwksFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewJWKSKeyResolver("file://"+jwksFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := resolver.SelectKeys(requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "file-key", rsaKey)))
+	if len(keys) != 1 {
+		t.Fatal("Expected the key to be loaded from file")
+	}
+
+	if _, err = NewJWKSKeyResolver(path.Join(dir, "missing.json"), 0); err == nil {
+		t.Fatal("Expected an error for missing JWKS file")
+	}
+}
+
+func TestJWTMiddlewareWithJWKS(t *testing.T) {
+	rsaKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(JWKSet{Keys: []JWK{toTestJWK("key-1", &rsaKey.PublicKey)}})
+	}))
+	defer server.Close()
+
+	resolver, err := NewJWKSKeyResolver(server.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	middleware := NewJWTSecurityMiddleware(resolver, &goa.JWTSecurity{
+		In:   goa.LocHeader,
+		Name: "Authorization",
+	})
+
+	ctx, _, err := middleware(context.Background(), nil, requestWithToken(signTestToken(t, jwt.SigningMethodRS256, "key-1", rsaKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.HasAuth(ctx) {
+		t.Fatal("Expected auth to be set in context")
+	}
+}
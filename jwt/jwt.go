@@ -5,14 +5,13 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
-	"strings"
 
 	"context"
 
 	"github.com/Microkubes/microservice-security/auth"
 	"github.com/Microkubes/microservice-security/chain"
+	"github.com/Microkubes/microservice-security/tools"
 	"github.com/dgrijalva/jwt-go"
-	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/keitaroinc/goa"
 	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
 )
@@ -36,51 +35,54 @@ func NewKeyResolver(path string) (goajwt.KeyResolver, error) {
 // As resolver you may pass the simple key resolver created with NewKeyResolver or you may pass a more
 // sophisticated key-resolver.
 // The scheme is obtained from the generated Goadesign JWT security.
+// The token signature is validated with ValidateToken, so RSA (RS and PS), ECDSA, Ed25519 and HMAC
+// keys are supported. The validated token is available in the context via goajwt.ContextJWT.
 func NewJWTSecurityMiddleware(resolver goajwt.KeyResolver, scheme *goa.JWTSecurity) chain.SecurityChainMiddleware {
-	goaMiddleware := goajwt.New(resolver, func(handler goa.Handler) goa.Handler {
-		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
-			jwtToken := goajwt.ContextJWT(ctx)
-			claims := jwtToken.Claims.(jwt.MapClaims)
-
-			if _, ok := claims["userId"]; !ok {
-				return jwt.NewValidationError("User ID is missing", jwt.ValidationErrorClaimsInvalid)
-			}
-
-			authObj := &auth.Auth{
-				UserID: claims["userId"].(string),
-			}
-
-			if _, ok := claims["customerID"]; ok {
-				authObj.CustomerID = claims["customerID"].(float64)
-			}
+	return NewJWTSecurityMiddlewareWithOptions(resolver, scheme, nil)
+}
 
-			if _, ok := claims["username"]; ok {
-				authObj.Username = claims["username"].(string)
+// NewJWTSecurityMiddlewareWithOptions creates a new chain.SecurityChainMiddleware like NewJWTSecurityMiddleware,
+// with additional SecurityOptions. If no ClaimMapper is set in the options, DefaultClaimMapper is used.
+// The token claims are validated with the ClaimsValidation from the options.
+func NewJWTSecurityMiddlewareWithOptions(resolver goajwt.KeyResolver, scheme *goa.JWTSecurity, options *SecurityOptions) chain.SecurityChainMiddleware {
+	goaMiddleware := func(handler goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			incomingToken, err := extractToken(scheme, req)
+			if err != nil {
+				return err
 			}
 
-			if _, ok := claims["fullname"]; ok {
-				authObj.Fullname = claims["fullname"].(string)
+			jwtToken, err := parseToken(incomingToken, resolver.SelectKeys(req))
+			if err != nil {
+				return goajwt.ErrJWTError("JWT validation failed", "err", err.Error())
 			}
+			claims := jwtToken.Claims.(jwt.MapClaims)
 
-			if _, ok := claims["email"]; ok {
-				authObj.Email = claims["email"].(string)
+			if err = options.ValidateClaims(claims); err != nil {
+				return err
 			}
 
-			if rolesStr, ok := claims["roles"]; ok {
-				authObj.Roles = strings.Split(rolesStr.(string), ",")
+			scopesInClaim, scopesInClaimList, err := parseClaimScopes(claims)
+			if err != nil {
+				return goajwt.ErrJWTError(err)
 			}
-
-			if organizations, ok := claims["organizations"]; ok {
-				authObj.Organizations = strings.Split(organizations.(string), ",")
+			requiredScopes := goa.ContextRequiredScopes(ctx)
+			for _, scope := range requiredScopes {
+				if !scopesInClaim[scope] {
+					msg := "authorization failed: required 'scope' or 'scopes' not present in JWT claim"
+					return goajwt.ErrJWTError(msg, "required", requiredScopes, "scopes", scopesInClaimList)
+				}
 			}
+			ctx = goajwt.WithJWT(ctx, jwtToken)
 
-			if namespaces, ok := claims["namespaces"]; ok {
-				authObj.Namespaces = strings.Split(namespaces.(string), ",")
+			authObj, err := options.MapClaims(claims, DefaultClaimMapper())
+			if err != nil {
+				return jwt.NewValidationError(err.Error(), jwt.ValidationErrorClaimsInvalid)
 			}
 
 			return handler(auth.SetAuth(ctx, authObj), rw, req)
 		}
-	}, scheme)
+	}
 	return chain.ToSecurityChainMiddleware(JWTSecurityType, goaMiddleware)
 }
 
@@ -88,14 +90,21 @@ func NewJWTSecurityMiddleware(resolver goajwt.KeyResolver, scheme *goa.JWTSecuri
 // that loads the public keys from the keysDir. The key files must end in *.pub.
 // The scheme is obtained from the generated Goadesign JWT security.
 func NewJWTSecurity(keysDir string, scheme *goa.JWTSecurity) chain.SecurityChainMiddleware {
+	return NewJWTSecurityWithOptions(keysDir, scheme, nil)
+}
+
+// NewJWTSecurityWithOptions creates a JWT SecurityChainMiddleware like NewJWTSecurity, with additional
+// SecurityOptions.
+func NewJWTSecurityWithOptions(keysDir string, scheme *goa.JWTSecurity, options *SecurityOptions) chain.SecurityChainMiddleware {
 	resolver, err := NewKeyResolver(keysDir)
 	if err != nil {
 		panic(err)
 	}
-	return NewJWTSecurityMiddleware(resolver, scheme)
+	return NewJWTSecurityMiddlewareWithOptions(resolver, scheme, options)
 }
 
-// LoadJWTPublicKeys loads PEM encoded RSA public keys used to validate and decrypt the JWT.
+// LoadJWTPublicKeys loads PEM encoded public keys used to validate the JWT. The keys may be
+// RSA, ECDSA or Ed25519 public keys (PKIX or PKCS#1) or X.509 certificates.
 func LoadJWTPublicKeys(path string) ([]goajwt.Key, error) {
 	keyFiles, err := filepath.Glob(fmt.Sprintf("%s/*.pub", path))
 	if err != nil {
@@ -107,7 +116,7 @@ func LoadJWTPublicKeys(path string) ([]goajwt.Key, error) {
 		if err != nil {
 			return nil, err
 		}
-		key, err := jwtgo.ParseRSAPublicKeyFromPEM([]byte(pem))
+		key, err := tools.ParsePublicKeyFromPEM(pem)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load key %s: %s", keyFile, err)
 		}
@@ -1,6 +1,9 @@
 package jwt
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -8,6 +11,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 
 	"net/http/httptest"
@@ -177,3 +181,196 @@ func TestNewJWTSecurity(t *testing.T) {
 	}
 
 }
+
+func TestLoadJWTPublicKeys(t *testing.T) {
+	keysDir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keysDir)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]interface{}{"ec.pub": &ecKey.PublicKey, "ed.pub": edPublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		if err = ioutil.WriteFile(path.Join(keysDir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := LoadJWTPublicKeys(keysDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatal("Expected 2 keys, got", len(keys))
+	}
+	if _, ok := keys[0].(*ecdsa.PublicKey); !ok {
+		t.Fatal("Expected ECDSA public key")
+	}
+	if _, ok := keys[1].(ed25519.PublicKey); !ok {
+		t.Fatal("Expected Ed25519 public key")
+	}
+}
+
+func TestJWTMiddlewareEdDSA(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := SignToken(map[string]interface{}{
+		"userId":   "f77fc7b6-faa4-4c64-b18c-934ba3f913dd",
+		"username": "test-user",
+	}, "EdDSA", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	middleware := NewJWTSecurityMiddleware(goajwt.NewSimpleResolver([]goajwt.Key{publicKey}), &goa.JWTSecurity{
+		In:   goa.LocHeader,
+		Name: "Authorization",
+	})
+
+	ctx, _, err := middleware(context.Background(), nil, requestWithToken(tokenStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authObj := auth.GetAuth(ctx)
+	if authObj == nil || authObj.Username != "test-user" {
+		t.Fatal("Expected auth to be set in context")
+	}
+}
+
+func TestJWTMiddlewareWithClaimMapper(t *testing.T) {
+	key, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := SignToken(map[string]interface{}{
+		"sub":                "f77fc7b6-faa4-4c64-b18c-934ba3f913dd",
+		"preferred_username": "test-user",
+		"realm_access": map[string]interface{}{
+			"roles": []string{"user", "admin"},
+		},
+	}, "RS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheme := &goa.JWTSecurity{
+		In:   goa.LocHeader,
+		Name: "Authorization",
+	}
+	resolver := goajwt.NewSimpleResolver([]goajwt.Key{&key.PublicKey})
+
+	middleware := NewJWTSecurityMiddlewareWithOptions(resolver, scheme, &SecurityOptions{
+		ClaimMapper: &auth.ClaimMapper{
+			UserID:   "sub",
+			Username: "preferred_username",
+			Roles:    "realm_access.roles",
+			Required: []string{"userId"},
+		},
+	})
+	ctx, _, err := middleware(context.Background(), nil, requestWithToken(tokenStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authObj := auth.GetAuth(ctx)
+	if authObj == nil || authObj.UserID != "f77fc7b6-faa4-4c64-b18c-934ba3f913dd" || authObj.Username != "test-user" {
+		t.Fatal("Expected the mapped claims to be set in the auth")
+	}
+	if len(authObj.Roles) != 2 || authObj.Roles[1] != "admin" {
+		t.Fatal("Expected the nested roles to be mapped, got", authObj.Roles)
+	}
+
+	// the "userId" claim stays required with a custom mapping
+	middleware = NewJWTSecurityMiddlewareWithOptions(resolver, scheme, &SecurityOptions{
+		ClaimMapper: &auth.ClaimMapper{
+			Roles: "realm_access.roles",
+		},
+	})
+	ctx, _, err = middleware(context.Background(), nil, requestWithToken(tokenStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.HasAuth(ctx) {
+		t.Fatal("Expected the token to be rejected without the userId claim")
+	}
+
+	// the default mapping requires the "userId" claim
+	middleware = NewJWTSecurityMiddleware(resolver, scheme)
+	ctx, _, err = middleware(context.Background(), nil, requestWithToken(tokenStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.HasAuth(ctx) {
+		t.Fatal("Expected the token to be rejected without the userId claim")
+	}
+	if errs := auth.GetSecurityErrors(ctx); errs == nil || (*errs)[JWTSecurityType] == nil {
+		t.Fatal("Expected a JWT security error")
+	}
+}
+
+func TestJWTMiddlewareClaimsValidation(t *testing.T) {
+	key, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := goajwt.NewSimpleResolver([]goajwt.Key{&key.PublicKey})
+	middleware := NewJWTSecurityMiddlewareWithOptions(resolver, &goa.JWTSecurity{
+		In:   goa.LocHeader,
+		Name: "Authorization",
+	}, &SecurityOptions{
+		Validation: &ClaimsValidation{
+			Issuers:  []string{"http://issuer.jwt"},
+			Audience: []string{"user-service"},
+		},
+	})
+
+	tests := []struct {
+		audience string
+		code     string
+	}{
+		{"user-service", ""},
+		{"other-service", "invalid_audience"},
+	}
+	for _, test := range tests {
+		tokenStr, err := SignToken(map[string]interface{}{
+			"userId": "f77fc7b6-faa4-4c64-b18c-934ba3f913dd",
+			"iss":    "http://issuer.jwt",
+			"aud":    test.audience,
+		}, "RS256", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, _, err := middleware(context.Background(), nil, requestWithToken(tokenStr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.code == "" {
+			if !auth.HasAuth(ctx) {
+				t.Fatal("Expected the token to be accepted")
+			}
+			continue
+		}
+		errs := auth.GetSecurityErrors(ctx)
+		if errs == nil {
+			t.Fatal("Expected a security error")
+		}
+		goaErr, ok := (*errs)[JWTSecurityType].(*goa.ErrorResponse)
+		if !ok || goaErr.Code != test.code {
+			t.Fatalf("Expected %s error, got %v", test.code, (*errs)[JWTSecurityType])
+		}
+	}
+}
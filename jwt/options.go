@@ -0,0 +1,49 @@
+package jwt
+
+import (
+	"github.com/Microkubes/microservice-security/auth"
+)
+
+// SecurityOptions holds the optional settings of the token based (JWT and OAuth2) security middlewares.
+// The options can be read from the service configuration file (see flow.LoadExtendedSecurityConfig).
+type SecurityOptions struct {
+	// ClaimMapper maps the token claims to auth.Auth. It is merged over the default mapping of the
+	// middleware, so only the claims that differ from the defaults need to be set.
+	ClaimMapper *auth.ClaimMapper `json:"claimMapping,omitempty"`
+
+	// Validation holds the validation rules for the token claims (issuer, audience, token age...).
+	// If not set, only the "exp", "nbf" and "iat" claims are validated.
+	Validation *ClaimsValidation `json:"validation,omitempty"`
+}
+
+// DefaultClaimMapper returns the default claim mapping of the JWT security middleware. The claims
+// have the same names as the auth.Auth fields and the "userId" claim is required.
+func DefaultClaimMapper() *auth.ClaimMapper {
+	return &auth.ClaimMapper{
+		Required: []string{"userId"},
+	}
+}
+
+// claimMapper returns the configured ClaimMapper merged over the given default mapper, or the default mapper
+// if no ClaimMapper is configured.
+func (o *SecurityOptions) claimMapper(defaultMapper *auth.ClaimMapper) *auth.ClaimMapper {
+	if o == nil || o.ClaimMapper == nil {
+		return defaultMapper
+	}
+	return o.ClaimMapper.WithDefaults(defaultMapper)
+}
+
+// MapClaims maps the token claims to auth.Auth with the configured ClaimMapper, merged over the given
+// default mapper (see auth.ClaimMapper.WithDefaults). The fields required by the default mapper are
+// always required.
+func (o *SecurityOptions) MapClaims(claims map[string]interface{}, defaultMapper *auth.ClaimMapper) (*auth.Auth, error) {
+	return o.claimMapper(defaultMapper).MapClaims(claims)
+}
+
+// ValidateClaims validates the token claims with the configured ClaimsValidation.
+func (o *SecurityOptions) ValidateClaims(claims map[string]interface{}) error {
+	if o == nil {
+		return (*ClaimsValidation)(nil).Validate(claims)
+	}
+	return o.Validation.Validate(claims)
+}
@@ -0,0 +1,130 @@
+package jwt
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// ReloadingKeyResolver is a goajwt.KeyResolver that loads the public keys (*.pub files) from a
+// directory and reloads them when Reload is called, or periodically if a scan interval is set.
+// This allows the verifiers to pick up new keys without restarting. If the keys cannot be
+// reloaded, the previously loaded keys are kept. A key removed from the directory is still
+// selected for the grace period, so the tokens signed with it stay valid until they expire.
+type ReloadingKeyResolver struct {
+	// KeysDir is the directory holding the public keys.
+	KeysDir string
+
+	// GracePeriod is the period for which a key removed from the directory is kept.
+	GracePeriod time.Duration
+
+	mutex       sync.RWMutex
+	keys        []goajwt.Key
+	removedKeys []removedKey
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// removedKey is a key removed from the keys directory, kept until it expires.
+type removedKey struct {
+	key     goajwt.Key
+	expires time.Time
+}
+
+// NewReloadingKeyResolver creates a ReloadingKeyResolver for the keys directory. The keys are loaded
+// immediately and an error is returned if no keys can be loaded. If scanInterval is greater than 0,
+// the directory is rescanned periodically in the background until Stop is called. The keys removed
+// from the directory are kept for the gracePeriod.
+func NewReloadingKeyResolver(keysDir string, scanInterval, gracePeriod time.Duration) (*ReloadingKeyResolver, error) {
+	resolver := &ReloadingKeyResolver{
+		KeysDir:     keysDir,
+		GracePeriod: gracePeriod,
+		stop:        make(chan struct{}),
+	}
+	if err := resolver.Reload(); err != nil {
+		return nil, err
+	}
+	if scanInterval > 0 {
+		go resolver.scan(scanInterval)
+	}
+	return resolver, nil
+}
+
+func (r *ReloadingKeyResolver) scan(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Println("Failed to reload public keys from", r.KeysDir, "; Keeping the current keys. Err:", err.Error())
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Reload loads the public keys from the directory and replaces the current keys. The keys that are no
+// longer in the directory are kept until the grace period expires.
+func (r *ReloadingKeyResolver) Reload() error {
+	keys, err := LoadJWTPublicKeys(r.KeysDir)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	removedKeys := []removedKey{}
+	for _, removed := range r.removedKeys {
+		if now.Before(removed.expires) && !containsKey(keys, removed.key) {
+			removedKeys = append(removedKeys, removed)
+		}
+	}
+	for _, key := range r.keys {
+		if !containsKey(keys, key) && r.GracePeriod > 0 {
+			removedKeys = append(removedKeys, removedKey{key: key, expires: now.Add(r.GracePeriod)})
+		}
+	}
+	r.keys = keys
+	r.removedKeys = removedKeys
+	return nil
+}
+
+func containsKey(keys []goajwt.Key, key goajwt.Key) bool {
+	for _, k := range keys {
+		if reflect.DeepEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops the periodic rescan of the keys directory.
+func (r *ReloadingKeyResolver) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// SelectKeys returns the currently loaded public keys and the removed keys within the grace period.
+func (r *ReloadingKeyResolver) SelectKeys(req *http.Request) []goajwt.Key {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.removedKeys) == 0 {
+		return r.keys
+	}
+	keys := append([]goajwt.Key{}, r.keys...)
+	now := time.Now()
+	for _, removed := range r.removedKeys {
+		if now.Before(removed.expires) {
+			keys = append(keys, removed.key)
+		}
+	}
+	return keys
+}
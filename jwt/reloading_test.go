@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloadingKeyResolver(t *testing.T) {
+	keysDir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keysDir)
+
+	if err = generateRSAKeyPairInDir(keysDir, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := NewReloadingKeyResolver(keysDir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Stop()
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	if keys := resolver.SelectKeys(req); len(keys) != 1 {
+		t.Fatal("Expected one key, got", len(keys))
+	}
+
+	if err = generateRSAKeyPairInDir(keysDir, "key-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = resolver.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := resolver.SelectKeys(req); len(keys) != 2 {
+		t.Fatal("Expected the new key to be loaded, got", len(keys), "keys")
+	}
+
+	if err = ioutil.WriteFile(keysDir+"/broken.pub", []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = resolver.Reload(); err == nil {
+		t.Fatal("Expected reload to fail")
+	}
+	if keys := resolver.SelectKeys(req); len(keys) != 2 {
+		t.Fatal("Expected the current keys to be kept after failed reload")
+	}
+	if err = os.Remove(keysDir + "/broken.pub"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the removed key is kept for the grace period
+	if err = os.Remove(keysDir + "/key-1.pub"); err != nil {
+		t.Fatal(err)
+	}
+	if err = resolver.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := resolver.SelectKeys(req); len(keys) != 2 {
+		t.Fatal("Expected the removed key to be kept for the grace period, got", len(keys), "keys")
+	}
+	resolver.mutex.Lock()
+	resolver.removedKeys[0].expires = time.Now().Add(-time.Second)
+	resolver.mutex.Unlock()
+	if keys := resolver.SelectKeys(req); len(keys) != 1 {
+		t.Fatal("Expected the removed key to expire, got", len(keys), "keys")
+	}
+}
@@ -0,0 +1,137 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// ValidateToken parses the incoming JWT and validates its signature against the given keys.
+// The signing algorithm of the token must match the type of the key:
+//
+// * *rsa.PublicKey validates RS256/384/512 and PS256/384/512 tokens
+//
+// * *ecdsa.PublicKey validates ES256/384/512 tokens
+//
+// * ed25519.PublicKey validates EdDSA tokens
+//
+// * []byte or string (HMAC secret) validates HS256/384/512 tokens
+//
+// The first successfully validated token is returned. The "exp", "nbf" and "iat" claims are
+// validated without clock skew tolerance; use ValidateTokenWithClaims for more validation rules.
+func ValidateToken(incomingToken string, keys []goajwt.Key) (*jwtgo.Token, error) {
+	return ValidateTokenWithClaims(incomingToken, keys, nil)
+}
+
+// ValidateTokenWithClaims parses the incoming JWT, validates its signature like ValidateToken and
+// then validates the claims with the given ClaimsValidation.
+func ValidateTokenWithClaims(incomingToken string, keys []goajwt.Key, validation *ClaimsValidation) (*jwtgo.Token, error) {
+	token, err := parseToken(incomingToken, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err = validation.Validate(token.Claims.(jwtgo.MapClaims)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// parseToken parses the incoming JWT and validates only its signature.
+func parseToken(incomingToken string, keys []goajwt.Key) (*jwtgo.Token, error) {
+	var (
+		token *jwtgo.Token
+		err   = fmt.Errorf("no keys to validate the token with")
+	)
+	parser := &jwtgo.Parser{SkipClaimsValidation: true}
+	for _, key := range keys {
+		if k, ok := key.(string); ok {
+			key = []byte(k)
+		}
+		token, err = parser.Parse(incomingToken, func(token *jwtgo.Token) (interface{}, error) {
+			if !algorithmMatchesKey(token.Method.Alg(), key) {
+				return nil, fmt.Errorf("unexpected signing method %v for key type %T", token.Header["alg"], key)
+			}
+			return key, nil
+		})
+		if err == nil {
+			return token, nil
+		}
+	}
+	return nil, err
+}
+
+func algorithmMatchesKey(alg string, key goajwt.Key) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PublicKey:
+		return alg == SigningMethodEdDSA.Alg()
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	}
+	return false
+}
+
+// extractToken extracts the raw JWT from the request, as specified by the JWT security scheme.
+func extractToken(scheme *goa.JWTSecurity, req *http.Request) (string, error) {
+	switch scheme.In {
+	case goa.LocHeader:
+		val := req.Header.Get(scheme.Name)
+		if val == "" {
+			return "", goajwt.ErrJWTError(fmt.Sprintf("missing header %q", scheme.Name))
+		}
+		if !strings.HasPrefix(strings.ToLower(val), "bearer ") {
+			return "", goajwt.ErrJWTError(fmt.Sprintf("invalid or malformed %q header, expected 'Bearer JWT-token...'", scheme.Name))
+		}
+		return strings.TrimSpace(val[7:]), nil
+	case goa.LocQuery:
+		val := req.URL.Query().Get(scheme.Name)
+		if val == "" {
+			return "", goajwt.ErrJWTError(fmt.Sprintf("missing parameter %q", scheme.Name))
+		}
+		return val, nil
+	}
+	return "", fmt.Errorf("security scheme with location (in) %q not supported", scheme.In)
+}
+
+// parseClaimScopes parses the "scope" or "scopes" claim. The scopes may be a list of
+// strings or a single string with space-separated scopes.
+func parseClaimScopes(claims jwtgo.MapClaims) (map[string]bool, []string, error) {
+	scopesInClaim := map[string]bool{}
+	scopesInClaimList := []string{}
+	for _, claim := range []string{"scope", "scopes"} {
+		rawScopes, ok := claims[claim]
+		if !ok || rawScopes == nil {
+			continue
+		}
+		switch scopes := rawScopes.(type) {
+		case string:
+			for _, scope := range strings.Split(scopes, " ") {
+				scopesInClaim[scope] = true
+				scopesInClaimList = append(scopesInClaimList, scope)
+			}
+		case []interface{}:
+			for _, scope := range scopes {
+				if val, ok := scope.(string); ok {
+					scopesInClaim[val] = true
+					scopesInClaimList = append(scopesInClaimList, val)
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported scope format in incoming JWT claim, was type %T", scopes)
+		}
+		break
+	}
+	sort.Strings(scopesInClaimList)
+	return scopesInClaim, scopesInClaimList, nil
+}
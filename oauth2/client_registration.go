@@ -0,0 +1,355 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keitaroinc/goa"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// ClientSecretLength is the length of the generated client secrets.
+	ClientSecretLength = 48
+
+	// RegistrationAccessTokenLength is the length of the generated registration access tokens.
+	RegistrationAccessTokenLength = 48
+)
+
+const (
+	// AuthMethodClientSecretBasic is the token endpoint authentication with the client secret in the HTTP Basic
+	// authorization header. It is the default authentication method.
+	AuthMethodClientSecretBasic = "client_secret_basic"
+
+	// AuthMethodClientSecretPost is the token endpoint authentication with the client secret in the form parameters.
+	AuthMethodClientSecretPost = "client_secret_post"
+
+	// AuthMethodNone is used by public clients, which have no client secret and must use PKCE.
+	AuthMethodNone = "none"
+)
+
+// OAuth2ErrorInvalidClientMetadata is Bad Request error for invalid client metadata in the client registration
+var OAuth2ErrorInvalidClientMetadata = goa.NewErrorClass("invalid_client_metadata", 400)
+
+// OAuth2ErrorInvalidClientRedirectURI is Bad Request error for an invalid redirect URI in the client registration
+var OAuth2ErrorInvalidClientRedirectURI = goa.NewErrorClass("invalid_redirect_uri", 400)
+
+// ClientRegistrationService is a ClientService that can store the clients. It is required by the dynamic
+// client registration and by AuthProvider.RotateClientSecret.
+type ClientRegistrationService interface {
+	ClientService
+
+	// SaveClient creates the Client, or replaces an existing one with the same ClientID.
+	SaveClient(client *Client) error
+
+	// DeleteClient deletes the Client.
+	DeleteClient(clientID string) error
+}
+
+// ClientMetadata holds the client metadata sent in a client registration request (RFC 7591 section 2).
+type ClientMetadata struct {
+	// RedirectURIs is the list of redirect URIs of the client.
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+
+	// TokenEndpointAuthMethod is the authentication method of the client - AuthMethodClientSecretBasic (default),
+	// AuthMethodClientSecretPost or AuthMethodNone for public clients.
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+
+	// ClientName is the name of the client, shown to the user.
+	ClientName string `json:"client_name,omitempty"`
+
+	// ClientURI is the URL of the home page of the client.
+	ClientURI string `json:"client_uri,omitempty"`
+
+	// Scope is the space-delimited list of scopes the client may request in the client credentials grant.
+	Scope string `json:"scope,omitempty"`
+
+	// GrantTypes is the list of grant types the client may use - "authorization_code" (default), "refresh_token",
+	// "client_credentials" (confidential clients only) and DeviceCodeGrantType. The client may refresh the tokens
+	// only if "refresh_token" is registered.
+	GrantTypes []string `json:"grant_types,omitempty"`
+}
+
+// registrableGrantTypes are the grant types a client may register.
+var registrableGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrantType}
+
+// ClientRegistration is the client information response (RFC 7591 section 3.2.1). The ClientSecret and the
+// RegistrationAccessToken are returned only when they are issued, because only their hashes are stored.
+type ClientRegistration struct {
+	ClientMetadata
+
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+}
+
+// HashClientSecret hashes a client secret (or a registration access token) for storing in Client.SecretHash.
+// The generated secrets are long random strings, so a SHA-256 hash is sufficient to protect them.
+func HashClientSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// VerifySecret checks the client secret against the SecretHash, or against the plain text Secret of clients
+// registered before the secrets were hashed. Clients without a secret never verify.
+func (client *Client) VerifySecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if client.SecretHash != "" {
+		return subtle.ConstantTimeCompare([]byte(HashClientSecret(secret)), []byte(client.SecretHash)) == 1
+	}
+	return client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) == 1
+}
+
+// RegisterClient registers a new client with the metadata (RFC 7591). A client secret is issued unless the
+// client is public (AuthMethodNone), in which case PKCE is required. The returned ClientRegistration holds the
+// client secret and the registration access token used to manage the registration; neither can be retrieved
+// later, only their hashes are stored.
+func (provider *AuthProvider) RegisterClient(metadata *ClientMetadata) (*ClientRegistration, error) {
+	registrationService, err := provider.clientRegistrationService()
+	if err != nil {
+		return nil, err
+	}
+
+	clientUUID, err := uuid.NewV4()
+	if err != nil {
+		return nil, InternalServerError(err)
+	}
+	client := &Client{
+		ClientID: clientUUID.String(),
+		IssuedAt: time.Now().Unix(),
+	}
+	if err = provider.applyClientMetadata(client, metadata); err != nil {
+		return nil, err
+	}
+
+	registration := &ClientRegistration{}
+	if client.TokenEndpointAuthMethod != AuthMethodNone {
+		if registration.ClientSecret, err = setClientSecret(client); err != nil {
+			return nil, InternalServerError(err)
+		}
+	}
+	registrationAccessToken, err := generateClientCredential(RegistrationAccessTokenLength)
+	if err != nil {
+		return nil, InternalServerError(err)
+	}
+	client.RegistrationAccessTokenHash = HashClientSecret(registrationAccessToken)
+	registration.RegistrationAccessToken = registrationAccessToken
+
+	if err = registrationService.SaveClient(client); err != nil {
+		return nil, InternalServerError("Failed to save the client", err)
+	}
+	return clientRegistration(client, registration), nil
+}
+
+// GetClientRegistration returns the registration of the client (RFC 7592 section 2.1). The client is
+// authenticated with the registration access token issued by RegisterClient.
+func (provider *AuthProvider) GetClientRegistration(clientID, registrationAccessToken string) (*ClientRegistration, error) {
+	client, _, err := provider.registeredClient(clientID, registrationAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return clientRegistration(client, &ClientRegistration{}), nil
+}
+
+// UpdateClientRegistration replaces the metadata of the client (RFC 7592 section 2.2). The client is
+// authenticated with the registration access token. A client secret is issued if a public client changes to
+// a confidential one, and the secret is removed if a confidential client changes to a public one.
+func (provider *AuthProvider) UpdateClientRegistration(clientID, registrationAccessToken string, metadata *ClientMetadata) (*ClientRegistration, error) {
+	client, registrationService, err := provider.registeredClient(clientID, registrationAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.applyClientMetadata(client, metadata); err != nil {
+		return nil, err
+	}
+
+	registration := &ClientRegistration{}
+	if client.TokenEndpointAuthMethod == AuthMethodNone {
+		client.Secret = ""
+		client.SecretHash = ""
+	} else if client.SecretHash == "" && client.Secret == "" {
+		if registration.ClientSecret, err = setClientSecret(client); err != nil {
+			return nil, InternalServerError(err)
+		}
+	}
+
+	if err = registrationService.SaveClient(client); err != nil {
+		return nil, InternalServerError("Failed to save the client", err)
+	}
+	return clientRegistration(client, registration), nil
+}
+
+// DeleteClientRegistration deletes the client (RFC 7592 section 2.3). The client is authenticated with the
+// registration access token.
+func (provider *AuthProvider) DeleteClientRegistration(clientID, registrationAccessToken string) error {
+	_, registrationService, err := provider.registeredClient(clientID, registrationAccessToken)
+	if err != nil {
+		return err
+	}
+	if err = registrationService.DeleteClient(clientID); err != nil {
+		return InternalServerError("Failed to delete the client", err)
+	}
+	return nil
+}
+
+// RotateClientSecret issues a new secret for the client and returns it. The previous secret is invalidated
+// immediately. It can also be used to migrate a client with a plain text Secret to a hashed one.
+// This is an administrative operation - the caller is responsible for authorizing it.
+func (provider *AuthProvider) RotateClientSecret(clientID string) (string, error) {
+	registrationService, err := provider.clientRegistrationService()
+	if err != nil {
+		return "", err
+	}
+	client, err := registrationService.GetClient(clientID)
+	if err != nil {
+		return "", InternalServerError(err)
+	}
+	if client == nil {
+		return "", OAuth2ErrorInvalidClient("invalid client")
+	}
+	if client.TokenEndpointAuthMethod == AuthMethodNone {
+		return "", OAuth2ErrorInvalidRequest("public clients have no client secret")
+	}
+
+	secret, err := setClientSecret(client)
+	if err != nil {
+		return "", InternalServerError(err)
+	}
+	if err = registrationService.SaveClient(client); err != nil {
+		return "", InternalServerError("Failed to save the client", err)
+	}
+	return secret, nil
+}
+
+func (provider *AuthProvider) clientRegistrationService() (ClientRegistrationService, error) {
+	registrationService, ok := provider.ClientService.(ClientRegistrationService)
+	if !ok {
+		return nil, InternalServerError("client registration requires a ClientRegistrationService")
+	}
+	return registrationService, nil
+}
+
+// registeredClient looks up a dynamically registered client and verifies the registration access token.
+// Unknown clients and invalid tokens are both reported as OAuth2ErrorInvalidToken (RFC 7592 section 3).
+func (provider *AuthProvider) registeredClient(clientID, registrationAccessToken string) (*Client, ClientRegistrationService, error) {
+	registrationService, err := provider.clientRegistrationService()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := registrationService.GetClient(clientID)
+	if err != nil {
+		return nil, nil, InternalServerError(err)
+	}
+	if client == nil || client.RegistrationAccessTokenHash == "" || registrationAccessToken == "" ||
+		subtle.ConstantTimeCompare([]byte(HashClientSecret(registrationAccessToken)), []byte(client.RegistrationAccessTokenHash)) != 1 {
+		return nil, nil, OAuth2ErrorInvalidToken("invalid registration access token")
+	}
+	return client, registrationService, nil
+}
+
+// applyClientMetadata validates the metadata and sets it on the client.
+func (provider *AuthProvider) applyClientMetadata(client *Client, metadata *ClientMetadata) error {
+	for _, redirectURI := range metadata.RedirectURIs {
+		if err := validateClientRedirectURI(redirectURI); err != nil {
+			return OAuth2ErrorInvalidClientRedirectURI(fmt.Sprintf("invalid redirect URI %s: %s", redirectURI, err))
+		}
+	}
+
+	authMethod := metadata.TokenEndpointAuthMethod
+	switch authMethod {
+	case "":
+		authMethod = AuthMethodClientSecretBasic
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodNone:
+	default:
+		return OAuth2ErrorInvalidClientMetadata(fmt.Sprintf("unsupported token endpoint auth method %s", authMethod))
+	}
+
+	grantTypes := metadata.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{"authorization_code"}
+	}
+	for _, grantType := range grantTypes {
+		if !containsString(registrableGrantTypes, grantType) {
+			return OAuth2ErrorInvalidClientMetadata(fmt.Sprintf("unsupported grant type %s", grantType))
+		}
+	}
+	if authMethod == AuthMethodNone && containsString(grantTypes, "client_credentials") {
+		return OAuth2ErrorInvalidClientMetadata("public clients cannot use the client credentials grant")
+	}
+
+	scopes := ParseScope(metadata.Scope)
+	if s := missingScope(provider.ClientRegistrationScopes, scopes); s != "" {
+		return OAuth2ErrorInvalidClientMetadata(fmt.Sprintf("scope %s cannot be registered", s))
+	}
+
+	client.Name = metadata.ClientName
+	client.Website = metadata.ClientURI
+	client.RedirectURIs = metadata.RedirectURIs
+	client.Scopes = scopes
+	client.TokenEndpointAuthMethod = authMethod
+	client.GrantTypes = grantTypes
+	client.RequirePKCE = authMethod == AuthMethodNone
+	return nil
+}
+
+// validateClientRedirectURI checks that the redirect URI is an absolute URI without a fragment
+// (RFC 6749 section 3.1.2).
+func validateClientRedirectURI(redirectURI string) error {
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		return err
+	}
+	if !redirectURL.IsAbs() || redirectURL.Host == "" {
+		return fmt.Errorf("must be an absolute URI")
+	}
+	if redirectURL.Fragment != "" {
+		return fmt.Errorf("must not contain a fragment")
+	}
+	return nil
+}
+
+// setClientSecret generates a new secret for the client and stores its hash. The plain text Secret is removed.
+func setClientSecret(client *Client) (string, error) {
+	secret, err := generateClientCredential(ClientSecretLength)
+	if err != nil {
+		return "", err
+	}
+	client.Secret = ""
+	client.SecretHash = HashClientSecret(secret)
+	return secret, nil
+}
+
+// generateClientCredential generates a random client secret or registration access token. The characters are
+// URL safe, so the value is not altered by the form encoding of the HTTP Basic credentials.
+func generateClientCredential(n int) (string, error) {
+	code, err := GenerateRandomCode(n)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("+", "-", "/", "_").Replace(code), nil
+}
+
+// clientRegistration fills in the client information response with the metadata of the client.
+func clientRegistration(client *Client, registration *ClientRegistration) *ClientRegistration {
+	registration.ClientID = client.ClientID
+	registration.ClientIDIssuedAt = client.IssuedAt
+	registration.ClientMetadata = ClientMetadata{
+		RedirectURIs:            client.RedirectURIs,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		ClientName:              client.Name,
+		ClientURI:               client.Website,
+		Scope:                   strings.Join(client.Scopes, " "),
+		GrantTypes:              client.GrantTypes,
+	}
+	return registration
+}
@@ -0,0 +1,130 @@
+package oauth2
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientRegistrationPath is the conventional path of the client registration endpoint.
+const ClientRegistrationPath = "/oauth2/register"
+
+// ClientRegistrationOptions holds the options of the client registration endpoint.
+type ClientRegistrationOptions struct {
+	// RegistrationEndpoint is the URL of the client registration endpoint. The registration_client_uri of a client
+	// is this URL with the "client_id" query parameter.
+	RegistrationEndpoint string
+
+	// InitialAccessToken restricts the registration of new clients to the callers that send it as a "Bearer" token
+	// (RFC 7591 section 3). Required unless AllowOpenRegistration is set.
+	InitialAccessToken string
+
+	// AllowOpenRegistration allows anyone to register a client when no InitialAccessToken is set.
+	AllowOpenRegistration bool
+}
+
+// NewClientRegistrationHandler creates an http.Handler for the dynamic client registration endpoint (RFC 7591)
+// and the client configuration endpoint (RFC 7592).
+// A POST request with the client metadata (JSON) registers a new client with AuthProvider.RegisterClient.
+// GET, PUT and DELETE requests with the "client_id" query parameter read, update and delete the registration,
+// authenticated with the registration access token as a "Bearer" token.
+// It panics if the options have neither an InitialAccessToken nor AllowOpenRegistration set.
+func NewClientRegistrationHandler(provider *AuthProvider, options *ClientRegistrationOptions) http.Handler {
+	if options.InitialAccessToken == "" && !options.AllowOpenRegistration {
+		panic("the client registration requires an InitialAccessToken, or AllowOpenRegistration")
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		clientID := req.URL.Query().Get("client_id")
+		if clientID == "" {
+			if req.Method != http.MethodPost {
+				rw.Header().Set("Allow", http.MethodPost)
+				http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			registerClient(provider, options, rw, req)
+			return
+		}
+
+		registrationAccessToken, ok := bearerToken(req)
+		if !ok {
+			writeBearerError(rw, OAuth2ErrorInvalidToken("the registration access token is required"))
+			return
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+			registration, err := provider.GetClientRegistration(clientID, registrationAccessToken)
+			writeClientRegistration(rw, options, registration, http.StatusOK, err)
+		case http.MethodPut:
+			update := &ClientRegistration{}
+			if err := json.NewDecoder(req.Body).Decode(update); err != nil {
+				writeOAuth2Error(rw, OAuth2ErrorInvalidClientMetadata("invalid client metadata"))
+				return
+			}
+			if update.ClientID != "" && update.ClientID != clientID {
+				writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("the client_id does not match"))
+				return
+			}
+			registration, err := provider.UpdateClientRegistration(clientID, registrationAccessToken, &update.ClientMetadata)
+			writeClientRegistration(rw, options, registration, http.StatusOK, err)
+		case http.MethodDelete:
+			if err := provider.DeleteClientRegistration(clientID, registrationAccessToken); err != nil {
+				writeBearerError(rw, err)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		default:
+			rw.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func registerClient(provider *AuthProvider, options *ClientRegistrationOptions, rw http.ResponseWriter, req *http.Request) {
+	if options.InitialAccessToken != "" {
+		token, _ := bearerToken(req)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(options.InitialAccessToken)) != 1 {
+			writeBearerError(rw, OAuth2ErrorInvalidToken("invalid initial access token"))
+			return
+		}
+	}
+
+	metadata := &ClientMetadata{}
+	if err := json.NewDecoder(req.Body).Decode(metadata); err != nil {
+		writeOAuth2Error(rw, OAuth2ErrorInvalidClientMetadata("invalid client metadata"))
+		return
+	}
+	registration, err := provider.RegisterClient(metadata)
+	writeClientRegistration(rw, options, registration, http.StatusCreated, err)
+}
+
+// writeClientRegistration writes the client information response, or the error.
+func writeClientRegistration(rw http.ResponseWriter, options *ClientRegistrationOptions, registration *ClientRegistration, status int, err error) {
+	if err != nil {
+		writeBearerError(rw, err)
+		return
+	}
+	if options.RegistrationEndpoint != "" {
+		if registrationURL, err := url.Parse(options.RegistrationEndpoint); err == nil {
+			query := registrationURL.Query()
+			query.Set("client_id", registration.ClientID)
+			registrationURL.RawQuery = query.Encode()
+			registration.RegistrationClientURI = registrationURL.String()
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(registration)
+}
+
+// bearerToken reads the "Bearer" token from the Authorization header.
+func bearerToken(req *http.Request) (string, bool) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 8 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[7:]), true
+}
@@ -0,0 +1,90 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientRegistrationHandler(t *testing.T) {
+	provider := getRegistrationProvider(t)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Expected a panic without an initial access token")
+			}
+		}()
+		NewClientRegistrationHandler(provider, &ClientRegistrationOptions{})
+	}()
+
+	handler := NewClientRegistrationHandler(provider, &ClientRegistrationOptions{
+		RegistrationEndpoint: "https://auth.example.com" + ClientRegistrationPath,
+		InitialAccessToken:   "initial-token",
+	})
+
+	body := `{"client_name": "test-app", "redirect_uris": ["https://app.example.com/callback"]}`
+	req := httptest.NewRequest(http.MethodPost, ClientRegistrationPath, strings.NewReader(body))
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatal("Expected status 401 without the initial access token, got", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, ClientRegistrationPath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer initial-token")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusCreated {
+		t.Fatal("Expected status 201, got", rw.Code, rw.Body.String())
+	}
+	registration := &ClientRegistration{}
+	if err := json.NewDecoder(rw.Body).Decode(registration); err != nil {
+		t.Fatal(err)
+	}
+	if registration.ClientSecret == "" || registration.RegistrationAccessToken == "" ||
+		registration.RegistrationClientURI != "https://auth.example.com"+ClientRegistrationPath+"?client_id="+registration.ClientID {
+		t.Fatal("Unexpected registration response", registration)
+	}
+
+	configurationPath := ClientRegistrationPath + "?client_id=" + registration.ClientID
+	req = httptest.NewRequest(http.MethodGet, configurationPath, nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized || !strings.Contains(rw.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatal("Expected status 401 with invalid_token, got", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, configurationPath, strings.NewReader(`{"client_name": "renamed-app", "client_id": "other"}`))
+	req.Header.Set("Authorization", "Bearer "+registration.RegistrationAccessToken)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatal("Expected status 400 for a different client_id, got", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, configurationPath, strings.NewReader(`{"client_name": "renamed-app"}`))
+	req.Header.Set("Authorization", "Bearer "+registration.RegistrationAccessToken)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"client_name":"renamed-app"`) {
+		t.Fatal("Expected the client to be updated, got", rw.Code, rw.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, configurationPath, nil)
+	req.Header.Set("Authorization", "Bearer "+registration.RegistrationAccessToken)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatal("Expected status 204, got", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, ClientRegistrationPath, nil)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatal("Expected status 405, got", rw.Code)
+	}
+}
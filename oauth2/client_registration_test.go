@@ -0,0 +1,181 @@
+package oauth2
+
+import (
+	"testing"
+)
+
+func getRegistrationProvider(t *testing.T) *AuthProvider {
+	provider := getMockedProvider(t)
+	provider.ClientService = NewMemoryClientService()
+	provider.ClientRegistrationScopes = []string{"api:read", "api:write"}
+	return provider
+}
+
+func TestVerifySecret(t *testing.T) {
+	client := &Client{SecretHash: HashClientSecret("secret")}
+	if !client.VerifySecret("secret") || client.VerifySecret("wrong") || client.VerifySecret("") {
+		t.Fatal("Expected the secret to be verified against the hash")
+	}
+	legacy := &Client{Secret: "secret"}
+	if !legacy.VerifySecret("secret") || legacy.VerifySecret("wrong") {
+		t.Fatal("Expected the plain text secret to be verified")
+	}
+	if (&Client{}).VerifySecret("") {
+		t.Fatal("Expected a client without a secret not to verify")
+	}
+}
+
+func TestRegisterClient(t *testing.T) {
+	provider := getRegistrationProvider(t)
+
+	_, err := provider.RegisterClient(&ClientMetadata{RedirectURIs: []string{"/callback"}})
+	expectOAuth2Error(t, err, "invalid_redirect_uri")
+	_, err = provider.RegisterClient(&ClientMetadata{TokenEndpointAuthMethod: "private_key_jwt"})
+	expectOAuth2Error(t, err, "invalid_client_metadata")
+	_, err = provider.RegisterClient(&ClientMetadata{Scope: "api:read api:admin"})
+	expectOAuth2Error(t, err, "invalid_client_metadata")
+	_, err = provider.RegisterClient(&ClientMetadata{GrantTypes: []string{"password"}})
+	expectOAuth2Error(t, err, "invalid_client_metadata")
+	_, err = provider.RegisterClient(&ClientMetadata{
+		TokenEndpointAuthMethod: AuthMethodNone,
+		GrantTypes:              []string{"client_credentials"},
+	})
+	expectOAuth2Error(t, err, "invalid_client_metadata")
+
+	registration, err := provider.RegisterClient(&ClientMetadata{
+		ClientName:   "test-app",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		Scope:        "api:read",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registration.ClientID == "" || registration.ClientSecret == "" || registration.RegistrationAccessToken == "" {
+		t.Fatal("Expected the client credentials to be issued", registration)
+	}
+	if registration.TokenEndpointAuthMethod != AuthMethodClientSecretBasic || registration.Scope != "api:read" {
+		t.Fatal("Unexpected client metadata", registration.ClientMetadata)
+	}
+	if len(registration.GrantTypes) != 1 || registration.GrantTypes[0] != "authorization_code" {
+		t.Fatal("Expected the authorization code grant by default", registration.GrantTypes)
+	}
+	_, _, err = provider.ClientCredentials(registration.ClientID, registration.ClientSecret, "")
+	expectOAuth2Error(t, err, "unauthorized_client")
+
+	service, err := provider.RegisterClient(&ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "api:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = provider.ClientCredentials(service.ClientID, service.ClientSecret, ""); err != nil {
+		t.Fatal("Expected the client credentials grant to be allowed", err)
+	}
+
+	client, err := provider.ClientService.GetClient(registration.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Secret != "" || client.SecretHash == registration.ClientSecret {
+		t.Fatal("Expected only the hash of the secret to be stored")
+	}
+	if err = provider.Authenticate(registration.ClientID, registration.ClientSecret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.Authorize(registration.ClientID, "api:read", "https://app.example.com/callback"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client_uri is not a redirect URI
+	noRedirect, err := provider.RegisterClient(&ClientMetadata{ClientURI: "https://evil.example.com/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Authorize(noRedirect.ClientID, "", "https://evil.example.com/cb")
+	expectOAuth2Error(t, err, "invalid_request")
+
+	public, err := provider.RegisterClient(&ClientMetadata{TokenEndpointAuthMethod: AuthMethodNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.ClientSecret != "" {
+		t.Fatal("Expected no secret for a public client")
+	}
+	if client, _ = provider.ClientService.GetClient(public.ClientID); !client.RequirePKCE {
+		t.Fatal("Expected PKCE to be required for a public client")
+	}
+	if err = provider.Authenticate(public.ClientID, ""); err == nil {
+		t.Fatal("Expected a public client not to authenticate")
+	}
+}
+
+func TestManageClientRegistration(t *testing.T) {
+	provider := getRegistrationProvider(t)
+	registration, err := provider.RegisterClient(&ClientMetadata{ClientName: "test-app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID, token := registration.ClientID, registration.RegistrationAccessToken
+
+	_, err = provider.GetClientRegistration(clientID, "wrong")
+	expectOAuth2Error(t, err, "invalid_token")
+	_, err = provider.GetClientRegistration("unknown", token)
+	expectOAuth2Error(t, err, "invalid_token")
+
+	read, err := provider.GetClientRegistration(clientID, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.ClientName != "test-app" || read.ClientSecret != "" || read.RegistrationAccessToken != "" {
+		t.Fatal("Expected the metadata without the credentials", read)
+	}
+
+	updated, err := provider.UpdateClientRegistration(clientID, token, &ClientMetadata{
+		ClientName:              "renamed-app",
+		TokenEndpointAuthMethod: AuthMethodNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ClientName != "renamed-app" || updated.TokenEndpointAuthMethod != AuthMethodNone {
+		t.Fatal("Expected the metadata to be updated", updated)
+	}
+	if err = provider.Authenticate(clientID, registration.ClientSecret); err == nil {
+		t.Fatal("Expected the secret to be removed from a public client")
+	}
+
+	updated, err = provider.UpdateClientRegistration(clientID, token, &ClientMetadata{ClientName: "renamed-app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.Authenticate(clientID, updated.ClientSecret); err != nil {
+		t.Fatal("Expected a new secret for a confidential client", err)
+	}
+
+	if err = provider.DeleteClientRegistration(clientID, token); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ := provider.ClientService.GetClient(clientID); client != nil {
+		t.Fatal("Expected the client to be deleted")
+	}
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	provider := getRegistrationProvider(t)
+	provider.ClientService.(*MemoryClientService).SaveClient(&Client{ClientID: "001", Secret: "xyz"})
+
+	secret, err := provider.RotateClientSecret("001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.Authenticate("001", "xyz"); err == nil {
+		t.Fatal("Expected the previous secret to be invalidated")
+	}
+	if err = provider.Authenticate("001", secret); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ := provider.ClientService.GetClient("001"); client.Secret != "" {
+		t.Fatal("Expected the plain text secret to be removed")
+	}
+
+	_, err = provider.RotateClientSecret("unknown")
+	expectOAuth2Error(t, err, "invalid_client")
+}
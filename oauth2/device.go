@@ -0,0 +1,332 @@
+package oauth2
+
+import (
+	"strings"
+	"time"
+
+	"github.com/keitaroinc/goa"
+)
+
+const (
+	// DeviceCodeGrantType is the grant type of the token request in the device authorization grant (RFC 8628).
+	DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// DefaultDeviceCodeValidityPeriod is the default validity period of the device code in milliseconds (10 minutes).
+	DefaultDeviceCodeValidityPeriod = 10 * 60 * 1000
+
+	// DefaultDevicePollingInterval is the default minimal time in seconds between two token requests of a device.
+	DefaultDevicePollingInterval = 5
+
+	// DeviceCodeLength is the length of the generated device code.
+	DeviceCodeLength = 40
+
+	// userCodeCharset is the set of characters of the user code - upper case consonants, which are easy to
+	// type and cannot form words (RFC 8628 section 6.1).
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+	// userCodeLength is the length of the user code, without the separator.
+	userCodeLength = 8
+
+	// base64Charset is the alphabet of the codes generated by GenerateRandomCode.
+	base64Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+const (
+	// DeviceAuthorizationPending is the status of a device authorization waiting for the user.
+	DeviceAuthorizationPending = "pending"
+
+	// DeviceAuthorizationApproved is the status of a device authorization approved by the user.
+	DeviceAuthorizationApproved = "approved"
+
+	// DeviceAuthorizationDenied is the status of a device authorization denied by the user.
+	DeviceAuthorizationDenied = "denied"
+)
+
+// OAuth2ErrorAuthorizationPending is returned to a device polling for a token before the user has approved the request
+var OAuth2ErrorAuthorizationPending = goa.NewErrorClass("authorization_pending", 400)
+
+// OAuth2ErrorSlowDown is returned to a device polling for a token more often than the polling interval
+var OAuth2ErrorSlowDown = goa.NewErrorClass("slow_down", 400)
+
+// OAuth2ErrorExpiredToken is returned for an expired device code
+var OAuth2ErrorExpiredToken = goa.NewErrorClass("expired_token", 400)
+
+// DeviceAuthorization is a device authorization request (RFC 8628) of a client (usually a CLI) that cannot
+// redirect the user to the authorization server. The user approves the request by entering the UserCode at
+// the verification URI, while the client polls for the token with the DeviceCode.
+type DeviceAuthorization struct {
+	// DeviceCode is the code the client uses to poll for the token.
+	DeviceCode string `json:"deviceCode" bson:"deviceCode"`
+
+	// UserCode is the code the user enters at the verification URI.
+	UserCode string `json:"userCode" bson:"userCode"`
+
+	// ClientID is the ID of the client that requested the authorization.
+	ClientID string `json:"clientId" bson:"clientId"`
+
+	// Scope is the requested scope.
+	Scope string `json:"scope,omitempty" bson:"scope"`
+
+	// ExpiresAt is the Unix timestamp of the time when the device code and the user code expire.
+	ExpiresAt int64 `json:"expiresAt" bson:"expiresAt"`
+
+	// Interval is the minimal time in seconds between two token requests. It is increased every time the
+	// client polls too often.
+	Interval int `json:"interval" bson:"interval"`
+
+	// LastPolledAt is the Unix timestamp of the last token request.
+	LastPolledAt int64 `json:"lastPolledAt,omitempty" bson:"lastPolledAt"`
+
+	// Status is the status of the authorization - DeviceAuthorizationPending, DeviceAuthorizationApproved or
+	// DeviceAuthorizationDenied.
+	Status string `json:"status" bson:"status"`
+
+	// UserID is the ID of the user that approved the authorization.
+	UserID string `json:"userId,omitempty" bson:"userId"`
+
+	// UserData is the serialized data of the user that approved the authorization, the same as in ClientAuth.
+	UserData string `json:"userData,omitempty" bson:"userData"`
+}
+
+// DeviceAuthorizationService defines the interface for storing device authorizations.
+type DeviceAuthorizationService interface {
+	// SaveDeviceAuthorization stores the device authorization, replacing an existing one with the same DeviceCode.
+	SaveDeviceAuthorization(deviceAuth *DeviceAuthorization) error
+
+	// GetDeviceAuthorization retrieves the device authorization by the device code, or nil if there is no such
+	// authorization.
+	GetDeviceAuthorization(deviceCode string) (*DeviceAuthorization, error)
+
+	// GetDeviceAuthorizationByUserCode retrieves the device authorization by the user code, or nil if there is
+	// no such authorization.
+	GetDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorization, error)
+
+	// DeleteDeviceAuthorization deletes the device authorization.
+	DeleteDeviceAuthorization(deviceCode string) error
+
+	// ConsumeDeviceAuthorization deletes the approved device authorization, so the device code can be exchanged
+	// for tokens. It must be atomic: it returns true only for the first call for a device code, and false if
+	// the device authorization has already been consumed or does not exist.
+	ConsumeDeviceAuthorization(deviceCode string) (bool, error)
+}
+
+// DeviceAuthorize creates a device authorization request for the client (RFC 8628 section 3.1).
+// A confidential client must authenticate with its client secret; a public client sends only the client ID.
+// The client must display the UserCode and the DeviceVerificationURI to the user and poll for the token with
+// ExchangeDeviceCode.
+func (provider *AuthProvider) DeviceAuthorize(clientID, clientSecret, scope string) (*DeviceAuthorization, error) {
+	if provider.DeviceAuthorizationService == nil {
+		return nil, InternalServerError("device authorization is not configured")
+	}
+	if err := provider.authenticateDeviceClient(clientID, clientSecret); err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := GenerateRandomCode(DeviceCodeLength)
+	if err != nil {
+		return nil, InternalServerError("Failed to generate device code", err)
+	}
+	userCode, err := GenerateUserCode()
+	if err != nil {
+		return nil, InternalServerError("Failed to generate user code", err)
+	}
+
+	validityPeriod := provider.DeviceCodeValidityPeriod
+	if validityPeriod <= 0 {
+		validityPeriod = DefaultDeviceCodeValidityPeriod
+	}
+	interval := provider.DevicePollingInterval
+	if interval <= 0 {
+		interval = DefaultDevicePollingInterval
+	}
+
+	deviceAuth := &DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Scope:      scope,
+		ExpiresAt:  time.Now().Add(time.Duration(validityPeriod) * time.Millisecond).Unix(),
+		Interval:   interval,
+		Status:     DeviceAuthorizationPending,
+	}
+	if err = provider.DeviceAuthorizationService.SaveDeviceAuthorization(deviceAuth); err != nil {
+		return nil, InternalServerError("Failed to save the device authorization", err)
+	}
+	return deviceAuth, nil
+}
+
+// LookupUserCode returns the pending device authorization for the user code entered by the user, so the
+// client and the requested scope can be shown to the user before the approval.
+func (provider *AuthProvider) LookupUserCode(userCode string) (*DeviceAuthorization, error) {
+	if provider.DeviceAuthorizationService == nil {
+		return nil, InternalServerError("device authorization is not configured")
+	}
+	deviceAuth, err := provider.DeviceAuthorizationService.GetDeviceAuthorizationByUserCode(NormalizeUserCode(userCode))
+	if err != nil {
+		return nil, InternalServerError("Failed to look up the user code", err)
+	}
+	if deviceAuth == nil || deviceAuth.Status != DeviceAuthorizationPending {
+		return nil, OAuth2ErrorInvalidGrant("invalid user code")
+	}
+	if time.Now().Unix() > deviceAuth.ExpiresAt {
+		return nil, OAuth2ErrorExpiredToken("the user code has expired")
+	}
+	return deviceAuth, nil
+}
+
+// ApproveDeviceAuthorization approves the device authorization with the user code on behalf of the logged in user.
+// The userData is the serialized user data (the same as in ClientAuth) used to issue the tokens to the device.
+func (provider *AuthProvider) ApproveDeviceAuthorization(userCode, userID, userData string) error {
+	deviceAuth, err := provider.LookupUserCode(userCode)
+	if err != nil {
+		return err
+	}
+	deviceAuth.Status = DeviceAuthorizationApproved
+	deviceAuth.UserID = userID
+	deviceAuth.UserData = userData
+	if err = provider.DeviceAuthorizationService.SaveDeviceAuthorization(deviceAuth); err != nil {
+		return InternalServerError("Failed to save the device authorization", err)
+	}
+	return nil
+}
+
+// DenyDeviceAuthorization denies the device authorization with the user code.
+func (provider *AuthProvider) DenyDeviceAuthorization(userCode string) error {
+	deviceAuth, err := provider.LookupUserCode(userCode)
+	if err != nil {
+		return err
+	}
+	deviceAuth.Status = DeviceAuthorizationDenied
+	if err = provider.DeviceAuthorizationService.SaveDeviceAuthorization(deviceAuth); err != nil {
+		return InternalServerError("Failed to save the device authorization", err)
+	}
+	return nil
+}
+
+// ExchangeDeviceCode exchanges the device code for an access token and refresh token, once the user has approved
+// the device authorization (RFC 8628 section 3.4). Until then OAuth2ErrorAuthorizationPending is returned.
+// A client polling more often than the interval gets OAuth2ErrorSlowDown and the interval is increased by 5 seconds.
+// An expired device code returns OAuth2ErrorExpiredToken and a denied authorization returns OAuth2AccessDenied.
+// The device code can be exchanged only once. A confidential client must authenticate with its client secret.
+func (provider *AuthProvider) ExchangeDeviceCode(clientID, clientSecret, deviceCode string) (refreshToken, accessToken string, expiresIn int, err error) {
+	if provider.DeviceAuthorizationService == nil {
+		return "", "", 0, InternalServerError("device authorization is not configured")
+	}
+	if err = provider.authenticateDeviceClient(clientID, clientSecret); err != nil {
+		return "", "", 0, err
+	}
+	deviceAuth, err := provider.DeviceAuthorizationService.GetDeviceAuthorization(deviceCode)
+	if err != nil {
+		return "", "", 0, InternalServerError("Failed to look up the device code", err)
+	}
+	if deviceAuth == nil || deviceAuth.ClientID != clientID {
+		return "", "", 0, OAuth2ErrorInvalidGrant("invalid device code")
+	}
+
+	now := time.Now().Unix()
+	if now > deviceAuth.ExpiresAt {
+		provider.DeviceAuthorizationService.DeleteDeviceAuthorization(deviceCode)
+		return "", "", 0, OAuth2ErrorExpiredToken("the device code has expired")
+	}
+
+	switch deviceAuth.Status {
+	case DeviceAuthorizationApproved:
+		var consumed bool
+		if consumed, err = provider.DeviceAuthorizationService.ConsumeDeviceAuthorization(deviceCode); err != nil {
+			return "", "", 0, InternalServerError("Failed to consume the device code", err)
+		}
+		if !consumed {
+			return "", "", 0, OAuth2ErrorInvalidGrant("invalid device code")
+		}
+	case DeviceAuthorizationDenied:
+		provider.DeviceAuthorizationService.DeleteDeviceAuthorization(deviceCode)
+		return "", "", 0, OAuth2AccessDenied("the user denied the authorization")
+	default:
+		slowDown := deviceAuth.LastPolledAt != 0 && now-deviceAuth.LastPolledAt < int64(deviceAuth.Interval)
+		if slowDown {
+			deviceAuth.Interval += 5
+		}
+		deviceAuth.LastPolledAt = now
+		if err = provider.DeviceAuthorizationService.SaveDeviceAuthorization(deviceAuth); err != nil {
+			return "", "", 0, InternalServerError("Failed to save the device authorization", err)
+		}
+		if slowDown {
+			return "", "", 0, OAuth2ErrorSlowDown("polling too often")
+		}
+		return "", "", 0, OAuth2ErrorAuthorizationPending("the user has not approved the authorization yet")
+	}
+
+	userData, err := parseUserData(deviceAuth.UserData)
+	if err != nil {
+		return "", "", 0, InternalServerError("Failed to read user data", err)
+	}
+
+	oauth2Token, err := provider.generateOAuthToken(clientID, deviceAuth.Scope, userData, "")
+	if err != nil {
+		return "", "", 0, InternalServerError("Failed to generate access token and refresh token", err)
+	}
+	return oauth2Token.RefreshToken, oauth2Token.AccessToken, oauth2Token.ValidFor, nil
+}
+
+// authenticateDeviceClient authenticates the client of the device authorization grant. Confidential clients (clients
+// with a secret) are authenticated with the client secret, public clients are only looked up by the client ID.
+// The client must be allowed to use the DeviceCodeGrantType.
+func (provider *AuthProvider) authenticateDeviceClient(clientID, clientSecret string) error {
+	client, err := provider.ClientService.GetClient(clientID)
+	if err != nil || client == nil {
+		return OAuth2ErrorInvalidClient("invalid client")
+	}
+	if !client.AllowsGrantType(DeviceCodeGrantType) {
+		return OAuth2ErrorUnauthorizedClient("the client may not use the device authorization grant")
+	}
+	if !client.IsConfidential() {
+		return nil
+	}
+	client, err = provider.ClientService.VerifyClientCredentials(clientID, clientSecret)
+	if err != nil {
+		return InternalServerError(err)
+	}
+	if client == nil {
+		return OAuth2ErrorInvalidClient("invalid client credentials")
+	}
+	return nil
+}
+
+// GenerateUserCode generates a random user code in the form "XXXX-XXXX", with characters that are easy to type.
+// The random characters are drawn from GenerateRandomCode.
+func GenerateUserCode() (string, error) {
+	code := make([]byte, 0, userCodeLength)
+	for len(code) < userCodeLength {
+		random, err := GenerateRandomCode(userCodeLength)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range random {
+			// take only the characters that map to the charset without bias
+			index := strings.IndexRune(base64Charset, c)
+			if index < 0 || index >= len(base64Charset)/len(userCodeCharset)*len(userCodeCharset) {
+				continue
+			}
+			code = append(code, userCodeCharset[index%len(userCodeCharset)])
+			if len(code) == userCodeLength {
+				break
+			}
+		}
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizeUserCode normalizes the user code entered by the user - removes the separators and converts it to
+// upper case.
+func NormalizeUserCode(userCode string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+	if len(normalized) != userCodeLength {
+		return normalized
+	}
+	return normalized[:4] + "-" + normalized[4:]
+}
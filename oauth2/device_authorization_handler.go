@@ -0,0 +1,59 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DeviceAuthorizationPath is the conventional path of the device authorization endpoint.
+const DeviceAuthorizationPath = "/oauth2/device_authorization"
+
+// NewDeviceAuthorizationHandler creates an http.Handler for the device authorization endpoint, as specified
+// by RFC 8628 section 3.1. The client is identified by the "client_id" form parameter (or the HTTP Basic
+// credentials); confidential clients must send the client secret as well (see AuthProvider.DeviceAuthorize).
+// The response holds the device code, the user code and the DeviceVerificationURI of the provider.
+func NewDeviceAuthorizationHandler(provider *AuthProvider) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("invalid form data"))
+			return
+		}
+
+		clientID, clientSecret, ok := clientCredentials(req)
+		if !ok {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidClient("the client_id parameter is required"))
+			return
+		}
+
+		deviceAuth, err := provider.DeviceAuthorize(clientID, clientSecret, req.PostForm.Get("scope"))
+		if err != nil {
+			writeOAuth2Error(rw, err)
+			return
+		}
+
+		response := map[string]interface{}{
+			"device_code":      deviceAuth.DeviceCode,
+			"user_code":        deviceAuth.UserCode,
+			"verification_uri": provider.DeviceVerificationURI,
+			"expires_in":       deviceAuth.ExpiresAt - time.Now().Unix(),
+			"interval":         deviceAuth.Interval,
+		}
+		if verificationURL, err := url.Parse(provider.DeviceVerificationURI); err == nil && provider.DeviceVerificationURI != "" {
+			query := verificationURL.Query()
+			query.Set("user_code", deviceAuth.UserCode)
+			verificationURL.RawQuery = query.Encode()
+			response["verification_uri_complete"] = verificationURL.String()
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(rw).Encode(response)
+	})
+}
@@ -0,0 +1,53 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDeviceAuthorizationHandler(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+	provider.DeviceVerificationURI = "https://auth.example.com/device"
+	handler := NewDeviceAuthorizationHandler(provider)
+
+	form := url.Values{"client_id": {"001"}, "client_secret": {"xyz"}, "scope": {"api:read"}}
+	req := httptest.NewRequest(http.MethodPost, DeviceAuthorizationPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatal("Expected status 200, got", rw.Code)
+	}
+
+	response := map[string]interface{}{}
+	if err := json.NewDecoder(rw.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	userCode, _ := response["user_code"].(string)
+	if response["device_code"] == "" || userCode == "" || response["verification_uri"] != "https://auth.example.com/device" ||
+		response["verification_uri_complete"] != "https://auth.example.com/device?user_code="+userCode || response["interval"] != float64(5) {
+		t.Fatal("Unexpected device authorization response", response)
+	}
+
+	// wrong client secret
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, revocationRequest(url.Values{}, "001", "wrong"))
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatal("Expected status 401, got", rw.Code)
+	}
+
+	// a confidential client without the client secret
+	form = url.Values{"client_id": {"001"}}
+	req = httptest.NewRequest(http.MethodPost, DeviceAuthorizationPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatal("Expected status 401 without the client secret, got", rw.Code)
+	}
+}
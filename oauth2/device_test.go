@@ -0,0 +1,157 @@
+package oauth2
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/keitaroinc/goa"
+)
+
+func expectOAuth2Error(t *testing.T, err error, code string) {
+	t.Helper()
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != code {
+		t.Fatalf("Expected %s error, got %v", code, err)
+	}
+}
+
+func TestGenerateUserCode(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		userCode, err := GenerateUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`).MatchString(userCode) {
+			t.Fatal("Unexpected user code format", userCode)
+		}
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for _, userCode := range []string{"wdjb-mjht", "WDJBMJHT", " wdjb mjht"} {
+		if normalized := NormalizeUserCode(userCode); normalized != "WDJB-MJHT" {
+			t.Fatal("Unexpected normalized user code", normalized)
+		}
+	}
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+	provider.ClientService.(*DummyClientService).Clients["public"] = &Client{ClientID: "public"}
+
+	deviceAuth, err := provider.DeviceAuthorize("001", "xyz", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deviceAuth.DeviceCode == "" || deviceAuth.Interval != DefaultDevicePollingInterval {
+		t.Fatal("Unexpected device authorization", deviceAuth)
+	}
+
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "authorization_pending")
+
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "slow_down")
+	if stored, _ := provider.DeviceAuthorizationService.GetDeviceAuthorization(deviceAuth.DeviceCode); stored.Interval != DefaultDevicePollingInterval+5 {
+		t.Fatal("Expected the polling interval to be increased", stored.Interval)
+	}
+
+	_, _, _, err = provider.ExchangeDeviceCode("public", "", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "invalid_grant")
+	_, _, _, err = provider.ExchangeDeviceCode("001", "", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "invalid_client")
+
+	lookedUp, err := provider.LookupUserCode(NormalizeUserCode(deviceAuth.UserCode))
+	if err != nil || lookedUp.ClientID != "001" || lookedUp.Scope != "api:read" {
+		t.Fatal("Expected the pending device authorization for the user code", lookedUp, err)
+	}
+	if err = provider.ApproveDeviceAuthorization(deviceAuth.UserCode, "10001", "{\"userId\":\"10001\"}"); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, accessToken, _, err := provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshToken == "" || accessToken == "" {
+		t.Fatal("Expected the tokens to be issued")
+	}
+
+	// the device code can be used only once
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "invalid_grant")
+	expectOAuth2Error(t, provider.ApproveDeviceAuthorization(deviceAuth.UserCode, "10001", "{}"), "invalid_grant")
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+
+	deviceAuth, err := provider.DeviceAuthorize("001", "xyz", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.DenyDeviceAuthorization(deviceAuth.UserCode); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "access_denied")
+}
+
+func TestDeviceAuthorizationExpired(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+
+	deviceAuth, err := provider.DeviceAuthorize("001", "xyz", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceAuth.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	provider.DeviceAuthorizationService.SaveDeviceAuthorization(deviceAuth)
+
+	expectOAuth2Error(t, provider.ApproveDeviceAuthorization(deviceAuth.UserCode, "10001", "{}"), "expired_token")
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "expired_token")
+
+	if _, err = provider.DeviceAuthorize("unknown", "", "api:read"); err == nil {
+		t.Fatal("Expected unknown client to be rejected")
+	}
+}
+
+func TestDeviceAuthorizationPublicClient(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+	provider.ClientService.(*DummyClientService).Clients["public"] = &Client{ClientID: "public"}
+
+	if _, err := provider.DeviceAuthorize("001", "", "api:read"); err == nil {
+		t.Fatal("Expected the confidential client to authenticate")
+	}
+	deviceAuth, err := provider.DeviceAuthorize("public", "", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.ApproveDeviceAuthorization(deviceAuth.UserCode, "10001", "{\"userId\":\"10001\"}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the concurrent token requests gets the tokens
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, _, _, err := provider.ExchangeDeviceCode("public", "", deviceAuth.DeviceCode)
+			results <- err
+		}()
+	}
+	issued := 0
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err == nil {
+			issued++
+		} else {
+			expectOAuth2Error(t, err, "invalid_grant")
+		}
+	}
+	if issued != 1 {
+		t.Fatal("Expected the tokens to be issued exactly once, got", issued)
+	}
+}
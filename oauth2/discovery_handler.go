@@ -0,0 +1,82 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Microkubes/microservice-security/jwt"
+)
+
+// OpenIDConfigurationPath is the well-known path of the OpenID Provider metadata.
+const OpenIDConfigurationPath = "/.well-known/openid-configuration"
+
+// DiscoveryEndpoints holds the URLs of the endpoints of the authorization server, published in the
+// OpenID Provider metadata. Empty endpoints are not published.
+type DiscoveryEndpoints struct {
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserInfoEndpoint      string
+	JWKSURI               string
+	RevocationEndpoint    string
+	IntrospectionEndpoint string
+
+	DeviceAuthorizationEndpoint string
+	RegistrationEndpoint        string
+}
+
+// OpenIDConfiguration generates the OpenID Provider metadata (OpenID Connect Discovery 1.0 section 3).
+// The issuer is the ProviderName, which must be the URL of the authorization server. The ID token signing
+// algorithm is the SigningMethod of the provider, and it is checked that the KeyStore has a signing key.
+func (provider *AuthProvider) OpenIDConfiguration(endpoints *DiscoveryEndpoints) (map[string]interface{}, error) {
+	if _, ok := jwt.AvailableSigningMethods[provider.SigningMethod]; !ok {
+		return nil, fmt.Errorf("unsupported signing method %s", provider.SigningMethod)
+	}
+	if _, err := provider.KeyStore.GetPrivateKey(); err != nil {
+		return nil, err
+	}
+
+	configuration := map[string]interface{}{
+		"issuer":                                provider.ProviderName,
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{provider.SigningMethod},
+		"scopes_supported":                      []string{OpenIDScope, "profile", "email"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrantType, TokenExchangeGrantType},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{CodeChallengeMethodS256, CodeChallengeMethodPlain},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"preferred_username", "name", "email", "roles", "organizations", "namespaces",
+		},
+	}
+	setNonEmpty(configuration, "authorization_endpoint", endpoints.AuthorizationEndpoint)
+	setNonEmpty(configuration, "token_endpoint", endpoints.TokenEndpoint)
+	setNonEmpty(configuration, "userinfo_endpoint", endpoints.UserInfoEndpoint)
+	setNonEmpty(configuration, "jwks_uri", endpoints.JWKSURI)
+	setNonEmpty(configuration, "revocation_endpoint", endpoints.RevocationEndpoint)
+	setNonEmpty(configuration, "introspection_endpoint", endpoints.IntrospectionEndpoint)
+	setNonEmpty(configuration, "device_authorization_endpoint", endpoints.DeviceAuthorizationEndpoint)
+	setNonEmpty(configuration, "registration_endpoint", endpoints.RegistrationEndpoint)
+	return configuration, nil
+}
+
+// NewDiscoveryHandler creates an http.Handler that serves the OpenID Provider metadata generated with
+// AuthProvider.OpenIDConfiguration. The handler is usually mounted under OpenIDConfigurationPath.
+func NewDiscoveryHandler(provider *AuthProvider, endpoints *DiscoveryEndpoints) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		configuration, err := provider.OpenIDConfiguration(endpoints)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed to generate the configuration: %s", err), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(rw).Encode(configuration)
+	})
+}
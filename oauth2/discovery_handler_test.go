@@ -0,0 +1,57 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscoveryHandler(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.ProviderName = "https://auth.example.com"
+	handler := NewDiscoveryHandler(provider, &DiscoveryEndpoints{
+		AuthorizationEndpoint: "https://auth.example.com/oauth2/authorize",
+		TokenEndpoint:         "https://auth.example.com/oauth2/token",
+		UserInfoEndpoint:      "https://auth.example.com" + UserInfoPath,
+		JWKSURI:               "https://auth.example.com/.well-known/jwks.json",
+	})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, OpenIDConfigurationPath, nil))
+	if rw.Code != http.StatusOK {
+		t.Fatal("Expected status 200, got", rw.Code)
+	}
+	configuration := map[string]interface{}{}
+	if err := json.NewDecoder(rw.Body).Decode(&configuration); err != nil {
+		t.Fatal(err)
+	}
+	if configuration["issuer"] != "https://auth.example.com" ||
+		configuration["userinfo_endpoint"] != "https://auth.example.com/oauth2/userinfo" ||
+		configuration["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Fatal("Unexpected configuration", configuration)
+	}
+	if algs, _ := configuration["id_token_signing_alg_values_supported"].([]interface{}); len(algs) != 1 || algs[0] != "RS512" {
+		t.Fatal("Expected the signing method of the provider", configuration)
+	}
+	grantTypes, _ := configuration["grant_types_supported"].([]interface{})
+	for _, grantType := range []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrantType, TokenExchangeGrantType} {
+		found := false
+		for _, supported := range grantTypes {
+			found = found || supported == grantType
+		}
+		if !found {
+			t.Fatalf("Expected grant type %s to be supported, got %v", grantType, grantTypes)
+		}
+	}
+	if _, ok := configuration["revocation_endpoint"]; ok {
+		t.Fatal("Expected empty endpoints not to be published")
+	}
+
+	provider.KeyStore = &DummyKeyStore{}
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, OpenIDConfigurationPath, nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatal("Expected status 500 without a signing key, got", rw.Code)
+	}
+}
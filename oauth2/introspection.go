@@ -0,0 +1,247 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/chain"
+	jormungandrJwt "github.com/Microkubes/microservice-security/jwt"
+	"github.com/keitaroinc/goa"
+	goaJwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// DefaultIntrospectionCacheTTL is the default period for which an introspection result is cached.
+const DefaultIntrospectionCacheTTL = 30 * time.Second
+
+// DefaultIntrospectionCacheSize is the default maximal number of cached introspection results.
+const DefaultIntrospectionCacheSize = 10000
+
+// IntrospectionClient calls a token introspection endpoint (RFC 7662) and caches the results.
+// Active tokens are cached for CacheTTL, but never after the token expires. Inactive tokens are not cached.
+type IntrospectionClient struct {
+	// Endpoint is the URL of the introspection endpoint.
+	Endpoint string
+
+	// ClientID is the client ID used to authenticate to the introspection endpoint.
+	ClientID string
+
+	// ClientSecret is the client secret used to authenticate to the introspection endpoint.
+	ClientSecret string
+
+	// CacheTTL is the period for which an introspection result is cached. Zero disables the cache.
+	CacheTTL time.Duration
+
+	// CacheSize is the maximal number of cached introspection results. When the cache is full, the result that
+	// expires first is evicted. If zero, DefaultIntrospectionCacheSize is used.
+	CacheSize int
+
+	// Client is the HTTP client used to call the introspection endpoint.
+	Client *http.Client
+
+	mutex sync.Mutex
+	cache map[string]*cachedIntrospection
+}
+
+type cachedIntrospection struct {
+	response  map[string]interface{}
+	expiresAt time.Time
+}
+
+// NewIntrospectionClient creates an IntrospectionClient for the introspection endpoint, authenticated with the
+// client credentials. If cacheTTL is 0, DefaultIntrospectionCacheTTL is used.
+func NewIntrospectionClient(endpoint, clientID, clientSecret string, cacheTTL time.Duration) *IntrospectionClient {
+	if cacheTTL == 0 {
+		cacheTTL = DefaultIntrospectionCacheTTL
+	}
+	return &IntrospectionClient{
+		Endpoint:     endpoint,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		CacheTTL:     cacheTTL,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Introspect returns the introspection response for the token, from the cache or from the introspection endpoint.
+func (c *IntrospectionClient) Introspect(token string) (map[string]interface{}, error) {
+	hash := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(hash[:])
+
+	if response, ok := c.cached(cacheKey); ok {
+		return response, nil
+	}
+
+	response, err := c.fetch(token)
+	if err != nil {
+		return nil, err
+	}
+	c.store(cacheKey, response)
+	return response, nil
+}
+
+func (c *IntrospectionClient) fetch(token string) (map[string]interface{}, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token introspection at %s failed: %s", c.Endpoint, resp.Status)
+	}
+
+	response := map[string]interface{}{}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse the introspection response: %s", err)
+	}
+	return response, nil
+}
+
+func (c *IntrospectionClient) cached(cacheKey string) (map[string]interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.cache[cacheKey]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.cache, cacheKey)
+		return nil, false
+	}
+	return entry.response, true
+}
+
+func (c *IntrospectionClient) store(cacheKey string, response map[string]interface{}) {
+	if active, _ := response["active"].(bool); c.CacheTTL <= 0 || !active {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(c.CacheTTL)
+	if exp, ok := response["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cache == nil {
+		c.cache = map[string]*cachedIntrospection{}
+	}
+	for key, entry := range c.cache {
+		if now.After(entry.expiresAt) {
+			delete(c.cache, key)
+		}
+	}
+	cacheSize := c.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = DefaultIntrospectionCacheSize
+	}
+	if _, ok := c.cache[cacheKey]; !ok && len(c.cache) >= cacheSize {
+		var evictKey string
+		var evictAt time.Time
+		for key, entry := range c.cache {
+			if evictKey == "" || entry.expiresAt.Before(evictAt) {
+				evictKey, evictAt = key, entry.expiresAt
+			}
+		}
+		delete(c.cache, evictKey)
+	}
+	c.cache[cacheKey] = &cachedIntrospection{
+		response:  response,
+		expiresAt: expiresAt,
+	}
+}
+
+// NewIntrospectionSecurity creates a OAuth2 SecurityChainMiddleware that validates the bearer tokens
+// with the introspection endpoint. See NewIntrospectionSecurityMiddleware.
+func NewIntrospectionSecurity(client *IntrospectionClient, scheme *goa.OAuth2Security, options *jormungandrJwt.SecurityOptions) chain.SecurityChainMiddleware {
+	return chain.ToSecurityChainMiddleware(OAuth2SecurityType, NewIntrospectionSecurityMiddleware(client, scheme, options))
+}
+
+// NewIntrospectionSecurityMiddleware creates a middleware that validates opaque (or any) bearer tokens by calling
+// the introspection endpoint, as an alternative to NewOAuth2SecurityMiddleware for services that cannot validate
+// the tokens locally.
+// The steps taken by the middleware are:
+// 1. Introspect the "Bearer" token present in the "Authorization" header and check that it is an active access
+// token - responses with a "token_type" other than "Bearer" (like refresh tokens) are rejected
+// 2. Validate the introspection response with the ClaimsValidation from the options
+// 3. If scopes are defined for the scheme or for the action validate them against the "scope" of the token
+// 4. Map the introspection response to auth.Auth with the ClaimMapper from the options or DefaultClaimMapper
+func NewIntrospectionSecurityMiddleware(client *IntrospectionClient, scheme *goa.OAuth2Security, options *jormungandrJwt.SecurityOptions) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			authorization := req.Header.Get("Authorization")
+			if authorization == "" {
+				return goa.ErrUnauthorized("missing auth header")
+			}
+			if len(authorization) < 8 || !strings.EqualFold(authorization[:7], "Bearer ") {
+				return goa.ErrUnauthorized("invalid auth header")
+			}
+			token := strings.TrimSpace(authorization[7:])
+
+			response, err := client.Introspect(token)
+			if err != nil {
+				return goa.ErrInternal(err)
+			}
+			if active, _ := response["active"].(bool); !active {
+				return goaJwt.ErrJWTError("token is not active")
+			}
+			if tokenType, ok := response["token_type"]; ok && !isAccessTokenType(tokenType) {
+				return goaJwt.ErrJWTError("token is not an access token")
+			}
+
+			if err = options.ValidateClaims(response); err != nil {
+				return err
+			}
+
+			scopes, _ := response["scope"].(string)
+			scopesInToken := map[string]bool{}
+			for _, scope := range strings.Fields(scopes) {
+				scopesInToken[scope] = true
+			}
+			for _, scope := range schemeScopes(ctx, scheme) {
+				if !scopesInToken[scope] {
+					return goaJwt.ErrJWTError("authorization failed: required scopes not present in the token", "required", scope, "scope", scopes)
+				}
+			}
+
+			authObj, err := options.MapClaims(response, DefaultClaimMapper())
+			if err != nil {
+				return goaJwt.ErrJWTError(err)
+			}
+
+			return h(auth.SetAuth(ctx, authObj), rw, req)
+		}
+	}
+}
+
+// isAccessTokenType checks if the "token_type" of an introspection response is an access token type.
+func isAccessTokenType(tokenType interface{}) bool {
+	value, _ := tokenType.(string)
+	return strings.EqualFold(value, "Bearer") || value == "access_token" || value == AccessTokenType
+}
@@ -0,0 +1,48 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// IntrospectionPath is the conventional path of the token introspection endpoint.
+const IntrospectionPath = "/oauth2/introspect"
+
+// NewIntrospectionHandler creates an http.Handler for the OAuth2 token introspection endpoint, as specified
+// by RFC 7662. The caller (usually a resource server registered as a client) is authenticated with
+// AuthProvider.Authenticate, the same way as for the revocation endpoint. The "token" form parameter is
+// introspected with AuthProvider.Introspect, using the optional "token_type_hint".
+func NewIntrospectionHandler(provider *AuthProvider) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("invalid form data"))
+			return
+		}
+
+		if _, err := authenticateClient(provider, req); err != nil {
+			writeOAuth2Error(rw, err)
+			return
+		}
+
+		token := req.PostForm.Get("token")
+		if token == "" {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("the token parameter is required"))
+			return
+		}
+
+		response, err := provider.Introspect(token, req.PostForm.Get("token_type_hint"))
+		if err != nil {
+			writeOAuth2Error(rw, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(rw).Encode(response)
+	})
+}
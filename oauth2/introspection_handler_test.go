@@ -0,0 +1,78 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/revocation"
+)
+
+func introspect(t *testing.T, handler http.Handler, token string) map[string]interface{} {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, revocationRequest(url.Values{"token": {token}}, "001", "xyz"))
+	if rw.Code != http.StatusOK {
+		t.Fatal("Expected status 200, got", rw.Code)
+	}
+	response := map[string]interface{}{}
+	if err := json.NewDecoder(rw.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+	handler := NewIntrospectionHandler(provider)
+
+	oauth2Token, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := introspect(t, handler, oauth2Token.AccessToken)
+	if response["active"] != true {
+		t.Fatal("Expected the access token to be active")
+	}
+	if response["scope"] != "api:read" || response["client_id"] != "001" || response["sub"] != "10001" {
+		t.Fatal("Unexpected introspection response", response)
+	}
+	if response["username"] != "user" || response["exp"] == nil {
+		t.Fatal("Expected the user claims in the introspection response", response)
+	}
+
+	response = introspect(t, handler, oauth2Token.RefreshToken)
+	if response["active"] != true || response["token_type"] != "refresh_token" || response["client_id"] != "001" {
+		t.Fatal("Unexpected refresh token introspection response", response)
+	}
+
+	if response = introspect(t, handler, "unknown-token"); response["active"] != false || len(response) != 1 {
+		t.Fatal("Expected unknown token to be inactive", response)
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, revocationRequest(url.Values{"token": {"unknown-token"}, "token_type_hint": {"refresh_token"}}, "001", "xyz"))
+	if rw.Code != http.StatusOK || strings.TrimSpace(rw.Body.String()) != `{"active":false}` {
+		t.Fatal("Expected unknown token with refresh token hint to be inactive", rw.Code, rw.Body.String())
+	}
+
+	if err = provider.Revoke(oauth2Token.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if response = introspect(t, handler, oauth2Token.AccessToken); response["active"] != false {
+		t.Fatal("Expected revoked token to be inactive", response)
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, revocationRequest(url.Values{"token": {oauth2Token.AccessToken}}, "001", "wrong"))
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatal("Expected status 401 for invalid client credentials, got", rw.Code)
+	}
+}
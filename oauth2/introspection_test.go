@@ -0,0 +1,130 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/revocation"
+)
+
+func TestIntrospectionSecurityMiddleware(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+
+	calls := 0
+	introspectionHandler := NewIntrospectionHandler(provider)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		introspectionHandler.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+
+	accessToken, err := provider.generateAccessToken(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+		"roles":    "user,admin",
+	}, "001", "api:read api:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewIntrospectionClient(server.URL, "001", "xyz", time.Minute)
+	middleware := NewIntrospectionSecurityMiddleware(client, &scheme, nil)
+
+	request := func(token string) (context.Context, error) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		modifiedCtx := context.Background()
+		err := middleware(func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+			modifiedCtx = c
+			return nil
+		})(context.Background(), nil, req)
+		return modifiedCtx, err
+	}
+
+	ctx, err := request(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authObj := auth.GetAuth(ctx)
+	if authObj == nil || authObj.UserID != "10001" || len(authObj.Roles) != 2 {
+		t.Fatal("Expected auth to be set from the introspection response", authObj)
+	}
+
+	// the result is cached
+	if _, err = request(accessToken); err != nil {
+		t.Fatal(err)
+	}
+	// the authentication scheme is case-insensitive
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", accessToken))
+	err = middleware(func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal("Expected the lowercase bearer scheme to be accepted", err)
+	}
+	if calls != 1 {
+		t.Fatal("Expected the introspection result to be cached, got calls:", calls)
+	}
+
+	if _, err = request("unknown-token"); err == nil {
+		t.Fatal("Expected inactive token to be rejected")
+	}
+	if _, err = request("unknown-token"); err == nil || calls != 3 {
+		t.Fatal("Expected the inactive result not to be cached, got calls:", calls)
+	}
+
+	oauth2Token, err := provider.generateOAuthToken("001", "api:read api:write", map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = request(oauth2Token.RefreshToken)
+	if err == nil || !strings.Contains(err.Error(), "not an access token") {
+		t.Fatal("Expected the refresh token to be rejected as bearer token, got", err)
+	}
+
+	readOnlyToken, err := provider.generateAccessToken(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+	}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = request(readOnlyToken); err == nil {
+		t.Fatal("Expected token without the required scopes to be rejected")
+	}
+
+	client.ClientSecret = "wrong"
+	if _, err = request("other-token"); err == nil {
+		t.Fatal("Expected an error when the introspection fails")
+	}
+}
+
+func TestIntrospectionCacheSize(t *testing.T) {
+	client := &IntrospectionClient{CacheTTL: time.Minute, CacheSize: 2}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	client.store("token-1", map[string]interface{}{"active": true, "exp": float64(time.Now().Add(10 * time.Second).Unix())})
+	client.store("token-2", map[string]interface{}{"active": true, "exp": exp})
+	client.store("token-3", map[string]interface{}{"active": true, "exp": exp})
+	client.store("token-4", map[string]interface{}{"active": false})
+
+	if len(client.cache) != 2 {
+		t.Fatal("Expected the cache to be bounded, got", len(client.cache))
+	}
+	if _, ok := client.cached("token-1"); ok {
+		t.Fatal("Expected the result that expires first to be evicted")
+	}
+	if _, ok := client.cached("token-4"); ok {
+		t.Fatal("Expected the inactive result not to be cached")
+	}
+}
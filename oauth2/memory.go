@@ -0,0 +1,353 @@
+package oauth2
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryTokenService is an in-memory TokenService. It supports revocation and rotation of the refresh tokens
+// (implements RevocableTokenService and RotatingTokenService). It is a reference implementation, suitable for
+// testing and for a single instance of the authorization server.
+type MemoryTokenService struct {
+	mutex  sync.RWMutex
+	tokens map[string]*AuthToken
+}
+
+// NewMemoryTokenService creates an empty MemoryTokenService.
+func NewMemoryTokenService() *MemoryTokenService {
+	return &MemoryTokenService{
+		tokens: map[string]*AuthToken{},
+	}
+}
+
+// SaveToken saves the token, keyed by its refresh token.
+func (s *MemoryTokenService) SaveToken(token AuthToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[token.RefreshToken] = &token
+	return nil
+}
+
+// GetToken retrieves a copy of the token for the refresh token, or nil if there is no such token.
+// Used tokens are returned as well.
+func (s *MemoryTokenService) GetToken(refreshToken string) (*AuthToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	token, ok := s.tokens[refreshToken]
+	if !ok {
+		return nil, nil
+	}
+	copied := *token
+	return &copied, nil
+}
+
+// GetTokenForClient looks up the latest unused token issued to the client for the user.
+func (s *MemoryTokenService) GetTokenForClient(userID, clientID string) (*AuthToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var found *AuthToken
+	for _, token := range s.tokens {
+		if token.UserID != userID || token.ClientID != clientID || token.Used {
+			continue
+		}
+		if found == nil || token.IssuedAt > found.IssuedAt {
+			found = token
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	copied := *found
+	return &copied, nil
+}
+
+// DeleteToken deletes the token for the refresh token.
+func (s *MemoryTokenService) DeleteToken(refreshToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.tokens, refreshToken)
+	return nil
+}
+
+// MarkTokenUsed marks the refresh token as used. Returns false if the token has already been used
+// or does not exist.
+func (s *MemoryTokenService) MarkTokenUsed(refreshToken string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[refreshToken]
+	if !ok || token.Used {
+		return false, nil
+	}
+	token.Used = true
+	return true, nil
+}
+
+// DeleteTokenFamily deletes all tokens of the token family.
+func (s *MemoryTokenService) DeleteTokenFamily(familyID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for refreshToken, token := range s.tokens {
+		if token.FamilyID == familyID {
+			delete(s.tokens, refreshToken)
+		}
+	}
+	return nil
+}
+
+// MemoryDeviceAuthorizationService is an in-memory DeviceAuthorizationService, suitable for testing and for
+// a single instance of the authorization server.
+type MemoryDeviceAuthorizationService struct {
+	mutex          sync.RWMutex
+	authorizations map[string]*DeviceAuthorization
+}
+
+// NewMemoryDeviceAuthorizationService creates an empty MemoryDeviceAuthorizationService.
+func NewMemoryDeviceAuthorizationService() *MemoryDeviceAuthorizationService {
+	return &MemoryDeviceAuthorizationService{
+		authorizations: map[string]*DeviceAuthorization{},
+	}
+}
+
+// SaveDeviceAuthorization saves a copy of the device authorization, keyed by its device code.
+func (s *MemoryDeviceAuthorizationService) SaveDeviceAuthorization(deviceAuth *DeviceAuthorization) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	copied := *deviceAuth
+	s.authorizations[deviceAuth.DeviceCode] = &copied
+	return nil
+}
+
+// GetDeviceAuthorization retrieves a copy of the device authorization for the device code, or nil if there is
+// no such authorization.
+func (s *MemoryDeviceAuthorizationService) GetDeviceAuthorization(deviceCode string) (*DeviceAuthorization, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	deviceAuth, ok := s.authorizations[deviceCode]
+	if !ok {
+		return nil, nil
+	}
+	copied := *deviceAuth
+	return &copied, nil
+}
+
+// GetDeviceAuthorizationByUserCode retrieves a copy of the device authorization for the user code, or nil if
+// there is no such authorization.
+func (s *MemoryDeviceAuthorizationService) GetDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorization, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, deviceAuth := range s.authorizations {
+		if deviceAuth.UserCode == userCode {
+			copied := *deviceAuth
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteDeviceAuthorization deletes the device authorization for the device code.
+func (s *MemoryDeviceAuthorizationService) DeleteDeviceAuthorization(deviceCode string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.authorizations, deviceCode)
+	return nil
+}
+
+// ConsumeDeviceAuthorization deletes the device authorization for the device code. Returns false if there is
+// no such authorization.
+func (s *MemoryDeviceAuthorizationService) ConsumeDeviceAuthorization(deviceCode string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.authorizations[deviceCode]; !ok {
+		return false, nil
+	}
+	delete(s.authorizations, deviceCode)
+	return true, nil
+}
+
+// MemoryClientService is an in-memory ClientService. It consumes the authorization codes atomically and stores
+// the clients (implements SingleUseClientService and ClientRegistrationService), so it supports the dynamic
+// client registration. It is a reference implementation, suitable for testing and for a single instance of the
+// authorization server.
+type MemoryClientService struct {
+	mutex   sync.RWMutex
+	clients map[string]*Client
+	auths   map[string]*ClientAuth
+}
+
+// NewMemoryClientService creates a MemoryClientService with the given clients.
+func NewMemoryClientService(clients ...*Client) *MemoryClientService {
+	s := &MemoryClientService{
+		clients: map[string]*Client{},
+		auths:   map[string]*ClientAuth{},
+	}
+	for _, client := range clients {
+		copied := *client
+		s.clients[client.ClientID] = &copied
+	}
+	return s
+}
+
+func clientAuthKey(clientID, code string) string {
+	return clientID + "\x00" + code
+}
+
+// GetClient retrieves a copy of the client, or nil if there is no such client.
+func (s *MemoryClientService) GetClient(clientID string) (*Client, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *client
+	return &copied, nil
+}
+
+// VerifyClientCredentials retrieves a copy of the client if the client secret is valid (see Client.VerifySecret),
+// or nil otherwise.
+func (s *MemoryClientService) VerifyClientCredentials(clientID, clientSecret string) (*Client, error) {
+	client, err := s.GetClient(clientID)
+	if err != nil || client == nil || !client.VerifySecret(clientSecret) {
+		return nil, err
+	}
+	return client, nil
+}
+
+// SaveClient saves a copy of the client, keyed by its client ID.
+func (s *MemoryClientService) SaveClient(client *Client) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	copied := *client
+	s.clients[client.ClientID] = &copied
+	return nil
+}
+
+// DeleteClient deletes the client and all of its authorizations.
+func (s *MemoryClientService) DeleteClient(clientID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.clients, clientID)
+	for key, clientAuth := range s.auths {
+		if clientAuth.ClientID == clientID {
+			delete(s.auths, key)
+		}
+	}
+	return nil
+}
+
+// SaveClientAuth saves a copy of the ClientAuth, keyed by its client ID and code.
+func (s *MemoryClientService) SaveClientAuth(clientAuth *ClientAuth) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	copied := *clientAuth
+	s.auths[clientAuthKey(clientAuth.ClientID, clientAuth.Code)] = &copied
+	return nil
+}
+
+// GetClientAuth retrieves a copy of the ClientAuth, or nil if there is no such ClientAuth.
+// Consumed ClientAuths are returned as well.
+func (s *MemoryClientService) GetClientAuth(clientID, code string) (*ClientAuth, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	clientAuth, ok := s.auths[clientAuthKey(clientID, code)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *clientAuth
+	return &copied, nil
+}
+
+// GetClientAuthForUser retrieves a copy of the latest unconsumed ClientAuth of the client for the user.
+func (s *MemoryClientService) GetClientAuthForUser(userID, clientID string) (*ClientAuth, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	found := s.clientAuthForUser(userID, clientID)
+	if found == nil {
+		return nil, nil
+	}
+	copied := *found
+	return &copied, nil
+}
+
+func (s *MemoryClientService) clientAuthForUser(userID, clientID string) *ClientAuth {
+	var found *ClientAuth
+	for _, clientAuth := range s.auths {
+		if clientAuth.UserID != userID || clientAuth.ClientID != clientID || clientAuth.Consumed {
+			continue
+		}
+		if found == nil || clientAuth.GeneratedAt > found.GeneratedAt {
+			found = clientAuth
+		}
+	}
+	return found
+}
+
+// ConfirmClientAuth confirms the latest unconsumed ClientAuth of the client for the user and returns a copy
+// of it, or nil if there is no such ClientAuth.
+func (s *MemoryClientService) ConfirmClientAuth(userID, clientID string) (*ClientAuth, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	found := s.clientAuthForUser(userID, clientID)
+	if found == nil {
+		return nil, nil
+	}
+	found.Confirmed = true
+	copied := *found
+	return &copied, nil
+}
+
+// UpdateUserData sets the user and the user data of the ClientAuth.
+func (s *MemoryClientService) UpdateUserData(clientID, code, userID, userData string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clientAuth, ok := s.auths[clientAuthKey(clientID, code)]
+	if !ok {
+		return fmt.Errorf("no client auth for client %s", clientID)
+	}
+	clientAuth.UserID = userID
+	clientAuth.UserData = userData
+	return nil
+}
+
+// DeleteClientAuth deletes the ClientAuth.
+func (s *MemoryClientService) DeleteClientAuth(clientID, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.auths, clientAuthKey(clientID, code))
+	return nil
+}
+
+// ConsumeClientAuth marks the ClientAuth as consumed and records the FamilyID. Returns false if the ClientAuth
+// has already been consumed or does not exist.
+func (s *MemoryClientService) ConsumeClientAuth(clientID, code, familyID string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clientAuth, ok := s.auths[clientAuthKey(clientID, code)]
+	if !ok || clientAuth.Consumed {
+		return false, nil
+	}
+	clientAuth.Consumed = true
+	clientAuth.FamilyID = familyID
+	return true, nil
+}
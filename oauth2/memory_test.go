@@ -0,0 +1,118 @@
+package oauth2
+
+import (
+	"testing"
+)
+
+func TestMemoryTokenService(t *testing.T) {
+	service := NewMemoryTokenService()
+
+	tokens := []AuthToken{
+		{RefreshToken: "rt-1", ClientID: "001", UserID: "10001", IssuedAt: 1, FamilyID: "family-1"},
+		{RefreshToken: "rt-2", ClientID: "001", UserID: "10001", IssuedAt: 2, FamilyID: "family-1"},
+		{RefreshToken: "rt-3", ClientID: "001", UserID: "10002", IssuedAt: 3, FamilyID: "family-2"},
+	}
+	for _, token := range tokens {
+		if err := service.SaveToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := service.GetTokenForClient("10001", "001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == nil || token.RefreshToken != "rt-2" {
+		t.Fatal("Expected the latest token for the client and user", token)
+	}
+
+	used, err := service.MarkTokenUsed("rt-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !used {
+		t.Fatal("Expected the token to be marked as used")
+	}
+	if used, _ = service.MarkTokenUsed("rt-2"); used {
+		t.Fatal("Expected the second use to be detected")
+	}
+	if token, _ = service.GetToken("rt-2"); token == nil || !token.Used {
+		t.Fatal("Expected the used token to be returned")
+	}
+	if token, _ = service.GetTokenForClient("10001", "001"); token == nil || token.RefreshToken != "rt-1" {
+		t.Fatal("Expected the used token to be skipped", token)
+	}
+
+	if err = service.DeleteTokenFamily("family-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, refreshToken := range []string{"rt-1", "rt-2"} {
+		if token, _ = service.GetToken(refreshToken); token != nil {
+			t.Fatal("Expected the token family to be deleted")
+		}
+	}
+	if token, _ = service.GetToken("rt-3"); token == nil {
+		t.Fatal("Expected the token of other family to be kept")
+	}
+
+	if err = service.DeleteToken("rt-3"); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ = service.GetToken("rt-3"); token != nil {
+		t.Fatal("Expected the token to be deleted")
+	}
+}
+
+func TestMemoryClientService(t *testing.T) {
+	service := NewMemoryClientService(&Client{ClientID: "001", SecretHash: HashClientSecret("xyz")})
+
+	if client, _ := service.VerifyClientCredentials("001", "xyz"); client == nil {
+		t.Fatal("Expected the client credentials to be verified")
+	}
+	if client, _ := service.VerifyClientCredentials("001", "wrong"); client != nil {
+		t.Fatal("Expected invalid client credentials to be rejected")
+	}
+
+	for i, code := range []string{"code-1", "code-2"} {
+		if err := service.SaveClientAuth(&ClientAuth{ClientID: "001", Code: code, UserID: "10001", GeneratedAt: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientAuth, err := service.ConfirmClientAuth("10001", "001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientAuth == nil || clientAuth.Code != "code-2" || !clientAuth.Confirmed {
+		t.Fatal("Expected the latest client auth to be confirmed", clientAuth)
+	}
+	if err = service.UpdateUserData("001", "code-2", "10001", `{"userId":"10001"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	consumed, err := service.ConsumeClientAuth("001", "code-2", "family-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !consumed {
+		t.Fatal("Expected the client auth to be consumed")
+	}
+	if consumed, _ = service.ConsumeClientAuth("001", "code-2", "family-2"); consumed {
+		t.Fatal("Expected the second use to be detected")
+	}
+	if clientAuth, _ = service.GetClientAuth("001", "code-2"); clientAuth == nil || clientAuth.FamilyID != "family-1" || clientAuth.UserData == "" {
+		t.Fatal("Expected the consumed client auth to be returned", clientAuth)
+	}
+	if clientAuth, _ = service.GetClientAuthForUser("10001", "001"); clientAuth == nil || clientAuth.Code != "code-1" {
+		t.Fatal("Expected the consumed client auth to be skipped", clientAuth)
+	}
+
+	if err = service.DeleteClient("001"); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ := service.GetClient("001"); client != nil {
+		t.Fatal("Expected the client to be deleted")
+	}
+	if clientAuth, _ = service.GetClientAuth("001", "code-1"); clientAuth != nil {
+		t.Fatal("Expected the client auths to be deleted with the client")
+	}
+}
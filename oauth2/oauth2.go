@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"reflect"
 	"sort"
 	"strings"
 
@@ -16,6 +15,7 @@ import (
 	goaJwt "github.com/keitaroinc/goa/middleware/security/jwt"
 
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 )
 
@@ -26,11 +26,17 @@ const OAuth2SecurityType = "OAuth2"
 // that loads the public keys from the keysDir. The key files must end in *.pub.
 // The scheme is obtained from app/security.go.
 func NewOAuth2Security(keysDir string, scheme *goa.OAuth2Security) chain.SecurityChainMiddleware {
+	return NewOAuth2SecurityWithOptions(keysDir, scheme, nil)
+}
+
+// NewOAuth2SecurityWithOptions creates a OAuth2 SecurityChainMiddleware like NewOAuth2Security, with additional
+// SecurityOptions.
+func NewOAuth2SecurityWithOptions(keysDir string, scheme *goa.OAuth2Security, options *jormungandrJwt.SecurityOptions) chain.SecurityChainMiddleware {
 	resolver, err := jormungandrJwt.NewKeyResolver(keysDir)
 	if err != nil {
 		panic(err)
 	}
-	goaMiddleware := NewOAuth2SecurityMiddleware(resolver, scheme)
+	goaMiddleware := NewOAuth2SecurityMiddlewareWithOptions(resolver, scheme, options)
 	return chain.ToSecurityChainMiddleware(OAuth2SecurityType, goaMiddleware)
 }
 
@@ -38,8 +44,27 @@ func NewOAuth2Security(keysDir string, scheme *goa.OAuth2Security) chain.Securit
 // and validates its content.
 // The steps taken by the middleware are:
 // 1. Validate the "Bearer" token present in the "Authorization" header against the key(s)
-// 2. If scopes are defined for the action validate them against the "scopes" JWT claim
+// 2. Validate the "exp", "nbf" and "iat" JWT claims
+// 3. If scopes are defined for the scheme or for the action (goa.ContextRequiredScopes) validate them against
+// the "scopes" JWT claim
+// 4. Map the JWT claims to auth.Auth with DefaultClaimMapper
+// The validated token is available in the context via goaJwt.ContextJWT.
 func NewOAuth2SecurityMiddleware(resolver goaJwt.KeyResolver, scheme *goa.OAuth2Security) goa.Middleware {
+	return NewOAuth2SecurityMiddlewareWithOptions(resolver, scheme, nil)
+}
+
+// DefaultClaimMapper returns the default claim mapping of the OAuth2 security middleware. The claims
+// have the same names as the auth.Auth fields and the "userId" and "username" claims are required.
+func DefaultClaimMapper() *auth.ClaimMapper {
+	return &auth.ClaimMapper{
+		Required: []string{"userId", "username"},
+	}
+}
+
+// NewOAuth2SecurityMiddlewareWithOptions creates a middleware like NewOAuth2SecurityMiddleware, with additional
+// SecurityOptions. If no ClaimMapper is set in the options, DefaultClaimMapper is used. The token claims
+// are validated with the ClaimsValidation from the options.
+func NewOAuth2SecurityMiddlewareWithOptions(resolver goaJwt.KeyResolver, scheme *goa.OAuth2Security, options *jormungandrJwt.SecurityOptions) goa.Middleware {
 	return func(h goa.Handler) goa.Handler {
 		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 			authorization := req.Header["Authorization"]
@@ -52,7 +77,7 @@ func NewOAuth2SecurityMiddleware(resolver goaJwt.KeyResolver, scheme *goa.OAuth2
 			}
 			tokenHeader = tokenHeader[7:]
 
-			rsaKeys, ecdsaKeys, hmacKeys := partitionKeys(resolver.SelectKeys(req))
+			rsaKeys, ecdsaKeys, ed25519Keys, hmacKeys := partitionKeys(resolver.SelectKeys(req))
 
 			var (
 				token     *jwt.Token
@@ -67,6 +92,13 @@ func NewOAuth2SecurityMiddleware(resolver goaJwt.KeyResolver, scheme *goa.OAuth2
 				}
 			}
 
+			if !validated && len(rsaKeys) > 0 {
+				token, err = validateRSAKeys(rsaKeys, "PS", tokenHeader)
+				if err == nil {
+					validated = true
+				}
+			}
+
 			if !validated && len(ecdsaKeys) > 0 {
 				token, err = validateECDSAKeys(ecdsaKeys, "ES", tokenHeader)
 				if err == nil {
@@ -74,6 +106,13 @@ func NewOAuth2SecurityMiddleware(resolver goaJwt.KeyResolver, scheme *goa.OAuth2
 				}
 			}
 
+			if !validated && len(ed25519Keys) > 0 {
+				token, err = validateEd25519Keys(ed25519Keys, "EdDSA", tokenHeader)
+				if err == nil {
+					validated = true
+				}
+			}
+
 			if !validated && len(hmacKeys) > 0 {
 				token, err = validateHMACKeys(hmacKeys, "HS", tokenHeader)
 				if err == nil {
@@ -85,70 +124,58 @@ func NewOAuth2SecurityMiddleware(resolver goaJwt.KeyResolver, scheme *goa.OAuth2
 				return goaJwt.ErrJWTError("JWT validation failed")
 			}
 
+			if err = options.ValidateClaims(token.Claims.(jwt.MapClaims)); err != nil {
+				return err
+			}
+
 			scopesInClaim, scopesInClaimList, err := parseClaimScopes(token)
 			if err != nil {
 				return goaJwt.ErrJWTError(err)
 			}
 
-			requiredScopes := reflect.ValueOf(scheme.Scopes).MapKeys()
-
+			requiredScopes := schemeScopes(ctx, scheme)
 			for _, scope := range requiredScopes {
-				if !scopesInClaim[scope.String()] {
+				if !scopesInClaim[scope] {
 					msg := "authorization failed: required 'scopes' not present in JWT claim for OAuth2"
 					return goaJwt.ErrJWTError(msg, "required", requiredScopes, "scopes", scopesInClaimList)
 				}
 			}
 
-			claims := token.Claims.(jwt.MapClaims)
-
-			if _, ok := claims["username"]; !ok {
-				return jwt.NewValidationError("Username is missing", jwt.ValidationErrorClaimsInvalid)
-			}
-			if _, ok := claims["userId"]; !ok {
-				return jwt.NewValidationError("User ID is missing", jwt.ValidationErrorClaimsInvalid)
-			}
-
-			roles := []string{}
-			organizations := []string{}
-			namespaces := []string{}
-			var username string
-			var userID string
-
-			username = claims["username"].(string)
-			if _, ok := claims["userId"].(string); !ok {
-				return jwt.NewValidationError("Invalid user ID", jwt.ValidationErrorClaimsInvalid)
-			}
-			userID = claims["userId"].(string)
-
-			if rolesStr, ok := claims["roles"]; ok {
-				roles = strings.Split(rolesStr.(string), ",")
-			}
-			if organizationsStr, ok := claims["organizations"]; ok {
-				organizations = strings.Split(organizationsStr.(string), ",")
-			}
-			if namespacesStr, ok := claims["namespaces"]; ok {
-				namespaces = strings.Split(namespacesStr.(string), ",")
+			authObj, err := options.MapClaims(token.Claims.(jwt.MapClaims), DefaultClaimMapper())
+			if err != nil {
+				return jwt.NewValidationError(err.Error(), jwt.ValidationErrorClaimsInvalid)
 			}
 
-			authObj := &auth.Auth{
-				Roles:         roles,
-				Organizations: organizations,
-				Username:      username,
-				UserID:        userID,
-				Namespaces:    namespaces,
-			}
+			ctx = goaJwt.WithJWT(ctx, token)
 
 			return h(auth.SetAuth(ctx, authObj), rw, req)
 		}
 	}
 }
 
+// schemeScopes returns the scopes of the scheme together with the scopes required for the action
+// (goa.ContextRequiredScopes), so the scopes set by the gRPC interceptors are checked as well.
+func schemeScopes(ctx context.Context, scheme *goa.OAuth2Security) []string {
+	scopes := []string{}
+	for scope := range scheme.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	for _, scope := range goa.ContextRequiredScopes(ctx) {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
 // partitionKeys sorts keys by their type.
-func partitionKeys(keys []goaJwt.Key) ([]*rsa.PublicKey, []*ecdsa.PublicKey, [][]byte) {
+func partitionKeys(keys []goaJwt.Key) ([]*rsa.PublicKey, []*ecdsa.PublicKey, []ed25519.PublicKey, [][]byte) {
 	var (
-		rsaKeys   []*rsa.PublicKey
-		ecdsaKeys []*ecdsa.PublicKey
-		hmacKeys  [][]byte
+		rsaKeys     []*rsa.PublicKey
+		ecdsaKeys   []*ecdsa.PublicKey
+		ed25519Keys []ed25519.PublicKey
+		hmacKeys    [][]byte
 	)
 
 	for _, key := range keys {
@@ -157,6 +184,8 @@ func partitionKeys(keys []goaJwt.Key) ([]*rsa.PublicKey, []*ecdsa.PublicKey, [][
 			rsaKeys = append(rsaKeys, k)
 		case *ecdsa.PublicKey:
 			ecdsaKeys = append(ecdsaKeys, k)
+		case ed25519.PublicKey:
+			ed25519Keys = append(ed25519Keys, k)
 		case []byte:
 			hmacKeys = append(hmacKeys, k)
 		case string:
@@ -164,7 +193,7 @@ func partitionKeys(keys []goaJwt.Key) ([]*rsa.PublicKey, []*ecdsa.PublicKey, [][
 		}
 	}
 
-	return rsaKeys, ecdsaKeys, hmacKeys
+	return rsaKeys, ecdsaKeys, ed25519Keys, hmacKeys
 }
 
 // parseClaimScopes parses the "scopes" parameter in the Claims. It supports two formats:
@@ -201,9 +230,13 @@ func parseClaimScopes(token *jwt.Token) (map[string]bool, []string, error) {
 	return scopesInClaim, scopesInClaimList, nil
 }
 
+// signatureParser validates only the signature of the token. The claims are validated by the middleware
+// with the ClaimsValidation from the SecurityOptions.
+var signatureParser = &jwt.Parser{SkipClaimsValidation: true}
+
 func validateRSAKeys(rsaKeys []*rsa.PublicKey, algo, incomingToken string) (token *jwt.Token, err error) {
 	for _, pubkey := range rsaKeys {
-		token, err = jwt.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
+		token, err = signatureParser.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
 			if !strings.HasPrefix(token.Method.Alg(), algo) {
 				return nil, goaJwt.ErrJWTError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
 			}
@@ -218,7 +251,7 @@ func validateRSAKeys(rsaKeys []*rsa.PublicKey, algo, incomingToken string) (toke
 
 func validateECDSAKeys(ecdsaKeys []*ecdsa.PublicKey, algo, incomingToken string) (token *jwt.Token, err error) {
 	for _, pubkey := range ecdsaKeys {
-		token, err = jwt.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
+		token, err = signatureParser.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
 			if !strings.HasPrefix(token.Method.Alg(), algo) {
 				return nil, goaJwt.ErrJWTError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
 			}
@@ -231,9 +264,24 @@ func validateECDSAKeys(ecdsaKeys []*ecdsa.PublicKey, algo, incomingToken string)
 	return
 }
 
+func validateEd25519Keys(ed25519Keys []ed25519.PublicKey, algo, incomingToken string) (token *jwt.Token, err error) {
+	for _, pubkey := range ed25519Keys {
+		token, err = signatureParser.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
+			if token.Method.Alg() != algo {
+				return nil, goaJwt.ErrJWTError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
+			}
+			return pubkey, nil
+		})
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
 func validateHMACKeys(hmacKeys [][]byte, algo, incomingToken string) (token *jwt.Token, err error) {
 	for _, key := range hmacKeys {
-		token, err = jwt.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
+		token, err = signatureParser.Parse(incomingToken, func(token *jwt.Token) (interface{}, error) {
 			if !strings.HasPrefix(token.Method.Alg(), algo) {
 				return nil, goaJwt.ErrJWTError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]))
 			}
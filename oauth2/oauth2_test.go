@@ -3,10 +3,12 @@ package oauth2
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
@@ -14,8 +16,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Microkubes/microservice-security/auth"
+	jormungandrJwt "github.com/Microkubes/microservice-security/jwt"
 	"github.com/keitaroinc/goa"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -97,6 +101,135 @@ func TestNewOAuth2SecurityMiddleware(t *testing.T) {
 	}
 }
 
+func TestNewOAuth2SecurityMiddlewareWithJWKS(t *testing.T) {
+	key, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		jwk, _ := jormungandrJwt.NewJWK(key)
+		jwk.Kid = "key-1"
+		json.NewEncoder(rw).Encode(jormungandrJwt.JWKSet{
+			Keys: []jormungandrJwt.JWK{*jwk},
+		})
+	}))
+	defer server.Close()
+
+	resolver, err := jormungandrJwt.NewJWKSKeyResolver(server.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	tokenStr, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
+
+	modifiedCtx := context.Background()
+	middleware := NewOAuth2SecurityMiddleware(resolver, &scheme)
+	err = middleware(func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		modifiedCtx = c
+		return nil
+	})(context.Background(), nil, req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.HasAuth(modifiedCtx) {
+		t.Fatal("Expected authentication to be set!")
+	}
+}
+
+func TestValidateEd25519Keys(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenStr, err := jormungandrJwt.SignToken(claims, "EdDSA", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, ed25519Keys, _ := partitionKeys([]goaJwt.Key{publicKey})
+
+	_, err = validateEd25519Keys(ed25519Keys, "EdDSA", tokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewOAuth2SecurityMiddlewarePS256(t *testing.T) {
+	resolver, key, err := newRSAKeyResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenStr, err := jormungandrJwt.SignToken(claims, "PS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
+
+	modifiedCtx := context.Background()
+	err = NewOAuth2SecurityMiddleware(resolver, &scheme)(func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		modifiedCtx = c
+		return nil
+	})(context.Background(), nil, req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.HasAuth(modifiedCtx) {
+		t.Fatal("Expected authentication to be set!")
+	}
+}
+
+func TestNewOAuth2SecurityMiddlewareClaimsValidation(t *testing.T) {
+	resolver, key, err := newRSAKeyResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiredClaims := jwt.MapClaims{}
+	for name, value := range claims {
+		expiredClaims[name] = value
+	}
+	expiredClaims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+
+	tokenStr, err := jormungandrJwt.SignToken(expiredClaims, "RS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
+	handler := func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}
+
+	err = NewOAuth2SecurityMiddleware(resolver, &scheme)(handler)(context.Background(), nil, req)
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "token_expired" {
+		t.Fatal("Expected token_expired error, got", err)
+	}
+
+	err = NewOAuth2SecurityMiddlewareWithOptions(resolver, &scheme, &jormungandrJwt.SecurityOptions{
+		Validation: &jormungandrJwt.ClaimsValidation{
+			ClockSkew: 60,
+		},
+	})(handler)(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPartitionKeys(t *testing.T) {
 	resolver, key, err := newRSAKeyResolver()
 	if err != nil {
@@ -112,7 +245,7 @@ func TestPartitionKeys(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com", nil)
 	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
 
-	rsaKeys, ecdsaKeys, hmacKeys := partitionKeys(resolver.SelectKeys(req))
+	rsaKeys, ecdsaKeys, _, hmacKeys := partitionKeys(resolver.SelectKeys(req))
 
 	if !(len(rsaKeys) > 0 || len(ecdsaKeys) > 0 || len(hmacKeys) > 0) {
 		t.Fatal("Expected to have RSA public key!")
@@ -134,7 +267,7 @@ func TestParseClaimScopes(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com", nil)
 	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
 
-	rsaKeys, _, _ := partitionKeys(resolver.SelectKeys(req))
+	rsaKeys, _, _, _ := partitionKeys(resolver.SelectKeys(req))
 
 	tokenRSA, err := validateRSAKeys(rsaKeys, "RS", tokenStr)
 	if err != nil {
@@ -161,7 +294,7 @@ func TestValidateRSAKeys(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com", nil)
 	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
 
-	rsaKeys, _, _ := partitionKeys(resolver.SelectKeys(req))
+	rsaKeys, _, _, _ := partitionKeys(resolver.SelectKeys(req))
 
 	_, err = validateRSAKeys(rsaKeys, "RS", tokenStr)
 	if err != nil {
@@ -184,7 +317,7 @@ func TestValidateECDSAKeys(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com", nil)
 	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
 
-	_, ecdsaKeys, _ := partitionKeys(resolver.SelectKeys(req))
+	_, ecdsaKeys, _, _ := partitionKeys(resolver.SelectKeys(req))
 
 	_, err = validateECDSAKeys(ecdsaKeys, "ES", tokenStr)
 	if err != nil {
@@ -205,7 +338,7 @@ func TestValidateHMACKeys(t *testing.T) {
 	req := httptest.NewRequest("GET", "http://example.com", nil)
 	req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", tokenStr)}
 
-	_, _, hmacKeys := partitionKeys(resolver.SelectKeys(req))
+	_, _, _, hmacKeys := partitionKeys(resolver.SelectKeys(req))
 
 	_, err = validateHMACKeys(hmacKeys, "HS", tokenStr)
 	if err != nil {
@@ -0,0 +1,163 @@
+package oauth2
+
+import (
+	"crypto"
+	_ "crypto/sha256" // SHA-256 for "at_hash"
+	_ "crypto/sha512" // SHA-384 and SHA-512 for "at_hash"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/keitaroinc/goa"
+)
+
+// OpenIDScope is the scope that requests an OpenID Connect ID token.
+const OpenIDScope = "openid"
+
+// OAuth2ErrorInvalidToken is an error for a missing, expired or revoked bearer token (RFC 6750 section 3.1)
+var OAuth2ErrorInvalidToken = goa.NewErrorClass("invalid_token", 401)
+
+// OAuth2ErrorInsufficientScope is an error for a bearer token without the scope required by the request
+var OAuth2ErrorInsufficientScope = goa.NewErrorClass("insufficient_scope", 403)
+
+// UserInfoService is a UserService that can look up a user by its ID. If the UserService of the AuthProvider
+// implements it, UserInfo returns the current user data instead of the user data in the access token.
+type UserInfoService interface {
+	UserService
+
+	// GetUser retrieves the User by its ID, or nil if there is no such user.
+	GetUser(userID string) (*User, error)
+}
+
+// AccessTokenHash calculates the "at_hash" claim of an ID token (OpenID Connect Core 1.0 section 3.1.3.6):
+// the base64url encoded left-most half of the hash of the access token. The hash function is the one used by
+// the signing method of the ID token (SHA-512 for EdDSA).
+func AccessTokenHash(accessToken, signingMethod string) (string, error) {
+	var hash crypto.Hash
+	switch {
+	case signingMethod == "EdDSA", strings.HasSuffix(signingMethod, "512"):
+		hash = crypto.SHA512
+	case strings.HasSuffix(signingMethod, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(signingMethod, "256"):
+		hash = crypto.SHA256
+	default:
+		return "", fmt.Errorf("unsupported signing method %s", signingMethod)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(accessToken))
+	sum := hasher.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// generateIDToken generates an OpenID Connect ID token for the user authorized with the ClientAuth.
+// The ID token is signed with the same key and signing method as the access token, but has the "JWT" type header.
+func (provider *AuthProvider) generateIDToken(clientAuth *ClientAuth, accessToken string) (string, error) {
+	key, err := provider.KeyStore.GetPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	atHash, err := AccessTokenHash(accessToken, provider.SigningMethod)
+	if err != nil {
+		return "", err
+	}
+
+	authTime := clientAuth.AuthTime
+	if authTime == 0 {
+		authTime = clientAuth.GeneratedAt
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       provider.ProviderName,
+		"sub":       clientAuth.UserID,
+		"aud":       clientAuth.ClientID,
+		"exp":       now.Add(time.Duration(provider.AccessTokenValidityPeriod) * time.Millisecond).Unix(),
+		"iat":       now.Unix(),
+		"auth_time": authTime,
+		"at_hash":   atHash,
+	}
+	if clientAuth.Nonce != "" {
+		claims["nonce"] = clientAuth.Nonce
+	}
+
+	return jwt.SignToken(claims, provider.SigningMethod, key)
+}
+
+// UserInfo returns the OpenID Connect claims (OpenID Connect Core 1.0 section 5.3) of the user the access token
+// has been issued for. The access token must be active and must have the "openid" scope. The "profile" scope
+// adds the "preferred_username" and "name" claims, and the "email" scope adds the "email" claim. The "roles",
+// "organizations" and "namespaces" of the user are always returned.
+func (provider *AuthProvider) UserInfo(accessToken string) (map[string]interface{}, error) {
+	claims, err := provider.introspectAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, OAuth2ErrorInvalidToken("the access token is not valid")
+	}
+	scope, _ := claims["scope"].(string)
+	if !hasScope(scope, OpenIDScope) {
+		return nil, OAuth2ErrorInsufficientScope("the access token does not have the openid scope")
+	}
+
+	authObj, err := jwt.DefaultClaimMapper().MapClaims(claims)
+	if err != nil {
+		return nil, OAuth2ErrorInvalidToken(err)
+	}
+	if userInfoService, ok := provider.UserService.(UserInfoService); ok {
+		if authObj, err = provider.currentUserAuth(userInfoService, authObj); err != nil {
+			return nil, err
+		}
+	}
+
+	userInfo := map[string]interface{}{
+		"sub": authObj.UserID,
+	}
+	if hasScope(scope, "profile") {
+		setNonEmpty(userInfo, "preferred_username", authObj.Username)
+		setNonEmpty(userInfo, "name", authObj.Fullname)
+	}
+	if hasScope(scope, "email") {
+		setNonEmpty(userInfo, "email", authObj.Email)
+	}
+	if len(authObj.Roles) > 0 {
+		userInfo["roles"] = authObj.Roles
+	}
+	if len(authObj.Organizations) > 0 {
+		userInfo["organizations"] = authObj.Organizations
+	}
+	if len(authObj.Namespaces) > 0 {
+		userInfo["namespaces"] = authObj.Namespaces
+	}
+	return userInfo, nil
+}
+
+// currentUserAuth updates the Auth from the access token with the current user data.
+func (provider *AuthProvider) currentUserAuth(userInfoService UserInfoService, authObj *auth.Auth) (*auth.Auth, error) {
+	user, err := userInfoService.GetUser(authObj.UserID)
+	if err != nil {
+		return nil, InternalServerError("Failed to look up the user", err)
+	}
+	if user == nil || !user.Active {
+		return nil, OAuth2ErrorInvalidToken("the user does not exist or is not active")
+	}
+	return &auth.Auth{
+		UserID:        authObj.UserID,
+		Username:      user.Username,
+		Fullname:      authObj.Fullname,
+		Email:         user.Email,
+		Roles:         user.Roles,
+		Organizations: user.Organizations,
+		Namespaces:    user.Namespaces,
+	}, nil
+}
+
+// setNonEmpty sets the value under the key, unless the value is empty.
+func setNonEmpty(values map[string]interface{}, key, value string) {
+	if value != "" {
+		values[key] = value
+	}
+}
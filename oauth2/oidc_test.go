@@ -0,0 +1,161 @@
+package oauth2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+)
+
+type DummyUserInfoService struct {
+	*DummyUserService
+}
+
+func (d *DummyUserInfoService) GetUser(userID string) (*User, error) {
+	for _, user := range d.Users {
+		if user.ID == userID {
+			return &user.User, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestAccessTokenHash(t *testing.T) {
+	// OpenID Connect Core 1.0 appendix A.3
+	atHash, err := AccessTokenHash("jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atHash != "77QmUPtjPfzWtF2AnpK9RQ" {
+		t.Fatal("Unexpected at_hash", atHash)
+	}
+	if _, err = AccessTokenHash("token", "HS1"); err == nil {
+		t.Fatal("Expected unsupported signing method to be rejected")
+	}
+}
+
+func TestExchangeWithIDToken(t *testing.T) {
+	provider := getMockedProvider(t)
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+
+	code, err := provider.AuthorizeWithNonce("001", "openid api:read", "http://example.com:8080", "", "", "n-0S6_WzA2Mj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSvcMock.UpdateUserData("001", code, "10001", "{\"userId\":\"10001\",\"username\":\"user\"}")
+
+	_, accessToken, idToken, _, err := provider.ExchangeWithIDToken("001", code, "http://example.com:8080", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken == "" {
+		t.Fatal("Expected ID token to be issued for the openid scope")
+	}
+
+	key, _ := provider.KeyStore.GetPrivateKey()
+	token, err := jwtgo.Parse(idToken, func(token *jwtgo.Token) (interface{}, error) {
+		return jwt.PublicKey(key), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(jwtgo.MapClaims)
+	atHash, _ := AccessTokenHash(accessToken, provider.SigningMethod)
+	if claims["iss"] != "unit-test-dummy" || claims["sub"] != "10001" || claims["aud"] != "001" ||
+		claims["nonce"] != "n-0S6_WzA2Mj" || claims["at_hash"] != atHash || claims["auth_time"] == nil {
+		t.Fatal("Unexpected ID token claims", claims)
+	}
+	if token.Header["typ"] != "JWT" {
+		t.Fatal("Expected the ID token to have the JWT type", token.Header)
+	}
+
+	// the ID token is not an access token
+	response, err := provider.Introspect(idToken, "access_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response["active"] != false {
+		t.Fatal("Expected the ID token to be inactive", response)
+	}
+	if _, err = provider.UserInfo(idToken); err == nil {
+		t.Fatal("Expected the ID token to be rejected by the userinfo")
+	}
+	provider.ClientService.(*DummyClientService).Clients["service-a"] = &Client{
+		ClientID:               "service-a",
+		Secret:                 "abc",
+		TokenExchangeAudiences: []string{"service-b"},
+	}
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{SubjectToken: idToken, Audience: "service-b"})
+	expectOAuth2Error(t, err, "invalid_request")
+
+	// no ID token without the openid scope
+	code, err = provider.Authorize("001", "api:read", "http://example.com:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSvcMock.UpdateUserData("001", code, "10001", "{\"userId\":\"10001\"}")
+	if _, _, idToken, _, err = provider.ExchangeWithIDToken("001", code, "http://example.com:8080", ""); err != nil || idToken != "" {
+		t.Fatal("Expected no ID token without the openid scope", err)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	provider := getMockedProvider(t)
+
+	oauth2Token, err := provider.generateOAuthToken("001", "openid profile", map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+		"email":    "user@example.com",
+		"roles":    "user",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo, err := provider.UserInfo(oauth2Token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"sub":                "10001",
+		"preferred_username": "user",
+		"roles":              []string{"user"},
+	}
+	if !reflect.DeepEqual(userInfo, expected) {
+		t.Fatal("Unexpected user info", userInfo)
+	}
+
+	// current user data from the UserInfoService
+	userService := provider.UserService.(*DummyUserService)
+	provider.UserService = &DummyUserInfoService{userService}
+	userService.Users["10001"].Active = true
+	userService.Users["10001"].Username = "renamed"
+	if userInfo, err = provider.UserInfo(oauth2Token.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo["preferred_username"] != "renamed" || !reflect.DeepEqual(userInfo["organizations"], []string{"org1", "org2"}) {
+		t.Fatal("Expected the current user data", userInfo)
+	}
+
+	userService.Users["10001"].Active = false
+	_, err = provider.UserInfo(oauth2Token.AccessToken)
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_token" {
+		t.Fatal("Expected invalid_token error for inactive user, got", err)
+	}
+
+	oauth2Token, err = provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.UserInfo(oauth2Token.AccessToken)
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "insufficient_scope" {
+		t.Fatal("Expected insufficient_scope error, got", err)
+	}
+
+	_, err = provider.UserInfo("invalid-token")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_token" {
+		t.Fatal("Expected invalid_token error, got", err)
+	}
+}
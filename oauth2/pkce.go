@@ -0,0 +1,58 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// CodeChallengeMethodPlain is the "plain" PKCE code challenge method - the challenge is the verifier itself.
+	CodeChallengeMethodPlain = "plain"
+
+	// CodeChallengeMethodS256 is the "S256" PKCE code challenge method - the challenge is the
+	// base64url encoded SHA-256 hash of the verifier.
+	CodeChallengeMethodS256 = "S256"
+)
+
+// CodeChallengeS256 calculates the S256 code challenge for the code verifier (RFC 7636 section 4.2).
+func CodeChallengeS256(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// VerifyCodeChallenge verifies the PKCE code verifier against the code challenge created with the given method.
+func VerifyCodeChallenge(codeChallenge, codeChallengeMethod, codeVerifier string) error {
+	if err := validatePKCEValue(codeVerifier); err != nil {
+		return fmt.Errorf("invalid code verifier: %s", err)
+	}
+	var expected string
+	switch codeChallengeMethod {
+	case CodeChallengeMethodS256:
+		expected = CodeChallengeS256(codeVerifier)
+	case CodeChallengeMethodPlain, "":
+		expected = codeVerifier
+	default:
+		return fmt.Errorf("unsupported code challenge method %s", codeChallengeMethod)
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) != 1 {
+		return fmt.Errorf("code verifier does not match the code challenge")
+	}
+	return nil
+}
+
+// validatePKCEValue checks the length and the characters of a code verifier or a code challenge
+// (RFC 7636 section 4.1).
+func validatePKCEValue(value string) error {
+	if len(value) < 43 || len(value) > 128 {
+		return fmt.Errorf("must be between 43 and 128 characters long")
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~') {
+			return fmt.Errorf("contains invalid character %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package oauth2
+
+import "testing"
+
+func TestCodeChallengeS256(t *testing.T) {
+	// RFC 7636 appendix B
+	if challenge := CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatal("Unexpected code challenge", challenge)
+	}
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		valid     bool
+	}{
+		{"S256", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "S256", verifier, true},
+		{"plain", verifier, "plain", verifier, true},
+		{"default method", verifier, "", verifier, true},
+		{"S256 mismatch", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "S256", verifier[1:] + "a", false},
+		{"short verifier", "abc", "plain", "abc", false},
+		{"invalid characters", verifier[1:] + "+", "plain", verifier[1:] + "+", false},
+		{"unsupported method", verifier, "S512", verifier, false},
+	}
+	for _, test := range tests {
+		err := VerifyCodeChallenge(test.challenge, test.method, test.verifier)
+		if test.valid && err != nil {
+			t.Fatalf("%s: unexpected error %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Microkubes/microservice-security/auth"
 	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/Microkubes/microservice-security/revocation"
 	"github.com/Microkubes/microservice-security/tools"
+	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/keitaroinc/goa"
 	uuid "github.com/satori/go.uuid"
 )
@@ -30,6 +35,26 @@ var OAuth2ErrorUnauthorizedClient = goa.NewErrorClass("unauthorized_client", 401
 // OAuth2AccessDenied is an access denied error for created auth
 var OAuth2AccessDenied = goa.NewErrorClass("access_denied", 403)
 
+// OAuth2ErrorInvalidRequest is Bad Request error for a malformed request or token
+var OAuth2ErrorInvalidRequest = goa.NewErrorClass("invalid_request", 400)
+
+// OAuth2ErrorInvalidClient is an error for failed client authentication
+var OAuth2ErrorInvalidClient = goa.NewErrorClass("invalid_client", 401)
+
+// OAuth2ErrorUnsupportedTokenType is Bad Request error for revocation of a token type that is not supported
+var OAuth2ErrorUnsupportedTokenType = goa.NewErrorClass("unsupported_token_type", 400)
+
+// OAuth2ErrorInvalidGrant is Bad Request error for an invalid authorization grant (auth code or PKCE verifier)
+var OAuth2ErrorInvalidGrant = goa.NewErrorClass("invalid_grant", 400)
+
+// AccessTokenJWTType is the "typ" header of the access tokens issued by the AuthProvider (RFC 9068). It tells the
+// access tokens apart from the ID tokens, which are signed with the same key but have the "JWT" type.
+const AccessTokenJWTType = "at+jwt"
+
+// DefaultAuthCodeValidityPeriod is the default validity period of the authorization code in milliseconds (10 minutes),
+// the maximum recommended by RFC 6749 section 4.1.2.
+const DefaultAuthCodeValidityPeriod = 10 * 60 * 1000
+
 // Client holds the data for a specific client (app).
 // A client must firt be registered for access on the platform.
 type Client struct {
@@ -38,6 +63,46 @@ type Client struct {
 	Description string `json:"description, omitempty"`
 	Website     string `json:"domain, omitempty"`
 	Secret      string `json:"secret, omitempty"`
+
+	// SecretHash is the hash of the client secret (see HashClientSecret). If set, it is used to verify the
+	// secret instead of the plain text Secret.
+	SecretHash string `json:"secretHash,omitempty"`
+
+	// TokenEndpointAuthMethod is the authentication method of a dynamically registered client.
+	TokenEndpointAuthMethod string `json:"tokenEndpointAuthMethod,omitempty"`
+
+	// RegistrationAccessTokenHash is the hash of the registration access token of a dynamically registered client.
+	RegistrationAccessTokenHash string `json:"registrationAccessTokenHash,omitempty"`
+
+	// IssuedAt is the Unix timestamp of the time when a dynamically registered client was registered.
+	IssuedAt int64 `json:"issuedAt,omitempty"`
+
+	// RequirePKCE requires the client to use PKCE (RFC 7636) in the authorization code flow.
+	// Should be set for public clients (SPA and mobile apps) that cannot keep the secret.
+	RequirePKCE bool `json:"requirePkce,omitempty"`
+
+	// GrantTypes is the list of grant types the client may use. Set for the dynamically registered clients;
+	// if empty, the client may use all grant types.
+	GrantTypes []string `json:"grantTypes,omitempty"`
+
+	// Scopes is the list of scopes the client may request in the client credentials grant.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// RedirectURIs is the list of registered redirect URIs. The redirect URI sent by the client must match
+	// one of them exactly. If empty, the Website is used as the only registered redirect URI.
+	RedirectURIs []string `json:"redirectUris,omitempty"`
+
+	// AllowLoopbackPort allows any port in loopback redirect URIs (http://127.0.0.1, http://[::1] or
+	// http://localhost), for native apps that listen on an ephemeral port (RFC 8252 section 7.3).
+	AllowLoopbackPort bool `json:"allowLoopbackPort,omitempty"`
+
+	// TokenExchangeAudiences is the list of audiences (target services) for which the client may exchange tokens
+	// (see AuthProvider.ExchangeToken). If empty, the client may not use the token exchange.
+	TokenExchangeAudiences []string `json:"tokenExchangeAudiences,omitempty"`
+
+	// LegacyRedirectURIMatching enables the legacy matching of the redirect URI, which only compares the scheme
+	// and the host (with port) of the redirect URI with the Website. Use only for legacy clients.
+	LegacyRedirectURIMatching bool `json:"legacyRedirectUriMatching,omitempty"`
 }
 
 // ClientAuth is an authorization record for a specific client (app) and user.
@@ -52,6 +117,25 @@ type ClientAuth struct {
 	UserData    string `json:"userData, omitempty" bson:"userData"`
 	RedirectURI string `json:"redirectUri, omitempty" bson:"redirectUri"`
 	Confirmed   bool   `json:"confirmed, omitempty" bson:"confirmed"`
+
+	// CodeChallenge is the PKCE code challenge sent with the authorization request.
+	CodeChallenge string `json:"codeChallenge,omitempty" bson:"codeChallenge"`
+
+	// CodeChallengeMethod is the PKCE code challenge method ("S256" or "plain").
+	CodeChallengeMethod string `json:"codeChallengeMethod,omitempty" bson:"codeChallengeMethod"`
+
+	// Consumed is set once the authorization code has been exchanged for tokens.
+	Consumed bool `json:"consumed,omitempty" bson:"consumed"`
+
+	// FamilyID is the FamilyID of the tokens issued for the authorization code.
+	FamilyID string `json:"familyId,omitempty" bson:"familyId"`
+
+	// Nonce is the OpenID Connect nonce sent with the authorization request. It is returned in the ID token.
+	Nonce string `json:"nonce,omitempty" bson:"nonce"`
+
+	// AuthTime is the Unix timestamp of the time when the user authenticated. If not set, GeneratedAt is
+	// used as "auth_time" in the ID token.
+	AuthTime int64 `json:"authTime,omitempty" bson:"authTime"`
 }
 
 // ClientService is an interface that defines the access to a Client and ClientAuth.
@@ -88,6 +172,19 @@ type ClientService interface {
 	DeleteClientAuth(clientID, code string) error
 }
 
+// SingleUseClientService is a ClientService that consumes the authorization codes atomically.
+// When it is used by the AuthProvider, the consumed ClientAuth is kept (until it expires), so an authorization
+// code that is presented a second time can be detected and the tokens issued for it revoked.
+// GetClientAuth must return the consumed ClientAuth as well.
+type SingleUseClientService interface {
+	ClientService
+
+	// ConsumeClientAuth marks the ClientAuth as consumed and records the FamilyID of the tokens issued for it.
+	// It must be atomic: it returns true only for the first call for an authorization code, and false if the
+	// code has already been consumed or does not exist.
+	ConsumeClientAuth(clientID, code, familyID string) (bool, error)
+}
+
 // User holds the user data.
 type User struct {
 	ID            string   `json:"id, omitempty"`
@@ -131,6 +228,13 @@ type AuthToken struct {
 
 	// UserID is the reference to the user for which this token has been issued.
 	UserID string `json:"userId, omitempty" bson:"userId"`
+
+	// FamilyID identifies the family of refresh tokens - all tokens obtained by refreshing the same
+	// initial token share the FamilyID.
+	FamilyID string `json:"familyId,omitempty" bson:"familyId"`
+
+	// Used is set once the refresh token has been exchanged for a new token pair.
+	Used bool `json:"used,omitempty" bson:"used"`
 }
 
 // TokenService defines the interface for managing OAuth2 Tokens.
@@ -147,6 +251,31 @@ type TokenService interface {
 	GetTokenForClient(userID, clientID string) (*AuthToken, error)
 }
 
+// RevocableTokenService is a TokenService that supports revocation of the refresh tokens.
+// It is required by AuthProvider.RevokeToken to revoke refresh tokens.
+type RevocableTokenService interface {
+	TokenService
+
+	// DeleteToken deletes (or marks as revoked) the OAuth2Token for the refresh token,
+	// so it cannot be used anymore.
+	DeleteToken(refreshToken string) error
+}
+
+// RotatingTokenService is a TokenService that supports refresh token rotation with reuse detection.
+// When it is used by the AuthProvider, every refresh token can be used only once. Presenting an already
+// used refresh token revokes the whole token family.
+// GetToken must return the used tokens as well, so the reuse can be detected.
+type RotatingTokenService interface {
+	TokenService
+
+	// MarkTokenUsed marks the refresh token as used. It must be atomic: it returns true only for the
+	// first call for a refresh token, and false if the token has already been used.
+	MarkTokenUsed(refreshToken string) (bool, error)
+
+	// DeleteTokenFamily deletes (or marks as revoked) all tokens with the given FamilyID.
+	DeleteTokenFamily(familyID string) error
+}
+
 // AuthProvider holds the data for implementing the oauth2.Provider interface.
 type AuthProvider struct {
 	ClientService
@@ -158,27 +287,86 @@ type AuthProvider struct {
 	RefreshTokenLength        int
 	AccessTokenValidityPeriod int
 	ProviderName              string
+
+	// AuthCodeValidityPeriod is the time (in milliseconds) for which the authorization code can be exchanged
+	// for tokens. If not set, DefaultAuthCodeValidityPeriod is used.
+	AuthCodeValidityPeriod int
+
+	// RevocationStore keeps the IDs of the revoked access tokens. Required by Revoke.
+	RevocationStore revocation.RevocationStore
+
+	// DeviceAuthorizationService stores the device authorizations. Required by the device authorization grant.
+	DeviceAuthorizationService DeviceAuthorizationService
+
+	// DeviceVerificationURI is the URL of the page where the user enters the user code of a device authorization.
+	DeviceVerificationURI string
+
+	// DeviceCodeValidityPeriod is the time (in milliseconds) for which the device code and the user code are valid.
+	// If not set, DefaultDeviceCodeValidityPeriod is used.
+	DeviceCodeValidityPeriod int
+
+	// DevicePollingInterval is the minimal time (in seconds) between two token requests of a device.
+	// If not set, DefaultDevicePollingInterval is used.
+	DevicePollingInterval int
+
+	// ClientRegistrationScopes is the list of scopes the dynamically registered clients may register.
+	// If empty, the clients cannot register any scopes.
+	ClientRegistrationScopes []string
 }
 
 // Authorize performs the authorization of a client and generates basic ClientAuth.
 func (provider *AuthProvider) Authorize(clientID, scope, redirectURI string) (code string, err error) {
+	return provider.AuthorizeWithPKCE(clientID, scope, redirectURI, "", "")
+}
+
+// AuthorizeWithPKCE performs the authorization of a client like Authorize and stores the PKCE (RFC 7636)
+// code challenge in the ClientAuth. The codeChallengeMethod may be "S256" or "plain" (the default).
+// If the client requires PKCE, the code challenge is mandatory.
+func (provider *AuthProvider) AuthorizeWithPKCE(clientID, scope, redirectURI, codeChallenge, codeChallengeMethod string) (code string, err error) {
+	return provider.AuthorizeWithNonce(clientID, scope, redirectURI, codeChallenge, codeChallengeMethod, "")
+}
+
+// AuthorizeWithNonce performs the authorization of a client like AuthorizeWithPKCE and stores the OpenID Connect
+// nonce in the ClientAuth, to be returned in the ID token.
+func (provider *AuthProvider) AuthorizeWithNonce(clientID, scope, redirectURI, codeChallenge, codeChallengeMethod, nonce string) (code string, err error) {
 	client, err := provider.ClientService.GetClient(clientID)
-	if err != nil {
+	if err != nil || client == nil {
 		return "", OAuth2ErrorUnauthorizedClient("invalid_client")
 	}
-	if err = CompareRedirectURI(client.Website, redirectURI); err != nil {
-		return "", OAuth2ErrorInvalidRedirectURI("invalid_request")
+	if !client.AllowsGrantType("authorization_code") {
+		return "", OAuth2ErrorUnauthorizedClient("the client may not use the authorization code grant")
+	}
+	if err = client.ValidateRedirectURI(redirectURI); err != nil {
+		return "", OAuth2ErrorInvalidRedirectURI(err)
+	}
+	if codeChallenge == "" {
+		if client.RequirePKCE {
+			return "", OAuth2ErrorInvalidRequest("code challenge required")
+		}
+	} else {
+		if codeChallengeMethod == "" {
+			codeChallengeMethod = CodeChallengeMethodPlain
+		}
+		if codeChallengeMethod != CodeChallengeMethodS256 && codeChallengeMethod != CodeChallengeMethodPlain {
+			return "", OAuth2ErrorInvalidRequest("transform algorithm not supported")
+		}
+		if err = validatePKCEValue(codeChallenge); err != nil {
+			return "", OAuth2ErrorInvalidRequest("invalid code challenge", err)
+		}
 	}
 	code, err = GenerateRandomCode(provider.AuthCodeLength)
 	if err != nil {
 		return "", InternalServerError("server_error")
 	}
 	err = provider.ClientService.SaveClientAuth(&ClientAuth{
-		ClientID:    clientID,
-		Code:        code,
-		GeneratedAt: time.Now().Unix(),
-		Scope:       scope,
-		RedirectURI: redirectURI,
+		ClientID:            clientID,
+		Code:                code,
+		GeneratedAt:         time.Now().Unix(),
+		Scope:               scope,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		Nonce:               nonce,
 		// Note that the UserData field MUST be populated afterwards, in a special flow
 		// with user interaction (usually after the authorize has completed and the resource
 		// owner has authorized the client. At that point we have the user logged in and we serialize the user data)
@@ -191,30 +379,168 @@ func (provider *AuthProvider) Authorize(clientID, scope, redirectURI string) (co
 
 // Exchange exchanges the confimed ClientAuth for an access token and refresh token.
 func (provider *AuthProvider) Exchange(clientID, code, redirectURI string) (refreshToken, accessToken string, expiresIn int, err error) {
+	return provider.ExchangeWithPKCE(clientID, code, redirectURI, "")
+}
+
+// ExchangeWithPKCE exchanges the confimed ClientAuth for an access token and refresh token like Exchange.
+// If a PKCE code challenge was sent with the authorization request, the codeVerifier must match it.
+// The authorization code must be exchanged within the AuthCodeValidityPeriod and can be used only once.
+// If the ClientService is a SingleUseClientService, presenting an already consumed code revokes the tokens
+// issued for it with OAuth2ErrorInvalidGrant.
+func (provider *AuthProvider) ExchangeWithPKCE(clientID, code, redirectURI, codeVerifier string) (refreshToken, accessToken string, expiresIn int, err error) {
+	refreshToken, accessToken, _, expiresIn, err = provider.ExchangeWithIDToken(clientID, code, redirectURI, codeVerifier)
+	return refreshToken, accessToken, expiresIn, err
+}
+
+// ExchangeWithIDToken exchanges the confimed ClientAuth for tokens like ExchangeWithPKCE. If the "openid" scope
+// has been granted, an OpenID Connect ID token is issued as well.
+func (provider *AuthProvider) ExchangeWithIDToken(clientID, code, redirectURI, codeVerifier string) (refreshToken, accessToken, idToken string, expiresIn int, err error) {
 	clientAuth, err := provider.ClientService.GetClientAuth(clientID, code)
 	if err != nil {
-		return "", "", 0, InternalServerError("Failed to verify client authentication", err)
+		return "", "", "", 0, InternalServerError("Failed to verify client authentication", err)
 	}
 	if clientAuth == nil || clientAuth.UserData == "" {
-		return "", "", 0, OAuth2AccessDenied("client not authorized")
+		return "", "", "", 0, OAuth2AccessDenied("client not authorized")
+	}
+	if clientAuth.Consumed {
+		return "", "", "", 0, provider.revokeConsumedCode(clientAuth)
+	}
+	if provider.authCodeExpired(clientAuth) {
+		return "", "", "", 0, OAuth2ErrorInvalidGrant("authorization code has expired")
 	}
 
 	client, err := provider.ClientService.GetClient(clientID)
 	if err != nil {
-		return "", "", 0, InternalServerError("Unable to verify client at this time")
+		return "", "", "", 0, InternalServerError("Unable to verify client at this time")
 	}
 	if client == nil {
-		return "", "", 0, OAuth2AccessDenied("client not registered")
+		return "", "", "", 0, OAuth2AccessDenied("client not registered")
+	}
+	if !client.AllowsGrantType("authorization_code") {
+		return "", "", "", 0, OAuth2ErrorUnauthorizedClient("the client may not use the authorization code grant")
+	}
+
+	if err = client.ValidateRedirectURI(redirectURI); err != nil {
+		return "", "", "", 0, OAuth2ErrorInvalidRedirectURI(err)
+	}
+	if clientAuth.RedirectURI != "" && clientAuth.RedirectURI != redirectURI {
+		return "", "", "", 0, OAuth2ErrorInvalidGrant("redirect URI does not match the authorization request")
+	}
+
+	if clientAuth.CodeChallenge != "" {
+		if codeVerifier == "" {
+			return "", "", "", 0, OAuth2ErrorInvalidGrant("code verifier required")
+		}
+		if err = VerifyCodeChallenge(clientAuth.CodeChallenge, clientAuth.CodeChallengeMethod, codeVerifier); err != nil {
+			return "", "", "", 0, OAuth2ErrorInvalidGrant(err)
+		}
+	} else if client.RequirePKCE {
+		return "", "", "", 0, OAuth2ErrorInvalidGrant("the authorization was not made with a code challenge")
+	}
+
+	userData, err := parseUserData(clientAuth.UserData)
+	if err != nil {
+		return "", "", "", 0, InternalServerError("Failed to read user data", err)
+	}
+
+	familyID, err := provider.consumeAuthCode(clientAuth)
+	if err != nil {
+		return "", "", "", 0, err
+	}
+
+	oauth2Token, err := provider.generateOAuthToken(clientID, clientAuth.Scope, userData, familyID)
+	if err != nil {
+		return "", "", "", 0, InternalServerError("Failed to generate access token and refresh token", err)
+	}
+
+	if hasScope(clientAuth.Scope, OpenIDScope) {
+		idToken, err = provider.generateIDToken(clientAuth, oauth2Token.AccessToken)
+		if err != nil {
+			return "", "", "", 0, InternalServerError("Failed to generate ID token", err)
+		}
+	}
+
+	return oauth2Token.RefreshToken, oauth2Token.AccessToken, idToken, oauth2Token.ValidFor, nil
+}
+
+// authCodeExpired checks whether the authorization code is older than the AuthCodeValidityPeriod.
+func (provider *AuthProvider) authCodeExpired(clientAuth *ClientAuth) bool {
+	validityPeriod := provider.AuthCodeValidityPeriod
+	if validityPeriod <= 0 {
+		validityPeriod = DefaultAuthCodeValidityPeriod
+	}
+	expiresAt := time.Unix(clientAuth.GeneratedAt, 0).Add(time.Duration(validityPeriod) * time.Millisecond)
+	return time.Now().After(expiresAt)
+}
+
+// consumeAuthCode invalidates the authorization code before the tokens are issued and returns the FamilyID for
+// the tokens. With a SingleUseClientService the code is consumed atomically, otherwise the ClientAuth is deleted.
+func (provider *AuthProvider) consumeAuthCode(clientAuth *ClientAuth) (string, error) {
+	familyUUID, err := uuid.NewV4()
+	if err != nil {
+		return "", InternalServerError(err)
+	}
+	familyID := familyUUID.String()
+
+	clientService, ok := provider.ClientService.(SingleUseClientService)
+	if !ok {
+		if err = provider.ClientService.DeleteClientAuth(clientAuth.ClientID, clientAuth.Code); err != nil {
+			return "", InternalServerError(err)
+		}
+		return familyID, nil
+	}
+
+	consumed, err := clientService.ConsumeClientAuth(clientAuth.ClientID, clientAuth.Code, familyID)
+	if err != nil {
+		return "", InternalServerError("Failed to consume the authorization code", err)
+	}
+	if !consumed {
+		// concurrent exchange of the same code
+		consumedAuth, err := clientService.GetClientAuth(clientAuth.ClientID, clientAuth.Code)
+		if err != nil {
+			return "", InternalServerError("Failed to verify client authentication", err)
+		}
+		if consumedAuth == nil {
+			return "", OAuth2ErrorInvalidGrant("authorization code has already been used")
+		}
+		return "", provider.revokeConsumedCode(consumedAuth)
+	}
+	return familyID, nil
+}
+
+// revokeConsumedCode revokes the tokens issued for an authorization code that has been presented a second time
+// (RFC 6749 section 4.1.2). Revocation of the refresh tokens requires a RotatingTokenService and revocation of the
+// access tokens requires a RevocationStore.
+func (provider *AuthProvider) revokeConsumedCode(clientAuth *ClientAuth) error {
+	if clientAuth.FamilyID != "" {
+		if err := provider.revokeTokenFamily(clientAuth.FamilyID); err != nil {
+			return InternalServerError("Failed to revoke the tokens issued for the authorization code", err)
+		}
 	}
+	return OAuth2ErrorInvalidGrant("authorization code has already been used")
+}
 
-	if err = CompareRedirectURI(client.Website, redirectURI); err != nil {
-		return "", "", 0, OAuth2ErrorInvalidRedirectURI(err)
+// revokeTokenFamily deletes the refresh tokens of the family, if the TokenService is a RotatingTokenService, and
+// records the FamilyID in the RevocationStore, which revokes the access tokens issued for the family.
+func (provider *AuthProvider) revokeTokenFamily(familyID string) error {
+	if tokenService, ok := provider.TokenService.(RotatingTokenService); ok {
+		if err := tokenService.DeleteTokenFamily(familyID); err != nil {
+			return err
+		}
+	}
+	if provider.RevocationStore != nil {
+		expiresAt := time.Now().Add(time.Duration(provider.AccessTokenValidityPeriod) * time.Millisecond)
+		return provider.RevocationStore.Revoke(familyID, expiresAt)
 	}
+	return nil
+}
 
+// parseUserData parses the serialized user data into the claims of the access token.
+func parseUserData(serializedUserData string) (map[string]interface{}, error) {
 	userData := map[string]interface{}{}
 
-	if err = json.Unmarshal([]byte(clientAuth.UserData), &userData); err != nil {
-		return "", "", 0, InternalServerError("Failed to read user data", err)
+	if err := json.Unmarshal([]byte(serializedUserData), &userData); err != nil {
+		return nil, err
 	}
 
 	if roles, ok := userData["roles"]; ok && roles != nil {
@@ -234,18 +560,7 @@ func (provider *AuthProvider) Exchange(clientID, code, redirectURI string) (refr
 			userData["namespaces"] = strings.Join(toArr(nsArr), ",")
 		}
 	}
-
-	oauth2Token, err := provider.generateOAuthToken(clientID, clientAuth.Scope, userData)
-	if err != nil {
-		return "", "", 0, InternalServerError("Failed to generate access token and refresh token", err)
-	}
-
-	err = provider.ClientService.DeleteClientAuth(clientID, code)
-	if err != nil {
-		return "", "", 0, InternalServerError(err)
-	}
-
-	return oauth2Token.RefreshToken, oauth2Token.AccessToken, oauth2Token.ValidFor, nil
+	return userData, nil
 }
 
 func toArr(genericArr []interface{}) []string {
@@ -262,7 +577,14 @@ func toArr(genericArr []interface{}) []string {
 
 // generateAccessToken generates new access token as JWT token with encoded user data and standard JWT claims.
 // The generated access token is self contained - holds all data needed to authenticate and authorize the user by APIs.
+// The token header carries the "kid" of the signing key, as published by jwt.NewJWKSHandler.
 func (provider *AuthProvider) generateAccessToken(userData map[string]interface{}, clientID, scope string) (string, error) {
+	expiresAt := time.Now().Add(time.Duration(provider.AccessTokenValidityPeriod) * time.Millisecond)
+	return provider.generateAccessTokenUntil(userData, clientID, scope, expiresAt)
+}
+
+// generateAccessTokenUntil generates an access token that expires at expiresAt.
+func (provider *AuthProvider) generateAccessTokenUntil(userData map[string]interface{}, clientID, scope string, expiresAt time.Time) (string, error) {
 	key, err := provider.KeyStore.GetPrivateKey()
 	if err != nil {
 		return "", err
@@ -274,19 +596,37 @@ func (provider *AuthProvider) generateAccessToken(userData map[string]interface{
 	}
 	userData["jti"] = randUUID.String()
 	userData["iss"] = provider.ProviderName
-	userData["exp"] = time.Now().Add(time.Duration(provider.AccessTokenValidityPeriod) * time.Millisecond).Unix()
+	userData["exp"] = expiresAt.Unix()
 	userData["iat"] = time.Now().Unix()
 
 	userData["nbf"] = 0
 	userData["sub"] = clientID
 	userData["scopes"] = scope
 
-	token, err := jwt.SignToken(userData, provider.SigningMethod, key)
+	token, err := jwt.SignTokenWithType(userData, provider.SigningMethod, key, AccessTokenJWTType)
 	return token, err
 }
 
-func (provider *AuthProvider) generateOAuthToken(clientID, scope string, userData map[string]interface{}) (*AuthToken, error) {
-	accessToken, err := provider.generateAccessToken(userData, clientID, scope)
+// generateOAuthToken generates new access token and refresh token. The refresh token belongs to the given
+// token family; if familyID is empty, a new family is started.
+func (provider *AuthProvider) generateOAuthToken(clientID, scope string, userData map[string]interface{}, familyID string) (*AuthToken, error) {
+	return provider.generateScopedOAuthToken(clientID, scope, scope, userData, familyID)
+}
+
+// generateScopedOAuthToken generates new access token with the accessScope and refresh token for the granted scope.
+// The FamilyID is set in the revocation.DerivedFromClaim of the access token, so all access tokens of the family
+// are revoked by revoking the FamilyID (see revokeTokenFamily).
+func (provider *AuthProvider) generateScopedOAuthToken(clientID, scope, accessScope string, userData map[string]interface{}, familyID string) (*AuthToken, error) {
+	if familyID == "" {
+		familyUUID, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		familyID = familyUUID.String()
+	}
+	userData[revocation.DerivedFromClaim] = []string{familyID}
+
+	accessToken, err := provider.generateAccessToken(userData, clientID, accessScope)
 	if err != nil {
 		return nil, err
 	}
@@ -303,6 +643,7 @@ func (provider *AuthProvider) generateOAuthToken(clientID, scope string, userDat
 		RefreshToken: refreshToken,
 		Scope:        scope,
 		ValidFor:     provider.AccessTokenValidityPeriod,
+		FamilyID:     familyID,
 	}
 
 	err = provider.TokenService.SaveToken(oauth2Token)
@@ -310,6 +651,12 @@ func (provider *AuthProvider) generateOAuthToken(clientID, scope string, userDat
 }
 
 // Refresh exchnages a refresh token for a new access token.
+// The requested scope (space-delimited) may be any subset of the scope originally granted with the refresh token,
+// and defaults to the granted scope when empty. The new refresh token keeps the originally granted scope.
+// The refresh token is rotated: if the TokenService is a RotatingTokenService, the refresh token is marked as used
+// and presenting it again revokes the whole token family with OAuth2ErrorInvalidGrant. If the TokenService is only
+// a RevocableTokenService, the used refresh token is deleted. Other TokenServices cannot rotate the refresh tokens,
+// which is logged as a warning.
 func (provider *AuthProvider) Refresh(refreshToken, scope string) (newRefreshToken, accessToken string, expiresIn int, err error) {
 	oauth2Token, err := provider.TokenService.GetToken(refreshToken)
 	if err != nil {
@@ -319,8 +666,20 @@ func (provider *AuthProvider) Refresh(refreshToken, scope string) (newRefreshTok
 		return "", "", 0, OAuth2AccessDenied("Invalid refresh token")
 	}
 
-	if oauth2Token.Scope != scope {
-		return "", "", 0, OAuth2ErrorInvalidScope("invalid_scope")
+	client, err := provider.ClientService.GetClient(oauth2Token.ClientID)
+	if err != nil {
+		return "", "", 0, InternalServerError("Unable to verify client at this time")
+	}
+	if client == nil {
+		return "", "", 0, OAuth2AccessDenied("client not registered")
+	}
+	if !client.AllowsGrantType("refresh_token") {
+		return "", "", 0, OAuth2ErrorUnauthorizedClient("the client may not use the refresh token grant")
+	}
+
+	accessScope, err := NarrowScope(oauth2Token.Scope, scope)
+	if err != nil {
+		return "", "", 0, err
 	}
 
 	userData := map[string]interface{}{}
@@ -330,7 +689,7 @@ func (provider *AuthProvider) Refresh(refreshToken, scope string) (newRefreshTok
 		return "", "", 0, InternalServerError("The access token is in invalid format")
 	}
 
-	jwtClaims, err := base64.StdEncoding.DecodeString(tokenParts[1])
+	jwtClaims, err := jwtgo.DecodeSegment(tokenParts[1])
 	if err != nil {
 		return "", "", 0, InternalServerError("Failed to decode the access token", err)
 	}
@@ -340,7 +699,11 @@ func (provider *AuthProvider) Refresh(refreshToken, scope string) (newRefreshTok
 		return "", "", 0, InternalServerError("Failed to read the access token", err)
 	}
 
-	oauth2Token, err = provider.generateOAuthToken(oauth2Token.ClientID, oauth2Token.Scope, userData)
+	if err = provider.rotateRefreshToken(oauth2Token); err != nil {
+		return "", "", 0, err
+	}
+
+	oauth2Token, err = provider.generateScopedOAuthToken(oauth2Token.ClientID, oauth2Token.Scope, accessScope, userData, oauth2Token.FamilyID)
 
 	if err != nil {
 		return "", "", 0, InternalServerError("Failed to generate token pair", err)
@@ -349,6 +712,328 @@ func (provider *AuthProvider) Refresh(refreshToken, scope string) (newRefreshTok
 	return oauth2Token.RefreshToken, oauth2Token.AccessToken, oauth2Token.ValidFor, nil
 }
 
+// rotateRefreshToken invalidates the refresh token that is being exchanged. A reused refresh token revokes
+// the whole token family - the refresh tokens and, with a RevocationStore, the access tokens (see revokeTokenFamily).
+func (provider *AuthProvider) rotateRefreshToken(oauth2Token *AuthToken) error {
+	switch tokenService := provider.TokenService.(type) {
+	case RotatingTokenService:
+		firstUse := !oauth2Token.Used
+		if firstUse {
+			var err error
+			if firstUse, err = tokenService.MarkTokenUsed(oauth2Token.RefreshToken); err != nil {
+				return InternalServerError("Failed to rotate the refresh token", err)
+			}
+		}
+		if !firstUse {
+			if oauth2Token.FamilyID != "" {
+				if err := provider.revokeTokenFamily(oauth2Token.FamilyID); err != nil {
+					return InternalServerError("Failed to revoke the token family", err)
+				}
+			}
+			return OAuth2ErrorInvalidGrant("refresh token has already been used")
+		}
+	case RevocableTokenService:
+		if err := tokenService.DeleteToken(oauth2Token.RefreshToken); err != nil {
+			return InternalServerError("Failed to rotate the refresh token", err)
+		}
+	default:
+		rotationWarning.Do(func() {
+			log.Println("WARN: The TokenService supports neither RotatingTokenService nor RevocableTokenService; the refresh tokens are not rotated and can be reused.")
+		})
+	}
+	return nil
+}
+
+// rotationWarning logs the warning about the TokenService without refresh token rotation only once.
+var rotationWarning sync.Once
+
+// Revoke revokes an access token issued by this provider. The token signature is verified with the
+// default key from the KeyStore and the token ID (the "jti" claim) is recorded in the RevocationStore
+// until the token expires.
+func (provider *AuthProvider) Revoke(accessToken string) error {
+	if provider.RevocationStore == nil {
+		return InternalServerError("token revocation is not configured")
+	}
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		return err
+	}
+	return provider.revokeAccessToken(claims)
+}
+
+// RevokeToken revokes a refresh token or an access token issued to the client, as specified by RFC 7009.
+// The tokenTypeHint ("refresh_token" or "access_token") is used to look up the token first by that type.
+// Unknown tokens and tokens issued to other clients are ignored.
+// When a refresh token is revoked, the access token issued with it is revoked as well. Revocation of
+// refresh tokens requires a RevocableTokenService and revocation of access tokens requires a RevocationStore,
+// otherwise OAuth2ErrorUnsupportedTokenType is returned.
+func (provider *AuthProvider) RevokeToken(clientID, token, tokenTypeHint string) error {
+	if tokenTypeHint == "access_token" {
+		revoked, err := provider.revokeClientAccessToken(clientID, token)
+		if revoked || err != nil {
+			return err
+		}
+		_, err = provider.revokeRefreshToken(clientID, token)
+		return err
+	}
+
+	revoked, err := provider.revokeRefreshToken(clientID, token)
+	if revoked || err != nil {
+		return err
+	}
+	_, err = provider.revokeClientAccessToken(clientID, token)
+	return err
+}
+
+// revokeRefreshToken revokes the refresh token if it was issued to the client.
+// Returns true if the token was found.
+func (provider *AuthProvider) revokeRefreshToken(clientID, refreshToken string) (bool, error) {
+	oauth2Token, err := provider.TokenService.GetToken(refreshToken)
+	if err != nil {
+		return false, InternalServerError("Failed to look up the refresh token", err)
+	}
+	if oauth2Token == nil {
+		return false, nil
+	}
+	if oauth2Token.ClientID != clientID {
+		return true, nil
+	}
+	tokenService, ok := provider.TokenService.(RevocableTokenService)
+	if !ok {
+		return true, OAuth2ErrorUnsupportedTokenType("revocation of refresh tokens is not supported")
+	}
+	if err = tokenService.DeleteToken(refreshToken); err != nil {
+		return true, InternalServerError("Failed to revoke the refresh token", err)
+	}
+	if provider.RevocationStore != nil && oauth2Token.AccessToken != "" {
+		if claims, err := provider.parseAccessToken(oauth2Token.AccessToken); err == nil {
+			if err = provider.revokeAccessToken(claims); err != nil {
+				return true, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// revokeClientAccessToken revokes the access token if it is valid and was issued to the client.
+// Returns true if the token is a valid access token.
+func (provider *AuthProvider) revokeClientAccessToken(clientID, accessToken string) (bool, error) {
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		return false, nil
+	}
+	if claims["sub"] != clientID {
+		return true, nil
+	}
+	if provider.RevocationStore == nil {
+		return true, OAuth2ErrorUnsupportedTokenType("revocation of access tokens is not supported")
+	}
+	return true, provider.revokeAccessToken(claims)
+}
+
+// parseAccessToken verifies the signature of an access token issued by this provider and returns its claims.
+// The token is verified with the key of the KeyStore selected by the "kid" header (see verificationKey).
+// Tokens without the AccessTokenJWTType "typ" header, such as the ID tokens, are rejected.
+func (provider *AuthProvider) parseAccessToken(accessToken string) (jwtgo.MapClaims, error) {
+	defaultKey, err := provider.KeyStore.GetPrivateKey()
+	if err != nil {
+		return nil, InternalServerError("Failed to load the signing key", err)
+	}
+
+	parser := &jwtgo.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(accessToken, func(token *jwtgo.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, AccessTokenJWTType) {
+			return nil, fmt.Errorf("unexpected token type %q", typ)
+		}
+		kid, _ := token.Header["kid"].(string)
+		return provider.verificationKey(defaultKey, kid)
+	})
+	if err != nil {
+		return nil, OAuth2ErrorInvalidRequest("invalid access token", err)
+	}
+	return token.Claims.(jwtgo.MapClaims), nil
+}
+
+// verificationKey returns the public key with the key ID kid (see jwt.KeyID). The key is looked up among all keys
+// of the KeyStore, if it implements tools.KeyNameLister, so the tokens signed with the previous key are still
+// verified after a key rotation (see tools.ReloadingKeyStore). Tokens without "kid" are verified with the default key.
+func (provider *AuthProvider) verificationKey(defaultKey interface{}, kid string) (interface{}, error) {
+	if kid == "" {
+		return jwt.PublicKey(defaultKey), nil
+	}
+	keys := []interface{}{defaultKey}
+	if lister, ok := provider.KeyStore.(tools.KeyNameLister); ok {
+		for _, keyName := range lister.KeyNames() {
+			if key, err := provider.KeyStore.GetPrivateKeyByName(keyName); err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		if keyID, err := jwt.KeyID(key); err == nil && keyID == kid {
+			return jwt.PublicKey(key), nil
+		}
+	}
+	return nil, fmt.Errorf("no key with ID %s", kid)
+}
+
+// revokeAccessToken records the token ID of the access token in the RevocationStore.
+func (provider *AuthProvider) revokeAccessToken(claims jwtgo.MapClaims) error {
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return OAuth2ErrorInvalidRequest("the access token has no token ID")
+	}
+
+	var expiresAt time.Time
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	if err := provider.RevocationStore.Revoke(jti, expiresAt); err != nil {
+		return InternalServerError("Failed to revoke the access token", err)
+	}
+	return nil
+}
+
+// Introspect returns the state and metadata of a token issued by this provider, as specified by RFC 7662.
+// The tokenTypeHint ("access_token" or "refresh_token") is used to look up the token first by that type.
+// For an active access token, the response holds "active", "scope", "client_id", "sub", "exp", "iat",
+// "token_type" and all user claims of the token. Expired, revoked and unknown tokens are reported only
+// as {"active": false}.
+func (provider *AuthProvider) Introspect(token, tokenTypeHint string) (map[string]interface{}, error) {
+	lookups := []func(string) (map[string]interface{}, error){provider.introspectAccessToken, provider.introspectRefreshToken}
+	if tokenTypeHint == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		response, err := lookup(token)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+	return map[string]interface{}{"active": false}, nil
+}
+
+// introspectAccessToken returns the introspection response for an access token, or nil
+// if the token is not an access token issued by this provider.
+func (provider *AuthProvider) introspectAccessToken(accessToken string) (map[string]interface{}, error) {
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		return nil, nil
+	}
+	inactive := map[string]interface{}{"active": false}
+
+	if err = claims.Valid(); err != nil {
+		return inactive, nil
+	}
+	if provider.RevocationStore != nil {
+		revoked, err := revocation.IsTokenRevoked(provider.RevocationStore, claims)
+		if err != nil {
+			return nil, InternalServerError("Failed to check the token revocation", err)
+		}
+		if revoked {
+			return inactive, nil
+		}
+	}
+
+	response := map[string]interface{}{}
+	for claim, value := range claims {
+		response[claim] = value
+	}
+	response["active"] = true
+	response["token_type"] = "Bearer"
+	response["client_id"] = claims["sub"]
+	if scopes, ok := claims["scopes"].(string); ok {
+		response["scope"] = scopes
+	}
+	if userID, ok := claims["userId"].(string); ok {
+		response["sub"] = userID
+	}
+	return response, nil
+}
+
+// introspectRefreshToken returns the introspection response for a refresh token, or nil
+// if the refresh token is not found. Used (rotated) refresh tokens are inactive.
+func (provider *AuthProvider) introspectRefreshToken(refreshToken string) (map[string]interface{}, error) {
+	oauth2Token, err := provider.TokenService.GetToken(refreshToken)
+	if err != nil {
+		return nil, InternalServerError("Failed to look up the refresh token", err)
+	}
+	if oauth2Token == nil {
+		return nil, nil
+	}
+	if oauth2Token.Used {
+		return map[string]interface{}{"active": false}, nil
+	}
+	response := map[string]interface{}{
+		"active":     true,
+		"token_type": "refresh_token",
+		"scope":      oauth2Token.Scope,
+		"client_id":  oauth2Token.ClientID,
+		"iat":        oauth2Token.IssuedAt,
+	}
+	if oauth2Token.UserID != "" {
+		response["sub"] = oauth2Token.UserID
+	}
+	return response, nil
+}
+
+// ClientCredentials performs the client credentials grant (RFC 6749 section 4.4) and issues an access token
+// for the client itself, for machine-to-machine calls. The requested scope (space-separated) must be a subset of
+// the scopes registered on the Client; if no scope is requested, all registered scopes are granted.
+// The access token subject is the client: the token has the "client_id" claim and no user claims ("userId",
+// "username"), so the Auth created by the security middlewares is a service identity (see auth.Auth.IsService).
+// The "roles" claim is auth.ServiceRole. No refresh token is issued.
+func (provider *AuthProvider) ClientCredentials(clientID, clientSecret, scope string) (accessToken string, expiresIn int, err error) {
+	client, err := provider.ClientService.VerifyClientCredentials(clientID, clientSecret)
+	if err != nil {
+		return "", 0, InternalServerError(err)
+	}
+	if client == nil {
+		return "", 0, OAuth2ErrorInvalidClient("invalid client credentials")
+	}
+
+	if !client.AllowsGrantType("client_credentials") {
+		return "", 0, OAuth2ErrorUnauthorizedClient("the client may not use the client credentials grant")
+	}
+
+	grantedScope, err := grantClientScope(client, scope)
+	if err != nil {
+		return "", 0, err
+	}
+
+	accessToken, err = provider.generateAccessToken(map[string]interface{}{
+		"roles":     auth.ServiceRole,
+		"client_id": client.ClientID,
+	}, client.ClientID, grantedScope)
+	if err != nil {
+		return "", 0, InternalServerError("Failed to generate access token", err)
+	}
+	return accessToken, provider.AccessTokenValidityPeriod, nil
+}
+
+// AllowsGrantType checks if the client may use the grant type. Clients without GrantTypes may use all grant types.
+func (client *Client) AllowsGrantType(grantType string) bool {
+	return len(client.GrantTypes) == 0 || containsString(client.GrantTypes, grantType)
+}
+
+// IsConfidential checks if the client has a secret, i.e. if it is a confidential client that must authenticate.
+func (client *Client) IsConfidential() bool {
+	return client.SecretHash != "" || client.Secret != ""
+}
+
+// grantClientScope checks the requested scope against the scopes registered on the client.
+func grantClientScope(client *Client, scope string) (string, error) {
+	requested := ParseScope(scope)
+	if len(requested) == 0 {
+		return strings.Join(client.Scopes, " "), nil
+	}
+	if s := missingScope(client.Scopes, requested); s != "" {
+		return "", OAuth2ErrorInvalidScope(fmt.Sprintf("scope %s is not allowed for the client", s))
+	}
+	return strings.Join(requested, " "), nil
+}
+
 // Authenticate checks the client credentials.
 func (provider *AuthProvider) Authenticate(clientID, clientSecret string) error {
 	client, err := provider.ClientService.VerifyClientCredentials(clientID, clientSecret)
@@ -374,6 +1059,8 @@ func GenerateRandomCode(n int) (string, error) {
 }
 
 // CompareRedirectURI compares the registered redirect URI with a provided one.
+// Only the scheme and the host (with port) are compared. Used for clients with LegacyRedirectURIMatching,
+// see Client.ValidateRedirectURI.
 func CompareRedirectURI(registered, provided string) error {
 	// compare scheme + host + port
 	var registeredURL *url.URL
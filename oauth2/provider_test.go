@@ -1,12 +1,23 @@
 package oauth2
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/Microkubes/microservice-security/revocation"
+	"github.com/Microkubes/microservice-security/tools"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goaJwt "github.com/keitaroinc/goa/middleware/security/jwt"
 )
 
 type DummyClientService struct {
@@ -86,6 +97,21 @@ func (d *DummyClientService) DeleteClientAuth(clientID, code string) error {
 	return nil
 }
 
+// SingleUseDummyClientService keeps the consumed ClientAuth, to detect reuse of the authorization codes.
+type SingleUseDummyClientService struct {
+	*DummyClientService
+}
+
+func (d *SingleUseDummyClientService) ConsumeClientAuth(clientID, code, familyID string) (bool, error) {
+	ca, _ := d.GetClientAuth(clientID, code)
+	if ca == nil || ca.Consumed {
+		return false, nil
+	}
+	ca.Consumed = true
+	ca.FamilyID = familyID
+	return true, nil
+}
+
 func NewMockClientService() *DummyClientService {
 	return &DummyClientService{
 		Clients: map[string]*Client{},
@@ -113,6 +139,11 @@ func (d *DummyTokenService) GetTokenForClient(userID, clientID string) (*AuthTok
 	return nil, nil
 }
 
+func (d *DummyTokenService) DeleteToken(refreshToken string) error {
+	delete(d.Tokens, refreshToken)
+	return nil
+}
+
 type DummyKeyStore struct {
 	PrivateKey interface{}
 }
@@ -293,6 +324,51 @@ func TestExchange(t *testing.T) {
 	}
 }
 
+func TestRestrictedGrantTypes(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.DeviceAuthorizationService = NewMemoryDeviceAuthorizationService()
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+	clientSvcMock.Auths["001-authcode"] = &ClientAuth{
+		ClientID:    "001",
+		Code:        "authcode",
+		Confirmed:   true,
+		GeneratedAt: time.Now().Unix(),
+		RedirectURI: "http://example.com:8080",
+		Scope:       "api:read",
+		UserData:    "{\"userId\":\"10001\"}",
+		UserID:      "10001",
+	}
+	token, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceAuth, err := provider.DeviceAuthorize("001", "xyz", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.ApproveDeviceAuthorization(deviceAuth.UserCode, "10001", "{\"userId\":\"10001\"}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client is restricted to the client credentials grant
+	clientSvcMock.Clients["001"].GrantTypes = []string{"client_credentials"}
+
+	_, err = provider.Authorize("001", "api:read", "http://example.com:8080")
+	expectOAuth2Error(t, err, "unauthorized_client")
+	_, _, _, err = provider.Exchange("001", "authcode", "http://example.com:8080")
+	expectOAuth2Error(t, err, "unauthorized_client")
+	_, _, _, err = provider.Refresh(token.RefreshToken, "")
+	expectOAuth2Error(t, err, "unauthorized_client")
+	_, err = provider.DeviceAuthorize("001", "xyz", "api:read")
+	expectOAuth2Error(t, err, "unauthorized_client")
+	_, _, _, err = provider.ExchangeDeviceCode("001", "xyz", deviceAuth.DeviceCode)
+	expectOAuth2Error(t, err, "unauthorized_client")
+
+	if stored, _ := provider.TokenService.GetToken(token.RefreshToken); stored == nil {
+		t.Fatal("Expected the refresh token not to be consumed by the rejected refresh")
+	}
+}
+
 func TestRefresh(t *testing.T) {
 	provider := getMockedProvider(t)
 
@@ -320,3 +396,428 @@ func TestRefresh(t *testing.T) {
 		t.Fatal("Token should be valid for more than 0ms")
 	}
 }
+
+func TestGenerateAccessTokenKeyID(t *testing.T) {
+	provider := getMockedProvider(t)
+
+	accessToken, err := provider.generateAccessToken(map[string]interface{}{"userId": "10001"}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := jwt.JWKSFromKeyStore(provider.KeyStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := new(jwtgo.Parser).ParseUnverified(accessToken, jwtgo.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != keySet.Keys[0].Kid {
+		t.Fatal("Expected the access token kid to match the published key")
+	}
+}
+
+func TestIntrospectAfterKeyRotation(t *testing.T) {
+	provider := getMockedProvider(t)
+	previousKey, _ := provider.KeyStore.GetPrivateKey()
+	accessToken, err := provider.generateAccessToken(map[string]interface{}{"userId": "10001"}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.KeyStore = &tools.FileKeyStore{
+		PrivateKey: newKey,
+		KeysMap:    map[string]interface{}{"default": newKey, "default.previous": previousKey},
+	}
+	if response, err := provider.Introspect(accessToken, ""); err != nil || response["active"] != true {
+		t.Fatal("Expected the token signed with the previous key to be active", response, err)
+	}
+
+	provider.KeyStore = &DummyKeyStore{PrivateKey: newKey}
+	if response, _ := provider.Introspect(accessToken, ""); response["active"] == true {
+		t.Fatal("Expected the token signed with a removed key to be rejected", response)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	provider := getMockedProvider(t)
+
+	accessToken, err := provider.generateAccessToken(map[string]interface{}{"userId": "10001"}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = provider.Revoke(accessToken); err == nil {
+		t.Fatal("Expected an error when the RevocationStore is not set")
+	}
+
+	store := revocation.NewMemoryRevocationStore()
+	provider.RevocationStore = store
+
+	if err = provider.Revoke("not-a-token"); err == nil {
+		t.Fatal("Expected an error for an invalid token")
+	}
+
+	if err = provider.Revoke(accessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := new(jwtgo.Parser).ParseUnverified(accessToken, jwtgo.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := store.IsRevoked(token.Claims.(jwtgo.MapClaims)["jti"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("Expected the access token to be revoked")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	provider := getMockedProvider(t)
+	store := revocation.NewMemoryRevocationStore()
+	provider.RevocationStore = store
+	tokenService := provider.TokenService.(*DummyTokenService)
+
+	oauth2Token, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessTokenID := func(accessToken string) string {
+		token, _, err := new(jwtgo.Parser).ParseUnverified(accessToken, jwtgo.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Claims.(jwtgo.MapClaims)["jti"].(string)
+	}
+
+	// tokens of other clients and unknown tokens are ignored
+	if err = provider.RevokeToken("002", oauth2Token.RefreshToken, "refresh_token"); err != nil {
+		t.Fatal(err)
+	}
+	if tokenService.Tokens[oauth2Token.RefreshToken] == nil {
+		t.Fatal("Expected the refresh token of other client not to be revoked")
+	}
+	if err = provider.RevokeToken("001", "unknown-token", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoking the refresh token revokes the access token too
+	if err = provider.RevokeToken("001", oauth2Token.RefreshToken, "access_token"); err != nil {
+		t.Fatal(err)
+	}
+	if tokenService.Tokens[oauth2Token.RefreshToken] != nil {
+		t.Fatal("Expected the refresh token to be revoked")
+	}
+	if revoked, _ := store.IsRevoked(accessTokenID(oauth2Token.AccessToken)); !revoked {
+		t.Fatal("Expected the access token to be revoked with the refresh token")
+	}
+
+	accessToken, err := provider.generateAccessToken(map[string]interface{}{"userId": "10001"}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.RevokeToken("001", accessToken, "refresh_token"); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := store.IsRevoked(accessTokenID(accessToken)); !revoked {
+		t.Fatal("Expected the access token to be revoked")
+	}
+
+	provider.RevocationStore = nil
+	err = provider.RevokeToken("001", accessToken, "access_token")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "unsupported_token_type" {
+		t.Fatal("Expected unsupported_token_type error, got", err)
+	}
+}
+
+func TestExchangeWithPKCE(t *testing.T) {
+	provider := getMockedProvider(t)
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	confirm := func(code string) {
+		ca := clientSvcMock.Auths["001-"+code]
+		ca.UserData = "{\"userId\":\"10001\"}"
+		ca.UserID = "10001"
+		ca.Confirmed = true
+	}
+
+	tests := []struct {
+		name         string
+		challenge    string
+		method       string
+		verifier     string
+		exchangeCode string
+	}{
+		{"S256", CodeChallengeS256(codeVerifier), CodeChallengeMethodS256, codeVerifier, ""},
+		{"plain", codeVerifier, "", codeVerifier, ""},
+		{"wrong verifier", CodeChallengeS256(codeVerifier), CodeChallengeMethodS256, "x" + codeVerifier[1:], "invalid_grant"},
+		{"missing verifier", CodeChallengeS256(codeVerifier), CodeChallengeMethodS256, "", "invalid_grant"},
+	}
+	for _, test := range tests {
+		code, err := provider.AuthorizeWithPKCE("001", "api:read", "http://example.com:8080", test.challenge, test.method)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		confirm(code)
+		_, accessToken, _, err := provider.ExchangeWithPKCE("001", code, "http://example.com:8080", test.verifier)
+		if test.exchangeCode == "" {
+			if err != nil || accessToken == "" {
+				t.Fatal(test.name, "expected access token to be generated", err)
+			}
+			continue
+		}
+		if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != test.exchangeCode {
+			t.Fatal(test.name, "expected error", test.exchangeCode, "got", err)
+		}
+	}
+
+	if _, err := provider.AuthorizeWithPKCE("001", "api:read", "http://example.com:8080", "too-short", CodeChallengeMethodS256); err == nil {
+		t.Fatal("Expected invalid code challenge to be rejected")
+	}
+	if _, err := provider.AuthorizeWithPKCE("001", "api:read", "http://example.com:8080", codeVerifier, "S512"); err == nil {
+		t.Fatal("Expected unsupported code challenge method to be rejected")
+	}
+
+	// public clients must use PKCE
+	clientSvcMock.Clients["001"].RequirePKCE = true
+	if _, err := provider.Authorize("001", "api:read", "http://example.com:8080"); err == nil {
+		t.Fatal("Expected the authorization without code challenge to be rejected")
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	provider := getMockedProvider(t)
+	client := provider.ClientService.(*DummyClientService).Clients["001"]
+	client.Scopes = []string{"api:read", "api:write"}
+
+	if _, _, err := provider.ClientCredentials("001", "wrong", ""); err == nil {
+		t.Fatal("Expected invalid client credentials to be rejected")
+	}
+	_, _, err := provider.ClientCredentials("001", "xyz", "api:read api:admin")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_scope" {
+		t.Fatal("Expected invalid_scope error, got", err)
+	}
+
+	accessToken, expiresIn, err := provider.ClientCredentials("001", "xyz", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresIn != provider.AccessTokenValidityPeriod {
+		t.Fatal("Unexpected token validity period", expiresIn)
+	}
+
+	key, _ := provider.KeyStore.GetPrivateKey()
+	resolver := goaJwt.NewSimpleResolver([]goaJwt.Key{jwt.PublicKey(key)})
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var authObj *auth.Auth
+	err = NewOAuth2SecurityMiddleware(resolver, &scheme)(func(c context.Context, w http.ResponseWriter, r *http.Request) error {
+		authObj = auth.GetAuth(c)
+		return nil
+	})(context.Background(), nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj == nil || authObj.ClientID != "001" || authObj.UserID != "" || !authObj.IsService() {
+		t.Fatal("Expected a service identity for the client", authObj)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.TokenService = NewMemoryTokenService()
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+
+	initial, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _, _, err := provider.Refresh(initial.RefreshToken, "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedToken, _ := provider.TokenService.GetToken(rotated)
+	if rotatedToken == nil || rotatedToken.FamilyID != initial.FamilyID {
+		t.Fatal("Expected the new refresh token to be in the same family")
+	}
+
+	latest, latestAccessToken, _, err := provider.Refresh(rotated, "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the used refresh token is not active anymore
+	response, err := provider.Introspect(initial.RefreshToken, "refresh_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response["active"] != false || len(response) != 1 {
+		t.Fatal("Expected the used refresh token to be inactive", response)
+	}
+
+	// the initial refresh token has already been used
+	_, _, _, err = provider.Refresh(initial.RefreshToken, "api:read")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_grant" {
+		t.Fatal("Expected invalid_grant error for reused refresh token, got", err)
+	}
+
+	// the reuse revokes the whole family
+	if _, _, _, err = provider.Refresh(latest, "api:read"); err == nil {
+		t.Fatal("Expected the latest refresh token of the family to be revoked")
+	}
+	for _, accessToken := range []string{initial.AccessToken, latestAccessToken} {
+		if response, _ := provider.Introspect(accessToken, ""); response["active"] != false {
+			t.Fatal("Expected the access tokens of the family to be revoked", response)
+		}
+	}
+}
+
+func TestRefreshDeletesUsedToken(t *testing.T) {
+	provider := getMockedProvider(t)
+
+	initial, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = provider.Refresh(initial.RefreshToken, "api:read"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = provider.Refresh(initial.RefreshToken, "api:read"); err == nil {
+		t.Fatal("Expected the used refresh token to be deleted")
+	}
+}
+
+func TestRefreshDownScoping(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.TokenService = NewMemoryTokenService()
+
+	initial, err := provider.generateOAuthToken("001", "api:read api:write", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, accessToken, _, err := provider.Refresh(initial.RefreshToken, "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["scopes"] != "api:read" {
+		t.Fatal("Expected the access token to be down-scoped, got", claims["scopes"])
+	}
+	token, _ := provider.TokenService.GetToken(refreshToken)
+	if token == nil || token.Scope != "api:read api:write" {
+		t.Fatal("Expected the refresh token to keep the originally granted scope", token)
+	}
+
+	// empty scope defaults to the originally granted scope
+	refreshToken, accessToken, _, err = provider.Refresh(refreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ = provider.parseAccessToken(accessToken); claims["scopes"] != "api:read api:write" {
+		t.Fatal("Expected the originally granted scope, got", claims["scopes"])
+	}
+
+	_, _, _, err = provider.Refresh(refreshToken, "api:read api:admin")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_scope" {
+		t.Fatal("Expected invalid_scope error, got", err)
+	}
+}
+
+func TestExchangeExpiredCode(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.AuthCodeValidityPeriod = 60 * 1000
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+	clientSvcMock.Auths["001-authcode"] = &ClientAuth{
+		ClientID:    "001",
+		Code:        "authcode",
+		Confirmed:   true,
+		GeneratedAt: time.Now().Add(-2 * time.Minute).Unix(),
+		Scope:       "api:read",
+		UserData:    "{\"userId\":\"10001\"}",
+		UserID:      "10001",
+	}
+	_, _, _, err := provider.Exchange("001", "authcode", "http://example.com:8080")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_grant" {
+		t.Fatal("Expected invalid_grant error for expired code, got", err)
+	}
+}
+
+func TestExchangeCodeReuse(t *testing.T) {
+	provider := getMockedProvider(t)
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+	provider.ClientService = &SingleUseDummyClientService{clientSvcMock}
+	provider.TokenService = NewMemoryTokenService()
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+	clientSvcMock.Auths["001-authcode"] = &ClientAuth{
+		ClientID:    "001",
+		Code:        "authcode",
+		Confirmed:   true,
+		GeneratedAt: time.Now().Unix(),
+		Scope:       "api:read",
+		UserData:    "{\"userId\":\"10001\"}",
+		UserID:      "10001",
+	}
+
+	refreshToken, accessToken, _, err := provider.Exchange("001", "authcode", "http://example.com:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, refreshedAccessToken, _, err := provider.Refresh(refreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = provider.Exchange("001", "authcode", "http://example.com:8080")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_grant" {
+		t.Fatal("Expected invalid_grant error for reused code, got", err)
+	}
+
+	// the tokens issued for the code are revoked
+	if token, _ := provider.TokenService.GetToken(refreshToken); token != nil {
+		t.Fatal("Expected the refresh tokens issued for the code to be revoked")
+	}
+	for _, token := range []string{accessToken, refreshedAccessToken} {
+		if response, _ := provider.Introspect(token, ""); response["active"] != false {
+			t.Fatal("Expected the access tokens issued for the code to be revoked", response)
+		}
+	}
+}
+
+func TestAuthorizeRedirectURI(t *testing.T) {
+	provider := getMockedProvider(t)
+	clientSvcMock := provider.ClientService.(*DummyClientService)
+	clientSvcMock.Clients["001"].RedirectURIs = []string{"http://example.com:8080/callback"}
+
+	_, err := provider.Authorize("001", "api:read", "http://example.com:8080/other")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_request" {
+		t.Fatal("Expected invalid_request error for unregistered redirect URI, got", err)
+	}
+
+	code, err := provider.Authorize("001", "api:read", "http://example.com:8080/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSvcMock.UpdateUserData("001", code, "10001", "{\"userId\":\"10001\"}")
+
+	// the redirect URI must be the same as in the authorization request
+	_, _, _, err = provider.Exchange("001", code, "")
+	if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_grant" {
+		t.Fatal("Expected invalid_grant error for different redirect URI, got", err)
+	}
+}
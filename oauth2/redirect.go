@@ -0,0 +1,74 @@
+package oauth2
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// ValidateRedirectURI checks the redirect URI sent by the client against the registered redirect URIs.
+// The redirect URI must exactly match one of the RedirectURIs of the client (or the Website, if the client
+// has no RedirectURIs and is not dynamically registered - the Website of a dynamically registered client is its
+// unvalidated "client_uri"). If the client has a single registered redirect URI, the redirect URI may be omitted.
+// With AllowLoopbackPort, the port of a loopback redirect URI may differ from the registered one
+// (RFC 8252 section 7.3). With LegacyRedirectURIMatching, only the scheme and the host of the redirect URI
+// are compared with the Website (see CompareRedirectURI).
+func (client *Client) ValidateRedirectURI(redirectURI string) error {
+	if client.LegacyRedirectURIMatching {
+		return CompareRedirectURI(client.Website, redirectURI)
+	}
+
+	registered := client.RedirectURIs
+	if len(registered) == 0 && client.Website != "" && client.RegistrationAccessTokenHash == "" {
+		registered = []string{client.Website}
+	}
+	if len(registered) == 0 {
+		return fmt.Errorf("the client has no registered redirect URIs")
+	}
+	if redirectURI == "" {
+		if len(registered) == 1 {
+			return nil
+		}
+		return fmt.Errorf("redirect URI is required when the client has multiple registered redirect URIs")
+	}
+
+	for _, registeredURI := range registered {
+		if registeredURI == redirectURI {
+			return nil
+		}
+		if client.AllowLoopbackPort && matchLoopbackRedirectURI(registeredURI, redirectURI) {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI %s is not registered for the client", redirectURI)
+}
+
+// matchLoopbackRedirectURI compares two loopback redirect URIs, ignoring the port (RFC 8252 section 7.3).
+func matchLoopbackRedirectURI(registered, provided string) bool {
+	registeredURL, err := url.Parse(registered)
+	if err != nil || !isLoopbackRedirectURL(registeredURL) {
+		return false
+	}
+	providedURL, err := url.Parse(provided)
+	if err != nil || !isLoopbackRedirectURL(providedURL) {
+		return false
+	}
+	return registeredURL.Scheme == providedURL.Scheme &&
+		registeredURL.Hostname() == providedURL.Hostname() &&
+		registeredURL.EscapedPath() == providedURL.EscapedPath() &&
+		registeredURL.RawQuery == providedURL.RawQuery &&
+		providedURL.User == nil && providedURL.Fragment == ""
+}
+
+// isLoopbackRedirectURL checks if the URL is a "http" URL with a loopback IP address or "localhost" as host.
+func isLoopbackRedirectURL(redirectURL *url.URL) bool {
+	if redirectURL.Scheme != "http" {
+		return false
+	}
+	host := redirectURL.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,38 @@
+package oauth2
+
+import "testing"
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		name        string
+		client      *Client
+		redirectURI string
+		valid       bool
+	}{
+		{"exact match", &Client{RedirectURIs: []string{"https://app.example.com/cb", "https://app.example.com/cb2"}}, "https://app.example.com/cb2", true},
+		{"different path", &Client{RedirectURIs: []string{"https://app.example.com/cb"}}, "https://app.example.com/evil", false},
+		{"extra query", &Client{RedirectURIs: []string{"https://app.example.com/cb"}}, "https://app.example.com/cb?next=evil", false},
+		{"single registered, omitted", &Client{RedirectURIs: []string{"https://app.example.com/cb"}}, "", true},
+		{"multiple registered, omitted", &Client{RedirectURIs: []string{"https://app.example.com/cb", "https://app.example.com/cb2"}}, "", false},
+		{"website as redirect URI", &Client{Website: "https://app.example.com/cb"}, "https://app.example.com/cb", true},
+		{"website of registered client", &Client{Website: "https://evil.example.com/cb", RegistrationAccessTokenHash: "hash"}, "https://evil.example.com/cb", false},
+		{"none registered", &Client{}, "https://app.example.com/cb", false},
+		{"loopback port not allowed", &Client{RedirectURIs: []string{"http://127.0.0.1/cb"}}, "http://127.0.0.1:51004/cb", false},
+		{"loopback port", &Client{RedirectURIs: []string{"http://127.0.0.1/cb"}, AllowLoopbackPort: true}, "http://127.0.0.1:51004/cb", true},
+		{"loopback IPv6 port", &Client{RedirectURIs: []string{"http://[::1]/cb"}, AllowLoopbackPort: true}, "http://[::1]:8000/cb", true},
+		{"loopback different path", &Client{RedirectURIs: []string{"http://127.0.0.1/cb"}, AllowLoopbackPort: true}, "http://127.0.0.1:51004/other", false},
+		{"loopback different host", &Client{RedirectURIs: []string{"http://127.0.0.1/cb"}, AllowLoopbackPort: true}, "http://localhost:51004/cb", false},
+		{"non-loopback port", &Client{RedirectURIs: []string{"http://example.com/cb"}, AllowLoopbackPort: true}, "http://example.com:8080/cb", false},
+		{"legacy host match", &Client{Website: "https://app.example.com", LegacyRedirectURIMatching: true}, "https://app.example.com/any/path", true},
+		{"legacy different host", &Client{Website: "https://app.example.com", LegacyRedirectURIMatching: true}, "https://evil.example.com/cb", false},
+	}
+	for _, test := range tests {
+		err := test.client.ValidateRedirectURI(test.redirectURI)
+		if test.valid && err != nil {
+			t.Fatalf("%s: expected the redirect URI to be valid, got %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: expected the redirect URI to be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/keitaroinc/goa"
+)
+
+// RevocationPath is the conventional path of the token revocation endpoint.
+const RevocationPath = "/oauth2/revoke"
+
+// NewRevocationHandler creates an http.Handler for the OAuth2 token revocation endpoint, as specified
+// by RFC 7009. The client is authenticated with AuthProvider.Authenticate, using the HTTP Basic
+// credentials or the "client_id" and "client_secret" form parameters. The "token" form parameter is
+// revoked with AuthProvider.RevokeToken, using the optional "token_type_hint".
+// The endpoint responds with 200 OK for both revoked and unknown tokens.
+func NewRevocationHandler(provider *AuthProvider) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("invalid form data"))
+			return
+		}
+
+		clientID, err := authenticateClient(provider, req)
+		if err != nil {
+			writeOAuth2Error(rw, err)
+			return
+		}
+
+		token := req.PostForm.Get("token")
+		if token == "" {
+			writeOAuth2Error(rw, OAuth2ErrorInvalidRequest("the token parameter is required"))
+			return
+		}
+
+		if err = provider.RevokeToken(clientID, token, req.PostForm.Get("token_type_hint")); err != nil {
+			writeOAuth2Error(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// clientCredentials reads the client credentials from the HTTP Basic authorization header or from
+// the "client_id" and "client_secret" form parameters.
+func clientCredentials(req *http.Request) (clientID, clientSecret string, ok bool) {
+	if clientID, clientSecret, ok = req.BasicAuth(); ok {
+		// RFC 6749 section 2.3.1: the credentials are encoded with application/x-www-form-urlencoded
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if secret, err := url.QueryUnescape(clientSecret); err == nil {
+			clientSecret = secret
+		}
+		return clientID, clientSecret, true
+	}
+	clientID = req.PostForm.Get("client_id")
+	clientSecret = req.PostForm.Get("client_secret")
+	return clientID, clientSecret, clientID != ""
+}
+
+// authenticateClient authenticates the client making the request and returns its ID.
+func authenticateClient(provider *AuthProvider, req *http.Request) (string, error) {
+	clientID, clientSecret, ok := clientCredentials(req)
+	if !ok {
+		return "", OAuth2ErrorInvalidClient("client authentication is required")
+	}
+	if err := provider.Authenticate(clientID, clientSecret); err != nil {
+		return "", OAuth2ErrorInvalidClient("client authentication failed")
+	}
+	return clientID, nil
+}
+
+// writeOAuth2Error writes the error as an OAuth2 error response (RFC 6749 section 5.2).
+func writeOAuth2Error(rw http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	errorResponse := map[string]string{
+		"error": "server_error",
+	}
+	if goaErr, ok := err.(*goa.ErrorResponse); ok {
+		status = goaErr.Status
+		errorResponse["error"] = goaErr.Code
+		if goaErr.Detail != "" {
+			errorResponse["error_description"] = goaErr.Detail
+		}
+	}
+	if status == http.StatusUnauthorized {
+		rw.Header().Set("WWW-Authenticate", "Basic")
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(errorResponse)
+}
@@ -0,0 +1,73 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/revocation"
+)
+
+func revocationRequest(form url.Values, clientID, clientSecret string) *http.Request {
+	req := httptest.NewRequest("POST", "http://example.com/oauth2/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+	return req
+}
+
+func TestRevocationHandler(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+	handler := NewRevocationHandler(provider)
+
+	oauth2Token, err := provider.generateOAuthToken("001", "api:read", map[string]interface{}{"userId": "10001"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		status       int
+		errorCode    string
+		tokenRevoked bool
+	}{
+		{"method not allowed", httptest.NewRequest("GET", "http://example.com/oauth2/revoke", nil), http.StatusMethodNotAllowed, "", false},
+		{"no client credentials", revocationRequest(url.Values{"token": {oauth2Token.RefreshToken}}, "", ""), http.StatusUnauthorized, "invalid_client", false},
+		{"invalid client credentials", revocationRequest(url.Values{"token": {oauth2Token.RefreshToken}}, "001", "wrong"), http.StatusUnauthorized, "invalid_client", false},
+		{"missing token", revocationRequest(url.Values{}, "001", "xyz"), http.StatusBadRequest, "invalid_request", false},
+		{"unknown token", revocationRequest(url.Values{"token": {"unknown"}}, "001", "xyz"), http.StatusOK, "", false},
+		{"revoke with form credentials", revocationRequest(url.Values{
+			"token":           {oauth2Token.RefreshToken},
+			"token_type_hint": {"refresh_token"},
+			"client_id":       {"001"},
+			"client_secret":   {"xyz"},
+		}, "", ""), http.StatusOK, "", true},
+	}
+
+	for _, test := range tests {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, test.req)
+		if rw.Code != test.status {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.status, rw.Code)
+		}
+		if test.errorCode != "" {
+			errorResponse := map[string]string{}
+			if err := json.NewDecoder(rw.Body).Decode(&errorResponse); err != nil {
+				t.Fatal(err)
+			}
+			if errorResponse["error"] != test.errorCode {
+				t.Fatalf("%s: expected error %s, got %s", test.name, test.errorCode, errorResponse["error"])
+			}
+		}
+		_, exists := provider.TokenService.(*DummyTokenService).Tokens[oauth2Token.RefreshToken]
+		if exists == test.tokenRevoked {
+			t.Fatalf("%s: unexpected refresh token state", test.name)
+		}
+	}
+}
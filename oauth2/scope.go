@@ -0,0 +1,68 @@
+package oauth2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseScope parses a space-delimited scope (RFC 6749 section 3.3) into a list of unique scope tokens,
+// in the order they first appear.
+func ParseScope(scope string) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// NarrowScope checks the requested scope against the granted scope and returns the scope for the new token.
+// The requested scope may be any subset of the granted scope, in any order. If no scope is requested, the
+// granted scope is returned. OAuth2ErrorInvalidScope is returned if a scope that was not granted is requested.
+func NarrowScope(granted, requested string) (string, error) {
+	requestedScopes := ParseScope(requested)
+	if len(requestedScopes) == 0 {
+		return granted, nil
+	}
+	if scope := missingScope(ParseScope(granted), requestedScopes); scope != "" {
+		return "", OAuth2ErrorInvalidScope(fmt.Sprintf("scope %s has not been granted", scope))
+	}
+	return strings.Join(requestedScopes, " "), nil
+}
+
+// missingScope returns the first requested scope that is not in the allowed scopes, or an empty string.
+func missingScope(allowed, requested []string) string {
+	allowedScopes := map[string]bool{}
+	for _, s := range allowed {
+		allowedScopes[s] = true
+	}
+	for _, s := range requested {
+		if !allowedScopes[s] {
+			return s
+		}
+	}
+	return ""
+}
+
+// hasScope checks if the space-delimited scope contains the given scope token.
+func hasScope(scope, token string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == token {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString checks if the list contains the value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package oauth2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keitaroinc/goa"
+)
+
+func TestParseScope(t *testing.T) {
+	scopes := ParseScope("  api:read api:write\tapi:read ")
+	if !reflect.DeepEqual(scopes, []string{"api:read", "api:write"}) {
+		t.Fatal("Unexpected scopes", scopes)
+	}
+	if scopes = ParseScope(""); len(scopes) != 0 {
+		t.Fatal("Expected no scopes", scopes)
+	}
+}
+
+func TestNarrowScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		scope     string
+		valid     bool
+	}{
+		{"same scope", "api:read api:write", "api:read api:write", true},
+		{"different order", "api:write api:read", "api:write api:read", true},
+		{"subset", "api:read", "api:read", true},
+		{"empty", "", "api:read api:write", true},
+		{"not granted", "api:read api:admin", "", false},
+	}
+	for _, test := range tests {
+		scope, err := NarrowScope("api:read api:write", test.requested)
+		if !test.valid {
+			if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != "invalid_scope" {
+				t.Fatalf("%s: expected invalid_scope error, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if scope != test.scope {
+			t.Fatalf("%s: expected scope %q, got %q", test.name, test.scope, scope)
+		}
+	}
+}
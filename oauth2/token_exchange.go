@@ -0,0 +1,145 @@
+package oauth2
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Microkubes/microservice-security/revocation"
+)
+
+const (
+	// TokenExchangeGrantType is the grant type of the token exchange (RFC 8693).
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// AccessTokenType is the token type identifier of an OAuth2 access token (RFC 8693 section 3).
+	AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+	// JWTTokenType is the token type identifier of a JWT (RFC 8693 section 3).
+	JWTTokenType = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchangeRequest holds the parameters of a token exchange request (RFC 8693 section 2.1).
+type TokenExchangeRequest struct {
+	// SubjectToken is the access token of the party on behalf of whom the new token is requested.
+	SubjectToken string
+
+	// SubjectTokenType is the type of the subject token - AccessTokenType or JWTTokenType.
+	SubjectTokenType string
+
+	// ActorToken is the optional access token of the party acting on behalf of the subject.
+	ActorToken string
+
+	// ActorTokenType is the type of the actor token - AccessTokenType or JWTTokenType.
+	ActorTokenType string
+
+	// Audience is the target service of the new token, set as its "aud" claim.
+	Audience string
+
+	// Scope is the requested (space-delimited) scope. Must be a subset of the scope of the subject token.
+	Scope string
+}
+
+// tokenExchangeClaims are the claims of the subject token which are not carried over to the exchanged token.
+var tokenExchangeClaims = map[string]bool{
+	"jti": true, "iss": true, "exp": true, "iat": true, "nbf": true, "sub": true, "aud": true, "act": true,
+	"scopes": true, "scope": true, "active": true, "token_type": true, "client_id": true,
+	revocation.DerivedFromClaim: true,
+}
+
+// ExchangeToken performs the token exchange (RFC 8693) for on-behalf-of calls between services.
+// The client must authenticate with its credentials and may exchange tokens only for the audiences listed in
+// its TokenExchangeAudiences.
+// The subject token (and the actor token, if given) must be a valid access token issued by this provider,
+// verified with the keys from the KeyStore and checked against the RevocationStore.
+// The new access token carries the user claims of the subject token, is restricted to the requested audience
+// (the "aud" claim) and to the requested scope, which must be a subset of the subject token scope.
+// It expires with the subject token at the latest, and is revoked together with it: the IDs of the subject
+// token and of the tokens it was derived from are kept in the revocation.DerivedFromClaim.
+// The "act" claim identifies the actor - the user of the actor token, or the client if no actor token is given -
+// with any previous actor of the subject token nested in it.
+// No refresh token is issued.
+func (provider *AuthProvider) ExchangeToken(clientID, clientSecret string, request *TokenExchangeRequest) (accessToken string, expiresIn int, err error) {
+	client, err := provider.ClientService.VerifyClientCredentials(clientID, clientSecret)
+	if err != nil {
+		return "", 0, InternalServerError(err)
+	}
+	if client == nil {
+		return "", 0, OAuth2ErrorInvalidClient("invalid client credentials")
+	}
+	if request.Audience == "" {
+		return "", 0, OAuth2ErrorInvalidRequest("the audience is required")
+	}
+	if !containsString(client.TokenExchangeAudiences, request.Audience) {
+		return "", 0, OAuth2ErrorUnauthorizedClient("the client may not exchange tokens for this audience")
+	}
+
+	subject, err := provider.exchangedTokenClaims(request.SubjectToken, request.SubjectTokenType)
+	if err != nil {
+		return "", 0, err
+	}
+
+	actor := map[string]interface{}{
+		"sub": clientID,
+	}
+	if request.ActorToken != "" {
+		actorClaims, err := provider.exchangedTokenClaims(request.ActorToken, request.ActorTokenType)
+		if err != nil {
+			return "", 0, err
+		}
+		actorSubject, ok := actorClaims["sub"].(string)
+		if !ok || actorSubject == "" {
+			return "", 0, OAuth2ErrorInvalidRequest("the actor token has no subject")
+		}
+		actor["sub"] = actorSubject
+	}
+	if prior, ok := subject["act"]; ok && prior != nil {
+		actor["act"] = prior
+	}
+
+	subjectScope, _ := subject["scope"].(string)
+	scope, err := NarrowScope(subjectScope, request.Scope)
+	if err != nil {
+		return "", 0, err
+	}
+
+	userData := map[string]interface{}{}
+	for claim, value := range subject {
+		if !tokenExchangeClaims[claim] {
+			userData[claim] = value
+		}
+	}
+	userData["aud"] = request.Audience
+	userData["act"] = actor
+	userData[revocation.DerivedFromClaim] = revocation.TokenIDs(subject)
+
+	expiresAt := time.Now().Add(time.Duration(provider.AccessTokenValidityPeriod) * time.Millisecond)
+	if exp, ok := subject["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	accessToken, err = provider.generateAccessTokenUntil(userData, clientID, scope, expiresAt)
+	if err != nil {
+		return "", 0, InternalServerError("Failed to generate access token", err)
+	}
+	return accessToken, int(time.Until(expiresAt) / time.Millisecond), nil
+}
+
+// exchangedTokenClaims validates a subject or actor token and returns its introspected claims.
+func (provider *AuthProvider) exchangedTokenClaims(token, tokenType string) (map[string]interface{}, error) {
+	if token == "" {
+		return nil, OAuth2ErrorInvalidRequest("the subject token is required")
+	}
+	switch tokenType {
+	case "", AccessTokenType, JWTTokenType:
+	default:
+		return nil, OAuth2ErrorInvalidRequest(fmt.Sprintf("unsupported token type %s", tokenType))
+	}
+	claims, err := provider.introspectAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, OAuth2ErrorInvalidRequest("the token is not valid")
+	}
+	return claims, nil
+}
@@ -0,0 +1,222 @@
+package oauth2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-security/revocation"
+)
+
+func TestExchangeToken(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.ClientService.(*DummyClientService).Clients["service-a"] = &Client{
+		ClientID:               "service-a",
+		Secret:                 "abc",
+		TokenExchangeAudiences: []string{"service-b", "service-c"},
+	}
+
+	subjectToken, err := provider.generateAccessToken(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+		"roles":    "user",
+	}, "001", "api:read api:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{SubjectToken: subjectToken})
+	expectOAuth2Error(t, err, "invalid_request")
+
+	_, _, err = provider.ExchangeToken("service-a", "wrong", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-b",
+	})
+	expectOAuth2Error(t, err, "invalid_client")
+
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-x",
+	})
+	expectOAuth2Error(t, err, "unauthorized_client")
+
+	_, _, err = provider.ExchangeToken("001", "xyz", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-b",
+	})
+	expectOAuth2Error(t, err, "unauthorized_client")
+
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-b",
+		Scope:        "api:admin",
+	})
+	expectOAuth2Error(t, err, "invalid_scope")
+
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: subjectToken + "x",
+		Audience:     "service-b",
+	})
+	expectOAuth2Error(t, err, "invalid_request")
+
+	accessToken, expiresIn, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken:     subjectToken,
+		SubjectTokenType: AccessTokenType,
+		Audience:         "service-b",
+		Scope:            "api:read",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresIn <= 0 || expiresIn > provider.AccessTokenValidityPeriod {
+		t.Fatal("Unexpected token validity period", expiresIn)
+	}
+
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["aud"] != "service-b" || claims["scopes"] != "api:read" || claims["sub"] != "service-a" {
+		t.Fatal("Expected the token to be restricted to the audience and the scope", claims)
+	}
+	authObj, err := DefaultClaimMapper().MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.UserID != "10001" || authObj.Username != "user" {
+		t.Fatal("Expected the user claims of the subject token", authObj)
+	}
+	if !authObj.IsDelegated() || authObj.Actor.Subject != "service-a" || authObj.Actor.Actor != nil {
+		t.Fatal("Expected the client to be the actor", authObj.Actor)
+	}
+
+	// Delegate once more, with an explicit actor token.
+	actorToken, _, err := provider.ClientCredentials("001", "xyz", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegatedToken, _, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: accessToken,
+		ActorToken:   actorToken,
+		Audience:     "service-c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = provider.parseAccessToken(delegatedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authObj, err = DefaultClaimMapper().MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj.Actor == nil || authObj.Actor.Subject != "001" {
+		t.Fatal("Expected the actor token subject to be the actor", authObj.Actor)
+	}
+	if authObj.Actor.Actor == nil || authObj.Actor.Actor.Subject != "service-a" {
+		t.Fatal("Expected the previous actor to be nested", authObj.Actor.Actor)
+	}
+}
+
+func TestExchangeTokenExpiry(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.AccessTokenValidityPeriod = 3600 * 1000
+	provider.ClientService.(*DummyClientService).Clients["service-a"] = &Client{
+		ClientID:               "service-a",
+		Secret:                 "abc",
+		TokenExchangeAudiences: []string{"service-b"},
+	}
+
+	expiresAt := time.Now().Add(30 * time.Second)
+	subjectToken, err := provider.generateAccessTokenUntil(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+	}, "001", "api:read", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, expiresIn, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresIn > 30000 {
+		t.Fatal("Expected the exchanged token to expire with the subject token", expiresIn)
+	}
+	claims, err := provider.parseAccessToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(claims["exp"].(float64)) != expiresAt.Unix() {
+		t.Fatal("Expected the exp claim of the subject token", claims["exp"])
+	}
+
+	// Exchanging the exchanged token again does not extend it.
+	reexchanged, _, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: accessToken,
+		Audience:     "service-b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reexchangedClaims, err := provider.parseAccessToken(reexchanged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(reexchangedClaims["exp"].(float64)) != expiresAt.Unix() {
+		t.Fatal("Expected the exp claim of the original subject token", reexchangedClaims["exp"])
+	}
+}
+
+func TestExchangeTokenRevocation(t *testing.T) {
+	provider := getMockedProvider(t)
+	provider.RevocationStore = revocation.NewMemoryRevocationStore()
+	provider.ClientService.(*DummyClientService).Clients["service-a"] = &Client{
+		ClientID:               "service-a",
+		Secret:                 "abc",
+		TokenExchangeAudiences: []string{"service-b", "service-c"},
+	}
+
+	subjectToken, err := provider.generateAccessToken(map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+	}, "001", "api:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchanged, _, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     "service-b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reexchanged, _, err := provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: exchanged,
+		Audience:     "service-c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = provider.Revoke(subjectToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{exchanged, reexchanged} {
+		response, err := provider.Introspect(token, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response["active"] != false {
+			t.Fatal("Expected the derived token to be revoked with the subject token", response)
+		}
+	}
+	_, _, err = provider.ExchangeToken("service-a", "abc", &TokenExchangeRequest{
+		SubjectToken: exchanged,
+		Audience:     "service-b",
+	})
+	expectOAuth2Error(t, err, "invalid_request")
+}
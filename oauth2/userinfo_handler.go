@@ -0,0 +1,54 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/keitaroinc/goa"
+)
+
+// UserInfoPath is the conventional path of the OpenID Connect userinfo endpoint.
+const UserInfoPath = "/oauth2/userinfo"
+
+// NewUserInfoHandler creates an http.Handler for the OpenID Connect userinfo endpoint (OpenID Connect Core 1.0
+// section 5.3). The access token is read from the "Bearer" Authorization header and the claims of the user are
+// returned with AuthProvider.UserInfo.
+func NewUserInfoHandler(provider *AuthProvider) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodPost {
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		accessToken, ok := bearerToken(req)
+		if !ok {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		userInfo, err := provider.UserInfo(accessToken)
+		if err != nil {
+			writeBearerError(rw, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(rw).Encode(userInfo)
+	})
+}
+
+// writeBearerError writes the error as a bearer token error response (RFC 6750 section 3).
+func writeBearerError(rw http.ResponseWriter, err error) {
+	goaErr, ok := err.(*goa.ErrorResponse)
+	if !ok || (goaErr.Status != http.StatusUnauthorized && goaErr.Status != http.StatusForbidden) {
+		writeOAuth2Error(rw, err)
+		return
+	}
+	rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", goaErr.Code, goaErr.Detail))
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(goaErr.Status)
+}
@@ -0,0 +1,52 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserInfoHandler(t *testing.T) {
+	provider := getMockedProvider(t)
+	handler := NewUserInfoHandler(provider)
+
+	oauth2Token, err := provider.generateOAuthToken("001", "openid email", map[string]interface{}{
+		"userId":   "10001",
+		"username": "user",
+		"email":    "user@example.com",
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, UserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+oauth2Token.AccessToken)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatal("Expected status 200, got", rw.Code)
+	}
+	userInfo := map[string]interface{}{}
+	if err = json.NewDecoder(rw.Body).Decode(&userInfo); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo["sub"] != "10001" || userInfo["email"] != "user@example.com" {
+		t.Fatal("Unexpected user info", userInfo)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, UserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer invalid-token")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized || !strings.Contains(rw.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatal("Expected invalid_token error, got", rw.Code, rw.Header().Get("WWW-Authenticate"))
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, UserInfoPath, nil))
+	if rw.Code != http.StatusUnauthorized || rw.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatal("Expected status 401 for missing token, got", rw.Code)
+	}
+}
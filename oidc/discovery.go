@@ -0,0 +1,66 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DiscoveryPath is the well-known path of the OpenID Provider configuration, relative to the issuer.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// ProviderMetadata holds the OpenID Provider metadata (OpenID Connect Discovery 1.0 section 3) used by the
+// relying party.
+type ProviderMetadata struct {
+	// Issuer is the issuer identifier of the provider - the value of the "iss" claim of the tokens.
+	Issuer string `json:"issuer"`
+
+	// AuthorizationEndpoint is the URL of the authorization endpoint.
+	AuthorizationEndpoint string `json:"authorization_endpoint,omitempty"`
+
+	// TokenEndpoint is the URL of the token endpoint.
+	TokenEndpoint string `json:"token_endpoint,omitempty"`
+
+	// UserInfoEndpoint is the URL of the userinfo endpoint.
+	UserInfoEndpoint string `json:"userinfo_endpoint,omitempty"`
+
+	// JWKSURI is the URL of the JSON Web Key Set with the keys used to sign the tokens.
+	JWKSURI string `json:"jwks_uri"`
+
+	// IDTokenSigningAlgValues is the list of the algorithms used to sign the ID tokens.
+	IDTokenSigningAlgValues []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// DiscoveryURL returns the URL of the OpenID Provider configuration for the issuer.
+func DiscoveryURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + DiscoveryPath
+}
+
+// Discover fetches the OpenID Provider metadata from the discovery URL. The client may be nil, in which case
+// http.DefaultClient is used.
+func Discover(discoveryURL string, client *http.Client) (*ProviderMetadata, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenID Provider discovery at %s failed: %s", discoveryURL, resp.Status)
+	}
+
+	metadata := &ProviderMetadata{}
+	if err = json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse the OpenID Provider metadata: %s", err)
+	}
+	if metadata.Issuer == "" {
+		return nil, fmt.Errorf("the OpenID Provider metadata has no issuer")
+	}
+	if metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("the OpenID Provider metadata has no jwks_uri")
+	}
+	return metadata, nil
+}
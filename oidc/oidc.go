@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/chain"
+	"github.com/Microkubes/microservice-security/jwt"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// OIDCSecurityType is the name of the OpenID Connect security type.
+const OIDCSecurityType = "OIDC"
+
+const (
+	// MinDiscoveryRetryInterval is the time after a failed discovery during which the requests fail with the
+	// discovery error, without contacting the provider. The interval is doubled after every failed discovery.
+	MinDiscoveryRetryInterval = time.Second
+
+	// MaxDiscoveryRetryInterval is the maximal time between two discovery attempts.
+	MaxDiscoveryRetryInterval = 5 * time.Minute
+)
+
+// Config holds the configuration of an external OpenID Provider (for example Keycloak or Dex) whose
+// tokens are accepted by the service. The claim mapping and the claims validation are configured with
+// the "claimMapping" and "validation" properties, the same way as for the JWT security.
+type Config struct {
+	// Issuer is the issuer identifier (URL) of the OpenID Provider.
+	Issuer string `json:"issuer"`
+
+	// DiscoveryURL is the URL of the OpenID Provider configuration. Defaults to the well-known URL
+	// under the Issuer. If set, the issuer in the discovered metadata is not compared with the Issuer,
+	// which is useful when the provider is reachable under a different URL (ex. in a test environment).
+	DiscoveryURL string `json:"discoveryUrl,omitempty"`
+
+	// ClientID is the client ID of the service registered with the provider. If set, and no audience
+	// validation is configured, the "aud" claim must contain the ClientID, or the "azp" claim must be
+	// equal to it. Either the ClientID or the audience validation is required, see Validate.
+	ClientID string `json:"clientId,omitempty"`
+
+	// JWKSCacheTTL is the period in seconds for which the JSON Web Key Set of the provider is cached.
+	// Defaults to jwt.DefaultJWKSCacheTTL.
+	JWKSCacheTTL int64 `json:"jwksCacheTtl,omitempty"`
+
+	jwt.SecurityOptions
+}
+
+// Validate checks the configuration. The Issuer is required, and either the ClientID or the audience validation
+// (Validation.Audience), so that tokens issued by the provider for other clients are not accepted.
+func (c *Config) Validate() error {
+	if c.Issuer == "" {
+		return fmt.Errorf("the OpenID Provider issuer is required")
+	}
+	if c.ClientID == "" && (c.Validation == nil || len(c.Validation.Audience) == 0) {
+		return fmt.Errorf("either the clientId or the validation audience is required for the OpenID Provider %s", c.Issuer)
+	}
+	return nil
+}
+
+// DefaultClaimMapper returns the default claim mapping for OpenID Connect tokens: the standard claims
+// "sub", "preferred_username", "name" and "email" are mapped to the Auth user data. The "sub" claim is required.
+func DefaultClaimMapper() *auth.ClaimMapper {
+	return &auth.ClaimMapper{
+		UserID:   "sub",
+		Username: "preferred_username",
+		Fullname: "name",
+		Email:    "email",
+		Required: []string{"userId"},
+	}
+}
+
+// RelyingParty validates the tokens issued by an OpenID Provider. The provider configuration is discovered
+// and the key set is loaded on first use, so the provider does not have to be available when the service starts.
+// Only one discovery runs at a time; the concurrent requests wait for its result. A failed discovery is retried
+// after a backoff (see MinDiscoveryRetryInterval), until then the requests fail with the discovery error.
+type RelyingParty struct {
+	// Config is the OpenID Provider configuration.
+	Config *Config
+
+	// Client is the HTTP client used for the discovery.
+	Client *http.Client
+
+	mutex         sync.Mutex
+	metadata      *ProviderMetadata
+	resolver      *jwt.JWKSKeyResolver
+	discovering   chan struct{}
+	discoveryErr  error
+	retryAt       time.Time
+	retryInterval time.Duration
+}
+
+// NewRelyingParty creates a RelyingParty for the OpenID Provider configuration.
+// Panics if the configuration is not valid (see Config.Validate).
+func NewRelyingParty(config *Config) *RelyingParty {
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+	return &RelyingParty{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Metadata returns the OpenID Provider metadata, discovering it on the first call.
+func (rp *RelyingParty) Metadata() (*ProviderMetadata, error) {
+	metadata, _, err := rp.discover()
+	return metadata, err
+}
+
+// discover returns the discovered metadata and the key resolver of the provider. The discovery runs without holding
+// the lock, and the failures are cached until the retry time.
+func (rp *RelyingParty) discover() (*ProviderMetadata, *jwt.JWKSKeyResolver, error) {
+	rp.mutex.Lock()
+	if rp.resolver == nil && rp.discovering == nil && !time.Now().Before(rp.retryAt) {
+		rp.discovering = make(chan struct{})
+		rp.mutex.Unlock()
+		metadata, resolver, err := rp.load()
+		rp.mutex.Lock()
+		rp.completeDiscovery(metadata, resolver, err)
+	}
+	if discovering := rp.discovering; discovering != nil {
+		rp.mutex.Unlock()
+		<-discovering
+		rp.mutex.Lock()
+	}
+	defer rp.mutex.Unlock()
+
+	if rp.resolver == nil {
+		return nil, nil, rp.discoveryErr
+	}
+	return rp.metadata, rp.resolver, nil
+}
+
+// completeDiscovery records the result of the discovery and wakes up the requests waiting for it. A failure
+// doubles the retry interval. Must be called while holding the lock.
+func (rp *RelyingParty) completeDiscovery(metadata *ProviderMetadata, resolver *jwt.JWKSKeyResolver, err error) {
+	if err != nil {
+		rp.retryInterval *= 2
+		if rp.retryInterval < MinDiscoveryRetryInterval {
+			rp.retryInterval = MinDiscoveryRetryInterval
+		}
+		if rp.retryInterval > MaxDiscoveryRetryInterval {
+			rp.retryInterval = MaxDiscoveryRetryInterval
+		}
+		rp.discoveryErr = err
+		rp.retryAt = time.Now().Add(rp.retryInterval)
+	} else {
+		rp.metadata = metadata
+		rp.resolver = resolver
+		rp.discoveryErr = nil
+	}
+	close(rp.discovering)
+	rp.discovering = nil
+}
+
+// load discovers the provider configuration and loads its key set.
+func (rp *RelyingParty) load() (*ProviderMetadata, *jwt.JWKSKeyResolver, error) {
+	discoveryURL := rp.Config.DiscoveryURL
+	if discoveryURL == "" {
+		discoveryURL = DiscoveryURL(rp.Config.Issuer)
+	}
+	metadata, err := Discover(discoveryURL, rp.Client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rp.Config.DiscoveryURL == "" && strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(rp.Config.Issuer, "/") {
+		return nil, nil, fmt.Errorf("the discovered issuer %s does not match the configured issuer %s", metadata.Issuer, rp.Config.Issuer)
+	}
+
+	resolver, err := jwt.NewJWKSKeyResolver(metadata.JWKSURI, time.Duration(rp.Config.JWKSCacheTTL)*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	return metadata, resolver, nil
+}
+
+// claimsValidation returns the configured ClaimsValidation. Unless configured otherwise, the issuer must be
+// the discovered issuer.
+func (rp *RelyingParty) claimsValidation(metadata *ProviderMetadata) *jwt.ClaimsValidation {
+	validation := jwt.ClaimsValidation{}
+	if rp.Config.Validation != nil {
+		validation = *rp.Config.Validation
+	}
+	if len(validation.Issuers) == 0 {
+		validation.Issuers = []string{metadata.Issuer}
+	}
+	return &validation
+}
+
+// issuedFor checks if the "aud" claim contains the client ID or the "azp" claim is the client ID.
+func issuedFor(claims map[string]interface{}, clientID string) bool {
+	if azp, ok := claims["azp"].(string); ok && azp == clientID {
+		return true
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewOIDCSecurity creates a OpenID Connect SecurityChainMiddleware for the OpenID Provider configuration.
+// See NewOIDCSecurityMiddleware. Panics if the configuration is not valid (see Config.Validate).
+func NewOIDCSecurity(config *Config) chain.SecurityChainMiddleware {
+	return chain.ToSecurityChainMiddleware(OIDCSecurityType, NewOIDCSecurityMiddleware(NewRelyingParty(config)))
+}
+
+// RegisterOIDCSecurity registers a MiddlewareBuilder for the "OIDC" security type, so the OpenID Connect security
+// can be added to a chain with AddMiddlewareType(OIDCSecurityType).
+func RegisterOIDCSecurity(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	return chain.NewSecuirty(OIDCSecurityType, func() chain.SecurityChainMiddleware {
+		return NewOIDCSecurity(config)
+	})
+}
+
+// NewOIDCSecurityMiddleware creates a middleware that validates the ID tokens and the JWT access tokens issued
+// by the OpenID Provider of the RelyingParty.
+// The steps taken by the middleware are:
+// 1. Discover the provider configuration and load its JSON Web Key Set (on first use)
+// 2. Validate the signature of the "Bearer" token present in the "Authorization" header with the provider keys
+// 3. Validate the token claims - the issuer, the audience (see Config.ClientID) and the ClaimsValidation from the Config
+// 4. Map the claims to auth.Auth with the ClaimMapper from the Config or DefaultClaimMapper
+// The validated token is available in the context via goajwt.ContextJWT.
+func NewOIDCSecurityMiddleware(rp *RelyingParty) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			authorization := req.Header.Get("Authorization")
+			if authorization == "" {
+				return goa.ErrUnauthorized("missing auth header")
+			}
+			if len(authorization) < 8 || !strings.EqualFold(authorization[:7], "Bearer ") {
+				return goa.ErrUnauthorized("invalid auth header")
+			}
+			incomingToken := strings.TrimSpace(authorization[7:])
+
+			metadata, resolver, err := rp.discover()
+			if err != nil {
+				return goa.ErrInternal(err)
+			}
+
+			validation := rp.claimsValidation(metadata)
+			token, err := jwt.ValidateTokenWithClaims(incomingToken, resolver.SelectKeys(req), validation)
+			if err != nil {
+				if _, ok := err.(*goa.ErrorResponse); ok {
+					return err
+				}
+				return goajwt.ErrJWTError("OIDC token validation failed", "err", err.Error())
+			}
+			claims := token.Claims.(jwtgo.MapClaims)
+
+			if len(validation.Audience) == 0 && rp.Config.ClientID != "" && !issuedFor(claims, rp.Config.ClientID) {
+				return jwt.ErrInvalidAudience("the token was not issued for the client", "clientId", rp.Config.ClientID)
+			}
+
+			authObj, err := rp.Config.MapClaims(claims, DefaultClaimMapper())
+			if err != nil {
+				return goajwt.ErrJWTError(err)
+			}
+
+			ctx = goajwt.WithJWT(ctx, token)
+			return h(auth.SetAuth(ctx, authObj), rw, req)
+		}
+	}
+}
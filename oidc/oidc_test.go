@@ -0,0 +1,224 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/chain"
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// newTestProvider starts a stand-in OpenID Provider that publishes the public part of the key.
+func newTestProvider(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc(DiscoveryPath, func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(&ProviderMetadata{
+			Issuer:  server.URL,
+			JWKSURI: server.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/certs", func(rw http.ResponseWriter, req *http.Request) {
+		jwk, err := jwt.NewJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(rw).Encode(&jwt.JWKSet{Keys: []jwt.JWK{*jwk}})
+	})
+	return server
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	token, err := jwt.SignToken(claims, "RS256", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func authenticate(rp *RelyingParty, token string) (*auth.Auth, error) {
+	req := httptest.NewRequest(http.MethodGet, "/profiles/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var authObj *auth.Auth
+	err := NewOIDCSecurityMiddleware(rp)(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		if goajwt.ContextJWT(ctx) == nil {
+			return goa.ErrInternal("expected the token in the context")
+		}
+		authObj = auth.GetAuth(ctx)
+		return nil
+	})(context.Background(), httptest.NewRecorder(), req)
+	return authObj, err
+}
+
+func TestOIDCSecurityMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestProvider(t, key)
+	defer server.Close()
+
+	rp := NewRelyingParty(&Config{
+		Issuer:   server.URL,
+		ClientID: "user-microservice",
+	})
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss":                server.URL,
+			"sub":                "10001",
+			"aud":                "user-microservice",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"preferred_username": "user",
+			"email":              "user@example.com",
+			"roles":              []string{"user"},
+		}
+		for claim, value := range overrides {
+			claims[claim] = value
+		}
+		return claims
+	}
+
+	authObj, err := authenticate(rp, signToken(t, key, claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authObj == nil || authObj.UserID != "10001" || authObj.Username != "user" || authObj.Email != "user@example.com" ||
+		len(authObj.Roles) != 1 || authObj.Roles[0] != "user" {
+		t.Fatal("Unexpected auth", authObj)
+	}
+
+	// access token issued to another client for the service
+	if _, err = authenticate(rp, signToken(t, key, claims(map[string]interface{}{"aud": "account", "azp": "user-microservice"}))); err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"wrong issuer", signToken(t, key, claims(map[string]interface{}{"iss": "http://evil.example.com"})), "invalid_issuer"},
+		{"wrong audience", signToken(t, key, claims(map[string]interface{}{"aud": "other-service"})), "invalid_audience"},
+		{"expired", signToken(t, key, claims(map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})), "token_expired"},
+		{"unknown key", signToken(t, otherKey, claims(nil)), "jwt_security_error"},
+	}
+	for _, test := range tests {
+		_, err := authenticate(rp, test.token)
+		if goaErr, ok := err.(*goa.ErrorResponse); !ok || goaErr.Code != test.code {
+			t.Fatalf("%s: expected %s error, got %v", test.name, test.code, err)
+		}
+	}
+}
+
+func TestOIDCSecurityDiscoveryFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestProvider(t, key)
+	defer server.Close()
+
+	// the discovered issuer must match the configured issuer
+	rp := NewRelyingParty(&Config{Issuer: "http://keycloak:8080/realms/test", ClientID: "user-microservice"})
+	if _, err = rp.Metadata(); err == nil {
+		t.Fatal("Expected the issuer mismatch to be rejected")
+	}
+
+	// unless the discovery URL is configured explicitly
+	rp = NewRelyingParty(&Config{
+		Issuer:       "http://keycloak:8080/realms/test",
+		DiscoveryURL: DiscoveryURL(server.URL),
+		ClientID:     "user-microservice",
+	})
+	metadata, err := rp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Issuer != server.URL || metadata.JWKSURI != server.URL+"/certs" {
+		t.Fatal("Unexpected metadata", metadata)
+	}
+
+	rp = NewRelyingParty(&Config{Issuer: server.URL + "/unknown", ClientID: "user-microservice"})
+	if _, err = authenticate(rp, signToken(t, key, map[string]interface{}{"sub": "10001"})); err == nil {
+		t.Fatal("Expected an error when the discovery fails")
+	}
+}
+
+func TestOIDCSecurityDiscoveryBackoff(t *testing.T) {
+	var mutex sync.Mutex
+	discoveries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		discoveries++
+		mutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	rp := NewRelyingParty(&Config{Issuer: server.URL, ClientID: "user-microservice"})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := rp.Metadata(); err == nil {
+				t.Error("Expected the discovery to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := rp.Metadata(); err == nil {
+		t.Fatal("Expected the cached discovery error")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if discoveries != 1 {
+		t.Fatal("Expected a single discovery until the retry interval passes, got", discoveries)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (&Config{Issuer: "http://localhost:5556/dex"}).Validate(); err == nil {
+		t.Fatal("Expected a config without client ID and audience to be rejected")
+	}
+	if err := (&Config{ClientID: "user-microservice"}).Validate(); err == nil {
+		t.Fatal("Expected a config without issuer to be rejected")
+	}
+	config := &Config{Issuer: "http://localhost:5556/dex"}
+	config.Validation = &jwt.ClaimsValidation{Audience: []string{"user-microservice"}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterOIDCSecurity(&Config{Issuer: "http://localhost:5556/dex"}); err == nil {
+		t.Fatal("Expected the invalid config to be rejected")
+	}
+}
+
+func TestRegisterOIDCSecurity(t *testing.T) {
+	if err := RegisterOIDCSecurity(&Config{Issuer: "http://localhost:5556/dex", ClientID: "user-microservice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.NewSecurityChain().AddMiddlewareType(OIDCSecurityType); err != nil {
+		t.Fatal(err)
+	}
+}
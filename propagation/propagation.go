@@ -0,0 +1,249 @@
+// Package propagation provides an http.RoundTripper that attaches the credentials of the current request
+// to the outbound requests made to other microservices.
+package propagation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/jwt"
+	"github.com/Microkubes/microservice-security/oauth2"
+	"github.com/Microkubes/microservice-security/tools"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Mode is the way the Transport obtains the token for the outbound requests.
+type Mode int
+
+const (
+	// ForwardToken forwards the token of the current request as it is.
+	ForwardToken Mode = iota
+
+	// ServiceToken mints a short-lived token for the service itself, signed with the key from the KeyStore.
+	ServiceToken
+
+	// ExchangeToken exchanges the token of the current request for a token restricted to the target service,
+	// acting on behalf of the user (RFC 8693).
+	ExchangeToken
+)
+
+// DefaultServiceTokenTTL is the validity period of the service tokens if none is set.
+const DefaultServiceTokenTTL = time.Minute
+
+// TokenExchanger performs the token exchange. It is implemented by oauth2.AuthProvider.
+type TokenExchanger interface {
+	// ExchangeToken exchanges the subject token in the request for a new access token.
+	ExchangeToken(clientID, clientSecret string, request *oauth2.TokenExchangeRequest) (accessToken string, expiresIn int, err error)
+}
+
+type key string
+
+// tokenKey is the context key under which the token set with WithToken is stored.
+const tokenKey key = "propagation-token"
+
+// WithToken sets the token of the current request in the context. It is needed only for tokens that are
+// not set in the context by the security middlewares - the JWT, OAuth2 and OpenID Connect middlewares set the
+// validated token, which is read by TokenFromContext.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// TokenFromContext returns the token of the current request - the token set with WithToken, or the JWT
+// validated by the security middlewares. Returns an empty string if the context holds no token.
+func TokenFromContext(ctx context.Context) string {
+	if token, ok := ctx.Value(tokenKey).(string); ok && token != "" {
+		return token
+	}
+	if token := goajwt.ContextJWT(ctx); token != nil {
+		return token.Raw
+	}
+	return ""
+}
+
+// Transport is an http.RoundTripper that sets the "Authorization: Bearer" header of the outbound requests, with
+// the token obtained according to the Mode. The token is attached only to requests for the AllowedHosts, so the
+// tokens do not leak to third-party services, and never to requests that already have an Authorization header.
+// The outbound request must carry the context of the current request (http.NewRequestWithContext or
+// req.WithContext), from which the token (and the auth.Auth) are read.
+type Transport struct {
+	// Base is the RoundTripper used to send the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Mode is the way the token is obtained.
+	Mode Mode
+
+	// AllowedHosts is the list of hosts to which the token is sent. A host may contain a port ("users:8080"),
+	// and may start with "*." to allow all subdomains ("*.services.internal"). If empty, no token is sent.
+	AllowedHosts []string
+
+	// Audiences maps the allowed hosts to the audience of the service tokens and the exchanged tokens.
+	// If a host is not in the map, the host name is used as audience.
+	Audiences map[string]string
+
+	// ServiceName is the name of the service, used as issuer and subject of the service tokens and as the client ID
+	// for the token exchange.
+	ServiceName string
+
+	// ClientSecret is the client secret of the service, used to authenticate the token exchange.
+	ClientSecret string
+
+	// KeyStore holds the private key used to sign the service tokens.
+	KeyStore tools.KeyStore
+
+	// SigningMethod is the signing method of the service tokens. Defaults to "RS256".
+	SigningMethod string
+
+	// ServiceTokenTTL is the validity period of the service tokens. Defaults to DefaultServiceTokenTTL.
+	ServiceTokenTTL time.Duration
+
+	// Scope is the (space-delimited) scope of the service tokens and the scope requested in the token exchange.
+	Scope string
+
+	// Exchanger performs the token exchange in the ExchangeToken mode.
+	Exchanger TokenExchanger
+}
+
+// NewClient creates an http.Client that sends the requests with the Transport.
+func NewClient(transport *Transport) *http.Client {
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// RoundTrip attaches the token to the request, if the host is allowed, and sends it with the Base RoundTripper.
+// The request is not sent if the token cannot be obtained. In the ForwardToken and ExchangeToken modes, a
+// request without token in the context is sent without Authorization header.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || !t.allowed(req) {
+		return t.base().RoundTrip(req)
+	}
+
+	token, err := t.token(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if token == "" {
+		return t.base().RoundTrip(req)
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.Header.Set("Authorization", "Bearer "+token)
+	return t.base().RoundTrip(outReq)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// allowed checks whether the host of the request is in the AllowedHosts.
+func (t *Transport) allowed(req *http.Request) bool {
+	host := strings.ToLower(req.URL.Host)
+	hostname := strings.ToLower(req.URL.Hostname())
+	for _, allowedHost := range t.AllowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if allowedHost == host || allowedHost == hostname {
+			return true
+		}
+		if strings.HasPrefix(allowedHost, "*.") && strings.HasSuffix(hostname, allowedHost[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// audience returns the audience of the tokens for the host of the request.
+func (t *Transport) audience(req *http.Request) string {
+	if audience, ok := t.Audiences[req.URL.Host]; ok {
+		return audience
+	}
+	if audience, ok := t.Audiences[req.URL.Hostname()]; ok {
+		return audience
+	}
+	return req.URL.Hostname()
+}
+
+// token obtains the token for the request according to the Mode.
+func (t *Transport) token(req *http.Request) (string, error) {
+	switch t.Mode {
+	case ForwardToken:
+		return TokenFromContext(req.Context()), nil
+	case ServiceToken:
+		return t.serviceToken(req)
+	case ExchangeToken:
+		return t.exchangeToken(req)
+	}
+	return "", fmt.Errorf("unknown token propagation mode %d", t.Mode)
+}
+
+// serviceToken mints a service token with the same claims as the tokens of the client credentials grant, so the
+// Auth created by the security middlewares of the target service is a service identity.
+func (t *Transport) serviceToken(req *http.Request) (string, error) {
+	if t.KeyStore == nil {
+		return "", fmt.Errorf("a KeyStore is required for the service tokens")
+	}
+	key, err := t.KeyStore.GetPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	signingMethod := t.SigningMethod
+	if signingMethod == "" {
+		signingMethod = "RS256"
+	}
+	ttl := t.ServiceTokenTTL
+	if ttl <= 0 {
+		ttl = DefaultServiceTokenTTL
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"jti":       jti.String(),
+		"iss":       t.ServiceName,
+		"sub":       t.ServiceName,
+		"aud":       t.audience(req),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(ttl).Unix(),
+		"roles":     auth.ServiceRole,
+		"client_id": t.ServiceName,
+	}
+	if t.Scope != "" {
+		claims["scopes"] = t.Scope
+	}
+	return jwt.SignToken(claims, signingMethod, key)
+}
+
+// exchangeToken exchanges the token of the current request for a token for the target service.
+func (t *Transport) exchangeToken(req *http.Request) (string, error) {
+	subjectToken := TokenFromContext(req.Context())
+	if subjectToken == "" {
+		return "", nil
+	}
+	if t.Exchanger == nil {
+		return "", fmt.Errorf("a TokenExchanger is required for the token exchange")
+	}
+	accessToken, _, err := t.Exchanger.ExchangeToken(t.ServiceName, t.ClientSecret, &oauth2.TokenExchangeRequest{
+		SubjectToken:     subjectToken,
+		SubjectTokenType: oauth2.AccessTokenType,
+		Audience:         t.audience(req),
+		Scope:            t.Scope,
+	})
+	if err != nil {
+		return "", err
+	}
+	return accessToken, nil
+}
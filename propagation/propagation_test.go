@@ -0,0 +1,217 @@
+package propagation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-security/auth"
+	"github.com/Microkubes/microservice-security/oauth2"
+	"github.com/Microkubes/microservice-security/tools"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+var _ TokenExchanger = &oauth2.AuthProvider{}
+
+type mockExchanger struct {
+	requests []*oauth2.TokenExchangeRequest
+}
+
+func (e *mockExchanger) ExchangeToken(clientID, clientSecret string, request *oauth2.TokenExchangeRequest) (string, int, error) {
+	e.requests = append(e.requests, request)
+	if request.SubjectToken == "invalid" || clientSecret != "secret" {
+		return "", 0, oauth2.OAuth2ErrorInvalidRequest("invalid subject token")
+	}
+	return fmt.Sprintf("%s-for-%s-as-%s", request.SubjectToken, request.Audience, clientID), 60000, nil
+}
+
+// newServer starts a server that responds with the Authorization header of the request.
+func newServer(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Header.Get("Authorization")))
+	}))
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, serverURL.Host
+}
+
+func doRequest(t *testing.T, client *http.Client, ctx context.Context, target string) string {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTokenFromContext(t *testing.T) {
+	if token := TokenFromContext(context.Background()); token != "" {
+		t.Fatal("Expected no token", token)
+	}
+	ctx := goajwt.WithJWT(context.Background(), &jwtgo.Token{Raw: "jwt-token"})
+	if token := TokenFromContext(ctx); token != "jwt-token" {
+		t.Fatal("Expected the validated JWT", token)
+	}
+	if token := TokenFromContext(WithToken(ctx, "opaque-token")); token != "opaque-token" {
+		t.Fatal("Expected the token set with WithToken", token)
+	}
+}
+
+func TestForwardToken(t *testing.T) {
+	server, host := newServer(t)
+	defer server.Close()
+
+	client := NewClient(&Transport{
+		Mode:         ForwardToken,
+		AllowedHosts: []string{host},
+	})
+	ctx := WithToken(context.Background(), "user-token")
+
+	if header := doRequest(t, client, ctx, server.URL); header != "Bearer user-token" {
+		t.Fatal("Expected the user token to be forwarded", header)
+	}
+	if header := doRequest(t, client, context.Background(), server.URL); header != "" {
+		t.Fatal("Expected no token without token in the context", header)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Authorization", "Basic abc")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Basic abc" {
+		t.Fatal("Expected the Authorization header of the request to be kept", string(body))
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	server, host := newServer(t)
+	defer server.Close()
+	ctx := WithToken(context.Background(), "user-token")
+
+	for _, allowedHosts := range [][]string{nil, {"example.com"}, {"*.example.com"}} {
+		client := NewClient(&Transport{AllowedHosts: allowedHosts})
+		if header := doRequest(t, client, ctx, server.URL); header != "" {
+			t.Fatal("Expected no token for a host that is not allowed", allowedHosts, header)
+		}
+	}
+
+	transport := &Transport{AllowedHosts: []string{"users", "*.services.internal", host}}
+	for target, allowed := range map[string]bool{
+		"http://users/api":                                             true,
+		"http://USERS:8080/api":                                        true,
+		"http://users.services.internal/api":                           true,
+		"http://users.services.internal.evil/":                         false,
+		"http://services.internal/api":                                 false,
+		"http://example.com/?host=users":                               false,
+		fmt.Sprintf("http://%s/api", host):                             true,
+		fmt.Sprintf("http://%s.evil/api", strings.Split(host, ":")[0]): false,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if transport.allowed(req) != allowed {
+			t.Fatal("Unexpected allowed check for", target)
+		}
+	}
+}
+
+func TestServiceToken(t *testing.T) {
+	server, host := newServer(t)
+	defer server.Close()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(&Transport{
+		Mode:         ServiceToken,
+		AllowedHosts: []string{host},
+		Audiences:    map[string]string{host: "users-service"},
+		ServiceName:  "orders-service",
+		KeyStore:     &tools.FileKeyStore{PrivateKey: privateKey},
+		Scope:        "api:read",
+	})
+
+	header := doRequest(t, client, context.Background(), server.URL)
+	if !strings.HasPrefix(header, "Bearer ") {
+		t.Fatal("Expected a service token", header)
+	}
+	token, err := jwtgo.Parse(strings.TrimPrefix(header, "Bearer "), func(*jwtgo.Token) (interface{}, error) {
+		return &privateKey.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(jwtgo.MapClaims)
+	if claims["sub"] != "orders-service" || claims["aud"] != "users-service" || claims["scopes"] != "api:read" {
+		t.Fatal("Unexpected service token claims", claims)
+	}
+	if claims["roles"] != auth.ServiceRole || claims["client_id"] != "orders-service" || claims["userId"] != nil {
+		t.Fatal("Expected a service identity", claims)
+	}
+
+	_, err = NewClient(&Transport{Mode: ServiceToken, AllowedHosts: []string{host}}).Get(server.URL)
+	if err == nil {
+		t.Fatal("Expected an error without KeyStore")
+	}
+}
+
+func TestExchangeToken(t *testing.T) {
+	server, host := newServer(t)
+	defer server.Close()
+
+	exchanger := &mockExchanger{}
+	client := NewClient(&Transport{
+		Mode:         ExchangeToken,
+		AllowedHosts: []string{host},
+		ServiceName:  "orders-service",
+		ClientSecret: "secret",
+		Scope:        "api:read",
+		Exchanger:    exchanger,
+	})
+
+	header := doRequest(t, client, WithToken(context.Background(), "user-token"), server.URL)
+	expected := fmt.Sprintf("Bearer user-token-for-%s-as-orders-service", strings.Split(host, ":")[0])
+	if header != expected {
+		t.Fatal("Expected the exchanged token", header)
+	}
+	if len(exchanger.requests) != 1 || exchanger.requests[0].Scope != "api:read" ||
+		exchanger.requests[0].SubjectTokenType != oauth2.AccessTokenType {
+		t.Fatal("Unexpected token exchange requests", exchanger.requests)
+	}
+
+	if header := doRequest(t, client, context.Background(), server.URL); header != "" {
+		t.Fatal("Expected no token without token in the context", header)
+	}
+	if len(exchanger.requests) != 1 {
+		t.Fatal("Expected no token exchange without token in the context")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := client.Do(req.WithContext(WithToken(context.Background(), "invalid"))); err == nil {
+		t.Fatal("Expected the token exchange error")
+	}
+}
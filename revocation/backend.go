@@ -0,0 +1,93 @@
+package revocation
+
+import (
+	"time"
+
+	"github.com/Microkubes/backends"
+	"github.com/Microkubes/microservice-tools/config"
+)
+
+// RevokedToken is a revoked token record stored in the backend.
+type RevokedToken struct {
+	// ID is the ID of the revoked token (the "jti" claim).
+	ID string `json:"id" bson:"id"`
+
+	// RevokedAt is a Unix timestamp of when the token was revoked.
+	RevokedAt int64 `json:"revokedAt" bson:"revokedAt"`
+
+	// ExpiresAt is a Unix timestamp of when the token expires. Zero means the token never expires.
+	ExpiresAt int64 `json:"expiresAt" bson:"expiresAt"`
+}
+
+// BackendRevocationStore is a RevocationStore that keeps the revoked tokens in a backends.Repository
+// (MongoDB or DynamoDB).
+type BackendRevocationStore struct {
+	Repository backends.Repository
+}
+
+// NewBackendRevocationStore builds a BackendRevocationStore for the given database configuration.
+// The revoked tokens are kept in the "revokedTokens" collection (table).
+func NewBackendRevocationStore(cfg *config.DBConfig) (*BackendRevocationStore, func(), error) {
+	manager := backends.NewBackendSupport(map[string]*config.DBInfo{
+		cfg.DBName: &cfg.DBInfo,
+	})
+
+	noop := func() {}
+
+	backend, err := manager.GetBackend(cfg.DBName)
+	if err != nil {
+		return nil, noop, err
+	}
+
+	repository, err := backend.DefineRepository("revokedTokens", backends.RepositoryDefinitionMap{
+		"customId":      true,
+		"name":          "revokedTokens",
+		"enableTtl":     false,
+		"hashKey":       "id",
+		"readCapacity":  50,
+		"writeCapacity": 50,
+		"indexes": []backends.Index{
+			backends.NewUniqueIndex("id"),
+		},
+	})
+	if err != nil {
+		return nil, noop, err
+	}
+
+	return &BackendRevocationStore{
+		Repository: repository,
+	}, backend.Shutdown, nil
+}
+
+// Revoke stores the token ID as revoked until expiresAt. Revoking an already revoked token is not an error.
+func (s *BackendRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	record := &RevokedToken{
+		ID:        jti,
+		RevokedAt: time.Now().Unix(),
+	}
+	if !expiresAt.IsZero() {
+		record.ExpiresAt = expiresAt.Unix()
+	}
+	_, err := s.Repository.Save(record, nil)
+	if err != nil && backends.IsErrAlreadyExists(err) {
+		_, err = s.Repository.Save(record, backends.NewFilter().Match("id", jti))
+	}
+	return err
+}
+
+// IsRevoked checks whether the token ID has been revoked and has not expired yet.
+// The record of an expired token is removed from the backend.
+func (s *BackendRevocationStore) IsRevoked(jti string) (bool, error) {
+	result, err := s.Repository.GetOne(backends.NewFilter().Match("id", jti), &RevokedToken{})
+	if err != nil {
+		if backends.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	record := result.(*RevokedToken)
+	if record.ExpiresAt != 0 && time.Now().Unix() > record.ExpiresAt {
+		return false, s.Repository.DeleteOne(backends.NewFilter().Match("id", jti))
+	}
+	return true, nil
+}
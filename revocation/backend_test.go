@@ -0,0 +1,90 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Microkubes/backends"
+)
+
+type DummyRepository struct {
+	Records map[string]*RevokedToken
+}
+
+func (d *DummyRepository) GetOne(filter backends.Filter, result interface{}) (interface{}, error) {
+	record, ok := d.Records[filter["id"].(string)]
+	if !ok {
+		return nil, backends.ErrNotFound("not found")
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (d *DummyRepository) GetAll(filter backends.Filter, resultsTypeHint interface{}, order string, sorting string, limit int, offset int) (interface{}, error) {
+	return nil, nil
+}
+
+func (d *DummyRepository) Save(object interface{}, filter backends.Filter) (interface{}, error) {
+	record := object.(*RevokedToken)
+	if _, ok := d.Records[record.ID]; ok && filter == nil {
+		return nil, backends.ErrAlreadyExists("already exists")
+	}
+	d.Records[record.ID] = record
+	return record, nil
+}
+
+func (d *DummyRepository) DeleteOne(filter backends.Filter) error {
+	delete(d.Records, filter["id"].(string))
+	return nil
+}
+
+func (d *DummyRepository) DeleteAll(filter backends.Filter) error {
+	return d.DeleteOne(filter)
+}
+
+func TestBackendRevocationStore(t *testing.T) {
+	repository := &DummyRepository{
+		Records: map[string]*RevokedToken{},
+	}
+	store := &BackendRevocationStore{
+		Repository: repository,
+	}
+
+	if err := store.Revoke("token-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// revoking twice updates the record
+	if err := store.Revoke("token-1", time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke("token-2", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := store.IsRevoked("token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("Expected token-1 to be revoked")
+	}
+
+	revoked, err = store.IsRevoked("token-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("Expected token-2 to be expired")
+	}
+	if _, ok := repository.Records["token-2"]; ok {
+		t.Fatal("Expected the expired record to be removed")
+	}
+
+	revoked, err = store.IsRevoked("token-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("Expected token-3 not to be revoked")
+	}
+}
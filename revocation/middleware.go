@@ -0,0 +1,39 @@
+package revocation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Microkubes/microservice-security/chain"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+// ErrTokenRevoked is returned when the token presented with the request has been revoked.
+var ErrTokenRevoked = goa.NewErrorClass("token_revoked", 401)
+
+// NewRevocationMiddleware creates a chain.SecurityChainMiddleware that rejects the requests with revoked tokens.
+// The middleware must be added to the chain after the JWT or OAuth2 middleware, as it checks the "jti" claim of
+// the validated token in the context, and the IDs of the tokens from which the token was derived (see
+// DerivedFromClaim). Requests without a validated token or without a "jti" claim are passed through.
+func NewRevocationMiddleware(store RevocationStore) chain.SecurityChainMiddleware {
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (context.Context, http.ResponseWriter, error) {
+		token := goajwt.ContextJWT(ctx)
+		if token == nil {
+			return ctx, rw, nil
+		}
+		claims, ok := token.Claims.(jwtgo.MapClaims)
+		if !ok {
+			return ctx, rw, nil
+		}
+		revoked, err := IsTokenRevoked(store, claims)
+		if err != nil {
+			return ctx, rw, goa.ErrInternal(err)
+		}
+		if revoked {
+			return ctx, rw, ErrTokenRevoked("token has been revoked")
+		}
+		return ctx, rw, nil
+	}
+}
@@ -0,0 +1,49 @@
+package revocation
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"github.com/keitaroinc/goa"
+	goajwt "github.com/keitaroinc/goa/middleware/security/jwt"
+)
+
+func TestNewRevocationMiddleware(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	if err := store.Revoke("revoked-token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	middleware := NewRevocationMiddleware(store)
+
+	tests := []struct {
+		name    string
+		token   *jwtgo.Token
+		revoked bool
+	}{
+		{"no token", nil, false},
+		{"no jti", jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"userId": "1"}), false},
+		{"valid token", jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"jti": "valid-token"}), false},
+		{"revoked token", jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, jwtgo.MapClaims{"jti": "revoked-token"}), true},
+	}
+
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.token != nil {
+			ctx = goajwt.WithJWT(ctx, test.token)
+		}
+		_, _, err := middleware(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+		if !test.revoked {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+		goaErr, ok := err.(*goa.ErrorResponse)
+		if !ok || goaErr.Code != "token_revoked" {
+			t.Fatalf("%s: expected token_revoked error, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationStore keeps track of the revoked tokens. The tokens are identified by their ID (the "jti" claim).
+// A token needs to be kept as revoked only until it expires - after that it is rejected by the token
+// validation anyway.
+type RevocationStore interface {
+	// Revoke records the token with the given ID as revoked until expiresAt. A zero expiresAt
+	// means the token never expires, so it is kept as revoked indefinitely.
+	Revoke(jti string, expiresAt time.Time) error
+
+	// IsRevoked checks whether the token with the given ID has been revoked.
+	IsRevoked(jti string) (bool, error)
+}
+
+// DerivedFromClaim is the claim of a token that holds the IDs of the tokens (for tokens obtained by token exchange)
+// or of the token family from which it was derived. A derived token is revoked together with any of these IDs.
+const DerivedFromClaim = "derived_from"
+
+// TokenIDs returns the ID of the token (the "jti" claim) followed by the IDs of the tokens from which the token
+// was derived (the DerivedFromClaim).
+func TokenIDs(claims map[string]interface{}) []string {
+	ids := []string{}
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		ids = append(ids, jti)
+	}
+	switch derivedFrom := claims[DerivedFromClaim].(type) {
+	case []string:
+		ids = append(ids, derivedFrom...)
+	case []interface{}:
+		for _, id := range derivedFrom {
+			if jti, ok := id.(string); ok && jti != "" {
+				ids = append(ids, jti)
+			}
+		}
+	}
+	return ids
+}
+
+// IsTokenRevoked checks whether the token, or any of the tokens from which it was derived, has been revoked.
+func IsTokenRevoked(store RevocationStore, claims map[string]interface{}) (bool, error) {
+	for _, jti := range TokenIDs(claims) {
+		revoked, err := store.IsRevoked(jti)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	return false, nil
+}
+
+// MemoryRevocationStore is a RevocationStore that keeps the revoked tokens in memory.
+// It is suitable for a single instance of a service or for testing.
+type MemoryRevocationStore struct {
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocationStore creates an empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked: map[string]time.Time{},
+	}
+}
+
+// Revoke records the token ID as revoked until expiresAt. The expired entries are removed
+// from the store on every call.
+func (s *MemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for id, expires := range s.revoked {
+		if isExpired(expires, now) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsRevoked checks whether the token ID has been revoked and has not expired yet.
+func (s *MemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expiresAt, ok := s.revoked[jti]
+	if !ok {
+		return false, nil
+	}
+	return !isExpired(expiresAt, time.Now()), nil
+}
+
+func isExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && now.After(expiresAt)
+}
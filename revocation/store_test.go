@@ -0,0 +1,52 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	store := NewMemoryRevocationStore()
+
+	revoked, err := store.IsRevoked("token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("Expected the token not to be revoked")
+	}
+
+	if err = store.Revoke("token-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Revoke("token-2", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Revoke("token-3", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"token-1": true,
+		"token-2": false, // already expired
+		"token-3": true,
+		"token-4": false,
+	}
+	for jti, expected := range tests {
+		revoked, err := store.IsRevoked(jti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != expected {
+			t.Fatalf("Expected revoked=%t for %s", expected, jti)
+		}
+	}
+
+	// the expired token is removed on the next revocation
+	if err = store.Revoke("token-5", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.revoked["token-2"]; ok {
+		t.Fatal("Expected the expired token to be removed from the store")
+	}
+}
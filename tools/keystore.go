@@ -6,10 +6,8 @@ import (
 	"log"
 	"os"
 	"path"
-
+	"sort"
 	"strings"
-
-	jwtgo "github.com/dgrijalva/jwt-go"
 )
 
 // KeyStore defines an interface for reading private keys for JWT signing.
@@ -22,6 +20,12 @@ type KeyStore interface {
 	GetPrivateKeyByName(keyName string) (interface{}, error)
 }
 
+// KeyNameLister is implemented by KeyStores that can list the names of the keys they hold.
+type KeyNameLister interface {
+	// KeyNames returns the names of all keys in the KeyStore.
+	KeyNames() []string
+}
+
 // FileKeyStore holds the data for a file-based KeyStore implementation.
 type FileKeyStore struct {
 	// PrivateKey is the default private key
@@ -50,11 +54,28 @@ func (fks *FileKeyStore) GetPrivateKeyByName(keyName string) (interface{}, error
 	return priv, nil
 }
 
+// KeyNames returns the sorted names of all loaded keys.
+func (fks *FileKeyStore) KeyNames() []string {
+	names := []string{}
+	for keyName := range fks.KeysMap {
+		names = append(names, keyName)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // NewFileKeyStore returns a file-based KeyStore implementation.
 // The keys are loaded based on the map of <key-name>:<key-file> provided.
 // The functions expects to be at least one key with name "default" defined.
-// The keys must be RSA keys and the files must be PEM.
+// The files must be PEM encoded RSA (PKCS#1 or PKCS#8), EC (SEC1 or PKCS#8) or
+// Ed25519 (PKCS#8) private keys.
 func NewFileKeyStore(keyFiles map[string]string) (KeyStore, error) {
+	return loadFileKeyStore(keyFiles, true)
+}
+
+// loadFileKeyStore loads the FileKeyStore from the key files. The loaded keys are logged only if logKeys is set,
+// so the periodic reloads (see ReloadingKeyStore) do not log every key on every scan.
+func loadFileKeyStore(keyFiles map[string]string, logKeys bool) (*FileKeyStore, error) {
 	keyStore := FileKeyStore{
 		KeysMap: make(map[string]interface{}),
 	}
@@ -65,13 +86,15 @@ func NewFileKeyStore(keyFiles map[string]string) (KeyStore, error) {
 			log.Println("Continuing on next key")
 			continue
 		}
-		privKey, err := jwtgo.ParseRSAPrivateKeyFromPEM(keyBytes)
+		privKey, err := ParsePrivateKeyFromPEM(keyBytes)
 		if err != nil {
 			log.Println("Failed to load key:", keyFile, "; Err:", err.Error())
 			return nil, err
 		}
 		keyStore.KeysMap[keyName] = privKey
-		log.Println("Key loaded:", keyFile, "as", keyName)
+		if logKeys {
+			log.Println("Key loaded:", keyFile, "as", keyName)
+		}
 	}
 	defaultKey, ok := keyStore.KeysMap["default"]
 	if !ok {
@@ -85,8 +108,18 @@ func NewFileKeyStore(keyFiles map[string]string) (KeyStore, error) {
 // The keys are loaded from the directory by scanning the directory
 // for private keys.
 // The functions expects to be at least one key with name "default" defined.
-// The keys must be RSA keys and the files must be PEM.
+// The files must be PEM encoded private keys (see NewFileKeyStore).
 func NewDirKeyStore(keysDir string) (KeyStore, error) {
+	keysMap, err := scanKeysDir(keysDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileKeyStore(keysMap)
+}
+
+// scanKeysDir scans the directory for private key files and returns a map <key-name>:<key-file>.
+// Public keys, certificates and configuration files are skipped.
+func scanKeysDir(keysDir string) (map[string]string, error) {
 	fi, err := os.Stat(keysDir)
 	if err != nil {
 		return nil, err
@@ -107,7 +140,7 @@ func NewDirKeyStore(keysDir string) (KeyStore, error) {
 			}
 		}
 	}
-	return NewFileKeyStore(keysMap)
+	return keysMap, nil
 }
 
 // hasAnySuffix checks if 'name' starts with any of the supplied suffixes.
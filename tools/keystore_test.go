@@ -1,6 +1,9 @@
 package tools
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -96,6 +99,87 @@ func TestDirBasedKeyStore(t *testing.T) {
 	}
 }
 
+func TestDirBasedKeyStoreKeyFormats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmpDir)
+	}()
+
+	rsaKey, err := generateRSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSEC1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFiles := map[string]*pem.Block{
+		"default": {Type: "PRIVATE KEY", Bytes: rsaPKCS8},
+		"ec-sec1": {Type: "EC PRIVATE KEY", Bytes: ecSEC1},
+		"ec":      {Type: "PRIVATE KEY", Bytes: ecPKCS8},
+		"ed25519": {Type: "PRIVATE KEY", Bytes: edPKCS8},
+	}
+	for name, block := range keyFiles {
+		if err = ioutil.WriteFile(path.Join(tmpDir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyStore, err := NewDirKeyStore(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keyStore.GetPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Fatal("Expected RSA private key")
+	}
+	for _, name := range []string{"ec-sec1", "ec"} {
+		key, err = keyStore.GetPrivateKeyByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := key.(*ecdsa.PrivateKey); !ok {
+			t.Fatal("Expected ECDSA private key for", name)
+		}
+	}
+	key, err = keyStore.GetPrivateKeyByName("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Fatal("Expected Ed25519 private key")
+	}
+}
+
 func generateRSAKeyPairInDir(dir string, keyFileName string) error {
 	keyPair, err := generateRSAKeyPair()
 	if err != nil {
@@ -0,0 +1,184 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReloadingKeyStore is a directory-based KeyStore that reloads the keys from the directory
+// when Reload is called, or periodically if a scan interval is set.
+// The keys are swapped atomically, so the key store can be used while reloading.
+//
+// The default key is the key in the file named "default". To rotate the signing key,
+// replace the "default" file (or, if "default" is a symlink, point it to another key file).
+// After the rotation, the previous default key stays available for the grace period under
+// the name returned by PreviousKeyName, so tokens signed with it can still be verified
+// (for example by publishing it in the JSON Web Key Set).
+type ReloadingKeyStore struct {
+	// KeysDir is the directory holding the private keys.
+	KeysDir string
+
+	// GracePeriod is the period for which the previous default key is kept after a rotation.
+	GracePeriod time.Duration
+
+	mutex           sync.RWMutex
+	keys            *FileKeyStore
+	activeKeyName   string
+	previousKey     interface{}
+	previousKeyName string
+	previousExpires time.Time
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+// NewReloadingDirKeyStore creates a ReloadingKeyStore for the keys directory. The keys are
+// loaded immediately and an error is returned if the directory does not contain a "default" key.
+// If scanInterval is greater than 0, the directory is rescanned periodically in the background
+// until Stop is called.
+func NewReloadingDirKeyStore(keysDir string, scanInterval, gracePeriod time.Duration) (*ReloadingKeyStore, error) {
+	keyStore := &ReloadingKeyStore{
+		KeysDir:     keysDir,
+		GracePeriod: gracePeriod,
+		stop:        make(chan struct{}),
+	}
+	if err := keyStore.Reload(); err != nil {
+		return nil, err
+	}
+	if scanInterval > 0 {
+		go keyStore.scan(scanInterval)
+	}
+	return keyStore, nil
+}
+
+func (ks *ReloadingKeyStore) scan(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ks.Reload(); err != nil {
+				log.Println("Failed to reload keys from", ks.KeysDir, "; Keeping the current keys. Err:", err.Error())
+			}
+		case <-ks.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the periodic rescan of the keys directory.
+func (ks *ReloadingKeyStore) Stop() {
+	ks.stopOnce.Do(func() {
+		close(ks.stop)
+	})
+}
+
+// Reload loads the keys from the directory and replaces the current keys. If the keys cannot
+// be loaded, the current keys are kept and an error is returned. Unlike NewFileKeyStore, Reload
+// does not log the loaded keys; only a rotation of the default key is logged.
+func (ks *ReloadingKeyStore) Reload() error {
+	keyFiles, err := scanKeysDir(ks.KeysDir)
+	if err != nil {
+		return err
+	}
+	keys, err := loadFileKeyStore(keyFiles, false)
+	if err != nil {
+		return err
+	}
+	activeKeyName := ks.resolveActiveKeyName()
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	if ks.keys != nil && !reflect.DeepEqual(ks.keys.PrivateKey, keys.PrivateKey) {
+		previousKeyName := ks.activeKeyName
+		if key, ok := keys.KeysMap[previousKeyName]; ok && !reflect.DeepEqual(key, ks.keys.PrivateKey) {
+			// the name now holds another key (the "default" file was replaced)
+			previousKeyName = previousKeyName + ".previous"
+		}
+		ks.previousKey = ks.keys.PrivateKey
+		ks.previousKeyName = previousKeyName
+		ks.previousExpires = time.Now().Add(ks.GracePeriod)
+		log.Println("Signing key rotated from", ks.activeKeyName, "to", activeKeyName, "; previous key available as", previousKeyName, "until", ks.previousExpires)
+	}
+
+	ks.keys = keys
+	ks.activeKeyName = activeKeyName
+	return nil
+}
+
+// resolveActiveKeyName returns the name of the file holding the default key. If "default" is a
+// symlink, that is the name of the link target, otherwise it is "default".
+func (ks *ReloadingKeyStore) resolveActiveKeyName() string {
+	target, err := os.Readlink(path.Join(ks.KeysDir, "default"))
+	if err != nil {
+		return "default"
+	}
+	return filepath.Base(target)
+}
+
+// hasPreviousKey checks if the previous key is still within the grace period. Must be called
+// while holding the lock.
+func (ks *ReloadingKeyStore) hasPreviousKey() bool {
+	return ks.previousKey != nil && time.Now().Before(ks.previousExpires)
+}
+
+// GetPrivateKey returns the active default key.
+func (ks *ReloadingKeyStore) GetPrivateKey() (interface{}, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.keys.GetPrivateKey()
+}
+
+// GetPrivateKeyByName returns a private key by name. The previous default key is available
+// under PreviousKeyName during the grace period.
+func (ks *ReloadingKeyStore) GetPrivateKeyByName(keyName string) (interface{}, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	if key, ok := ks.keys.KeysMap[keyName]; ok {
+		return key, nil
+	}
+	if keyName == ks.previousKeyName && ks.hasPreviousKey() {
+		return ks.previousKey, nil
+	}
+	return nil, fmt.Errorf("no key with name %s loaded", keyName)
+}
+
+// KeyNames returns the sorted names of all available keys, including the previous default
+// key during the grace period.
+func (ks *ReloadingKeyStore) KeyNames() []string {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	names := ks.keys.KeyNames()
+	if ks.hasPreviousKey() {
+		if _, ok := ks.keys.KeysMap[ks.previousKeyName]; !ok {
+			names = append(names, ks.previousKeyName)
+			sort.Strings(names)
+		}
+	}
+	return names
+}
+
+// ActiveKeyName returns the name of the key file holding the active default key.
+func (ks *ReloadingKeyStore) ActiveKeyName() string {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.activeKeyName
+}
+
+// PreviousKeyName returns the name under which the previous default key is available. If there
+// was no rotation or the grace period has expired, an empty string is returned.
+func (ks *ReloadingKeyStore) PreviousKeyName() string {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	if !ks.hasPreviousKey() {
+		return ""
+	}
+	return ks.previousKeyName
+}
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadingKeyStoreRotation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmpDir)
+	}()
+
+	if err = generateRSAKeyPairInDir(tmpDir, "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	keyStore, err := NewReloadingDirKeyStore(tmpDir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyStore.Stop()
+
+	oldKey, err := keyStore.GetPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyStore.ActiveKeyName() != "default" {
+		t.Fatal("Expected active key to be default, got", keyStore.ActiveKeyName())
+	}
+	if keyStore.PreviousKeyName() != "" {
+		t.Fatal("Expected no previous key before rotation")
+	}
+
+	// reloading without changes must keep the key, without logging the keys
+	var logOutput bytes.Buffer
+	log.SetOutput(&logOutput)
+	err = keyStore.Reload()
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logOutput.Len() != 0 {
+		t.Fatal("Expected no log output on reload, got", logOutput.String())
+	}
+	if keyStore.PreviousKeyName() != "" {
+		t.Fatal("Expected no previous key when the keys did not change")
+	}
+
+	// rotate the default key
+	if err = generateRSAKeyPairInDir(tmpDir, "default"); err != nil {
+		t.Fatal(err)
+	}
+	if err = keyStore.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := keyStore.GetPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(oldKey, newKey) {
+		t.Fatal("Expected the default key to be rotated")
+	}
+	if keyStore.PreviousKeyName() != "default.previous" {
+		t.Fatal("Expected previous key name default.previous, got", keyStore.PreviousKeyName())
+	}
+	previousKey, err := keyStore.GetPrivateKeyByName("default.previous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(previousKey, oldKey) {
+		t.Fatal("Expected the previous key to be the old default key")
+	}
+	if !reflect.DeepEqual(keyStore.KeyNames(), []string{"default", "default.previous"}) {
+		t.Fatal("Unexpected key names", keyStore.KeyNames())
+	}
+
+	// a broken key file must not replace the loaded keys
+	if err = ioutil.WriteFile(path.Join(tmpDir, "default"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = keyStore.Reload(); err == nil {
+		t.Fatal("Expected reload to fail")
+	}
+	key, err := keyStore.GetPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key, newKey) {
+		t.Fatal("Expected the current key to be kept after failed reload")
+	}
+}
+
+func TestReloadingKeyStoreGracePeriod(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.RemoveAll(tmpDir)
+	}()
+
+	if err = generateRSAKeyPairInDir(tmpDir, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = generateRSAKeyPairInDir(tmpDir, "key-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("key-1", path.Join(tmpDir, "default")); err != nil {
+		t.Fatal(err)
+	}
+
+	keyStore, err := NewReloadingDirKeyStore(tmpDir, 10*time.Millisecond, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyStore.Stop()
+
+	if keyStore.ActiveKeyName() != "key-1" {
+		t.Fatal("Expected active key to be key-1, got", keyStore.ActiveKeyName())
+	}
+
+	// rotate by pointing the symlink to the other key and removing the old key
+	if err = os.Remove(path.Join(tmpDir, "default")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("key-2", path.Join(tmpDir, "default")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(path.Join(tmpDir, "key-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for keyStore.ActiveKeyName() != "key-2" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the key store to pick up the rotated key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if keyStore.PreviousKeyName() != "key-1" {
+		t.Fatal("Expected previous key name key-1, got", keyStore.PreviousKeyName())
+	}
+	if _, err = keyStore.GetPrivateKeyByName("key-1"); err != nil {
+		t.Fatal("Expected the previous key to be available during the grace period")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if keyStore.PreviousKeyName() != "" {
+		t.Fatal("Expected the previous key to expire after the grace period")
+	}
+	if _, err = keyStore.GetPrivateKeyByName("key-1"); err == nil {
+		t.Fatal("Expected the previous key to be unavailable after the grace period")
+	}
+}